@@ -1,172 +1,572 @@
 package backend
 
 import (
+	"bytes"
+	"sort"
+	"time"
+
 	"github.com/meowdada/go-fcache/cache"
 	"github.com/meowdada/go-fcache/codec"
 	"github.com/meowdada/go-fcache/pkg/ioutil"
+	"github.com/meowdada/go-fcache/pkg/log"
 )
 
 // Adapter creates an adapter for cache.DB.
-func Adapter(store Store, codec codec.Codec) cache.Pool {
-	return &adapter{
+func Adapter(store Store, codec codec.Codec, opts ...Option) cache.Pool {
+	ada := &adapter{
 		backend: store,
 		codec:   codec,
 		idgen:   newSnowflake(0),
+		logger:  log.Nop(),
+	}
+	for _, opt := range opts {
+		opt.setAdapterOption(ada)
 	}
+	return ada
 }
 
 type adapter struct {
-	backend Store
-	codec   codec.Codec
-	idgen   IDGenerator
+	backend    Store
+	codec      codec.Codec
+	idgen      IDGenerator
+	logger     log.Logger
+	strategy   CorruptStrategy
+	quarantine Store
+	pseudoTTL  time.Duration
+	onModify   func(old, new cache.Item)
 }
 
 func (ada *adapter) Iter(iterCb func(k string, v cache.Item) error) error {
+	return ada.iterate(ada.backend.Iter, iterCb)
+}
+
+func (ada *adapter) Scan(start string, iterCb func(k string, v cache.Item) error) error {
 	var (
 		b = ada.backend
 	)
-	return b.Iter(func(k, v []byte) error {
-		item := ada.mustParse(v)
-		return iterCb(ioutil.Bytes2Str(k), item)
+
+	if scanner, ok := b.(Scanner); ok {
+		return ada.iterate(func(fn func(k, v []byte) error) error {
+			return scanner.Scan(ioutil.Str2Bytes(start), fn)
+		}, iterCb)
+	}
+
+	// The backend is unable to scan in order, so collects all the
+	// key-value pairs and sort them. Note that key-value pairs must
+	// be copied since they might be only valid during iteration.
+	var pairs []pair
+	err := b.Iter(func(k, v []byte) error {
+		if string(k) >= start {
+			pairs = append(pairs, newPair(k, v))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].k, pairs[j].k) < 0
 	})
+	return ada.iterate(func(fn func(k, v []byte) error) error {
+		for _, p := range pairs {
+			if err := fn(p.k, p.v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, iterCb)
 }
 
-func (ada *adapter) Put(key string, size int64) error {
-	var (
-		b = ada.backend
-		k = ioutil.Str2Bytes(key)
-	)
+type pair struct {
+	k, v []byte
+}
 
-	// If the key does not present. Create a new item and
-	// insert it into the backend.
-	v, err := b.Get(k)
-	if IsNoKeyError(err) {
-		return b.Put(k, ada.newMarshaledItem(key, size))
+func newPair(k, v []byte) pair {
+	return pair{
+		k: append([]byte(nil), k...),
+		v: append([]byte(nil), v...),
 	}
+}
+
+func (ada *adapter) Put(key string, size int64) error {
+	return ada.PutWithMetadata(key, size, nil)
+}
 
-	// Deserialize the stored data.
-	item := ada.mustParse(v)
+func (ada *adapter) PutWithMetadata(key string, size int64, md cache.Metadata) error {
+	return ada.modify([]string{key}, func(key string, item *cache.Item, found bool) (bool, error) {
+		// If the key does not present. Create a new item and
+		// insert it into the backend.
+		if !found {
+			*item = cache.New(ada.idgen.Get(), key, size)
+			if len(md) > 0 {
+				item.Metadata = item.Metadata.Merge(md)
+			}
+			return true, nil
+		}
+
+		// If it is a psudo item, then convert it into
+		// a real one.
+		if !item.IsReal() {
+			item.SetReal()
+			item.SetSize(size)
+			item.UpdateCreatedAt()
+			if len(md) > 0 {
+				item.Metadata = item.Metadata.Merge(md)
+			}
+			return true, nil
+		}
+
+		return false, ErrDupKey
+	})
+}
+
+func (ada *adapter) Annotate(key string, md cache.Metadata) error {
+	return ada.modify([]string{key}, func(key string, item *cache.Item, found bool) (bool, error) {
+		if !found {
+			return false, cache.ErrNoSuchKey
+		}
+		item.Metadata = item.Metadata.Merge(md)
+		return true, nil
+	})
+}
 
-	// If it is a psudo item, then convert it into
-	// a real one.
-	if !item.IsReal() {
-		item.SetReal()
+func (ada *adapter) Replace(key string, size int64, md cache.Metadata) error {
+	return ada.modify([]string{key}, func(key string, item *cache.Item, found bool) (bool, error) {
+		if !found {
+			return false, cache.ErrNoSuchKey
+		}
 		item.SetSize(size)
 		item.UpdateCreatedAt()
-		v = ada.mustMarshalItem(item)
-		return b.Put(k, v)
-	}
-
-	return ErrDupKey
+		item.Metadata = item.Metadata.Merge(md)
+		return true, nil
+	})
 }
 
 func (ada *adapter) Get(key string) (cache.Item, error) {
 	var (
-		b = ada.backend
 		k = ioutil.Str2Bytes(key)
 	)
-
-	v, err := b.Get(k)
-	if err != nil {
-		return cache.Item{}, err
-	}
-
-	item := ada.mustParse(v)
-	return item, nil
+	return ada.load(k)
 }
 
 func (ada *adapter) Remove(key string) error {
-	var (
-		b = ada.backend
-		k = ioutil.Str2Bytes(key)
-	)
-	return b.Remove(k)
+	return ada.backend.Remove(ioutil.Str2Bytes(key))
 }
 
-func (ada *adapter) IncrRef(keys ...string) error {
+func (ada *adapter) BatchRemove(keys ...string) error {
 	var (
 		b = ada.backend
 	)
 
-	for _, key := range keys {
-		k := ioutil.Str2Bytes(key)
-		v, err := b.Get(k)
-
-		// If the key presents, then update this cache item.
-		if err == nil {
-			item := ada.mustParse(v)
-			item.IncrRef()
-			item.IncrUsed()
-			item.UpdateLastUsed()
-
-			if err := b.Put(k, ada.mustMarshalItem(item)); err != nil {
-				return err
+	if batcher, ok := b.(Batcher); ok && len(keys) > 1 {
+		return batcher.Batch(func(txn Txn) error {
+			for _, key := range keys {
+				if err := txn.Remove(ioutil.Str2Bytes(key)); err != nil {
+					return err
+				}
 			}
-			continue
+			return nil
+		})
+	}
+
+	for _, key := range keys {
+		if err := b.Remove(ioutil.Str2Bytes(key)); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
+func (ada *adapter) IncrRef(keys ...string) error {
+	return ada.modifyRef(keys, func(key string, item *cache.Item, found bool) (bool, error) {
 		// If the key does not present, then create a dummy one.
-		id := ada.idgen.Get()
-		item := cache.Dummy(id, key)
+		if !found {
+			*item = cache.Dummy(ada.idgen.Get(), key)
+		}
 		item.IncrRef()
 		item.IncrUsed()
 		item.UpdateLastUsed()
+		return true, nil
+	})
+}
 
-		err = b.Put(k, ada.mustMarshalItem(item))
-		if err != nil {
-			return err
+func (ada *adapter) DecrRef(keys ...string) error {
+	return ada.modifyRef(keys, func(key string, item *cache.Item, found bool) (bool, error) {
+		// If the key does not present, then ignore it.
+		if !found {
+			return false, nil
+		}
+		if item.Reference() > 0 {
+			item.DecrRef()
 		}
+		return true, nil
+	})
+}
+
+func (ada *adapter) Close() error {
+	return ada.backend.Close()
+}
+
+// load gets and decodes a cache item from the backend. A corrupted record
+// will be handled according to the corrupt strategy.
+func (ada *adapter) load(k []byte) (cache.Item, error) {
+	v, err := ada.backend.Get(k)
+	if err != nil {
+		return cache.Item{}, err
 	}
-	return nil
+	item, err := ada.parse(k, v)
+	if err != nil {
+		return cache.Item{}, ada.corrupted(newPair(k, v), err)
+	}
+	return item, nil
 }
 
-func (ada *adapter) DecrRef(keys ...string) error {
-	var (
-		b = ada.backend
-	)
+// modifyFunc modifies a cache item. found is false if the key does not
+// present, and it returns false to leave the record untouched.
+type modifyFunc func(key string, item *cache.Item, found bool) (bool, error)
 
-	for _, key := range keys {
-		k := ioutil.Str2Bytes(key)
-		v, err := b.Get(k)
+// modify modifies cache items of given keys by fn. If the backend implements
+// Batcher, all of them are modified atomically. Otherwise if it implements
+// Updater, each of them is modified atomically. Otherwise, concurrent
+// modifications of the same key might be lost. A single key prefers Updater
+// since a batch might be more expensive, such as locking the whole store.
+func (ada *adapter) modify(keys []string, fn modifyFunc) error {
+	return ada.modifyWith(keys, fn, nil)
+}
 
-		// If the key does not present, then ignore it.
-		if err == cache.ErrNoSuchKey {
-			continue
+// modified is a cache item which has been modified and written.
+type modified struct {
+	key string
+
+	// value is the exact value written.
+	value []byte
+
+	// old is the cache item before the modification, which is zero if the
+	// key did not present. new is the one written.
+	old, new cache.Item
+}
+
+// modifyWith is like modify, but passes every cache item written to done
+// once it has been written, along with the observer set by WithOnModify.
+func (ada *adapter) modifyWith(keys []string, fn modifyFunc, done func(m modified)) error {
+	// results are the cache items modified but not yet committed. A key
+	// modified more than once before committed, which happens if it appears
+	// more than once in a batch, keeps the oldest one as old. They must be
+	// discarded if the backend retries.
+	results := make(map[string]modified, len(keys))
+	track := func(key string, item *cache.Item, found bool) (bool, error) {
+		var old cache.Item
+		if found {
+			old = *item
+		}
+		write, err := fn(key, item, found)
+		if write && err == nil {
+			if prev, ok := results[key]; ok {
+				old = prev.old
+			}
+			results[key] = modified{key: key, old: old, new: *item}
+		}
+		return write, err
+	}
+	commit := func(key string, v []byte) {
+		m, ok := results[key]
+		if !ok {
+			return
+		}
+		delete(results, key)
+		m.value = v
+		if ada.onModify != nil {
+			ada.onModify(m.old, m.new)
 		}
-		if err != nil && err != cache.ErrNoSuchKey {
+		if done != nil {
+			done(m)
+		}
+	}
+
+	batcher, canBatch := ada.backend.(Batcher)
+	updater, canUpdate := ada.backend.(Updater)
+	if canBatch && (len(keys) > 1 || !canUpdate) {
+		var (
+			c       corruption
+			written = make(map[string][]byte, len(keys))
+			order   []string
+		)
+		err := batcher.Batch(func(txn Txn) error {
+			order = order[:0]
+			for key := range written {
+				delete(written, key)
+			}
+			for key := range results {
+				delete(results, key)
+			}
+			for _, key := range keys {
+				k := ioutil.Str2Bytes(key)
+				old, err := txn.Get(k)
+				if err != nil && !IsNoKeyError(err) {
+					return err
+				}
+				v, err := ada.rewrite(&c, key, old, track)
+				if err != nil {
+					return err
+				}
+				if v == nil {
+					continue
+				}
+				if err := txn.Put(k, v); err != nil {
+					return err
+				}
+				if _, ok := written[key]; !ok {
+					order = append(order, key)
+				}
+				written[key] = v
+			}
+			return nil
+		})
+		if err = ada.settle(c, err); err != nil {
 			return err
 		}
+		for _, key := range order {
+			commit(key, written[key])
+		}
+		return nil
+	}
 
-		item := ada.mustParse(v)
-		if item.Reference() > 0 {
-			item.DecrRef()
+	if canUpdate {
+		for _, key := range keys {
+			var (
+				c corruption
+				v []byte
+			)
+			err := updater.Update(ioutil.Str2Bytes(key), func(old []byte) ([]byte, error) {
+				delete(results, key)
+				var err error
+				v, err = ada.rewrite(&c, key, old, track)
+				return v, err
+			})
+			if err := ada.settle(c, err); err != nil {
+				return err
+			}
+			if v != nil {
+				commit(key, v)
+			}
 		}
+		return nil
+	}
 
-		err = b.Put(k, ada.mustMarshalItem(item))
+	for _, key := range keys {
+		k := ioutil.Str2Bytes(key)
+		item, err := ada.load(k)
+		found := err == nil
+		if IsNoKeyError(err) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		write, err := track(key, &item, found)
+		if err != nil {
+			return err
+		}
+		if !write {
+			continue
+		}
+		v, err := ada.marshal(item)
 		if err != nil {
 			return err
 		}
+		if err := ada.backend.Put(k, v); err != nil {
+			return err
+		}
+		commit(key, v)
 	}
 	return nil
 }
 
-func (ada *adapter) Close() error {
-	return ada.backend.Close()
+// modifyRef modifies references of cache items by fn like modify. If the
+// pseudo TTL is set and the backend implements Expirer, pseudo items written
+// are made expire all at once, since nothing else removes the ones never put.
+// Expirer compares the exact bytes stored, so the values written are passed
+// rather than encoded again, which differ for codecs such as encrypted ones.
+// Failures of expiring are logged but not returned, since the items have
+// been written.
+func (ada *adapter) modifyRef(keys []string, fn modifyFunc) error {
+	expirer, ok := ada.backend.(Expirer)
+	if !ok || ada.pseudoTTL <= 0 {
+		return ada.modify(keys, fn)
+	}
+
+	var ks, vs [][]byte
+	err := ada.modifyWith(keys, fn, func(m modified) {
+		if !m.new.IsReal() {
+			ks = append(ks, ioutil.Str2Bytes(m.key))
+			vs = append(vs, m.value)
+		}
+	})
+	if err != nil || len(ks) == 0 {
+		return err
+	}
+	if err := expirer.ExpireAll(ks, vs, ada.pseudoTTL); err != nil {
+		ada.logger.Log(log.Warn, "failed to expire pseudo cache items", log.F("count", len(ks)), log.Err(err))
+	}
+	return nil
 }
 
-func (ada *adapter) mustParse(data []byte) cache.Item {
-	var item cache.Item
-	ada.codec.Unmarshal(data, &item)
-	return item
+// expired decodes an expired record and passes it to fn.
+func (ada *adapter) expired(fn func(item cache.Item)) func(k, v []byte) {
+	return func(k, v []byte) {
+		item, err := ada.parse(k, v)
+		if err != nil {
+			ada.logger.Log(log.Warn, "skip corrupted expired cache item", log.F("key", string(k)), log.Err(err))
+			return
+		}
+		fn(item)
+	}
+}
+
+// corruption records corrupted records met during a modification, and
+// whether they have been replaced or not.
+type corruption struct {
+	pairs    []pair
+	replaced []bool
 }
 
-func (ada *adapter) mustMarshalItem(item cache.Item) []byte {
-	data, _ := ada.codec.Marshal(item)
-	return data
+// rewrite decodes the old value, modifies it by fn and encodes it again. It
+// returns a nil value if nothing should be written. A corrupted record is
+// treated as missing unless the strategy is to fail, and the quarantine is
+// deferred until the modification is done since the backend might not allow
+// other operations during the modification.
+func (ada *adapter) rewrite(c *corruption, key string, old []byte, fn modifyFunc) ([]byte, error) {
+	var (
+		k         = ioutil.Str2Bytes(key)
+		item      cache.Item
+		found     = old != nil
+		corrupted bool
+	)
+	if found {
+		parsed, err := ada.parse(k, old)
+		if err != nil && ada.strategy == FailOnCorrupt {
+			return nil, ada.corrupted(pair{k, old}, err)
+		}
+		if err != nil {
+			ada.logger.Log(log.Warn, "replace corrupted cache item", log.F("key", key), log.Err(err))
+			corrupted, found = true, false
+		}
+		item = parsed
+	}
+
+	write, err := fn(key, &item, found)
+	if err != nil {
+		return nil, err
+	}
+	var v []byte
+	if write {
+		if v, err = ada.marshal(item); err != nil {
+			return nil, err
+		}
+	}
+	if corrupted {
+		c.pairs = append(c.pairs, newPair(k, old))
+		c.replaced = append(c.replaced, v != nil)
+	}
+	return v, nil
 }
 
-func (ada *adapter) newMarshaledItem(key string, size int64) []byte {
-	id := ada.idgen.Get()
-	item := cache.New(id, key, size)
-	return ada.mustMarshalItem(item)
+// settle quarantines corrupted records met during a successful modification.
+// A corrupted record which has not been replaced is removed from the backend
+// as well.
+func (ada *adapter) settle(c corruption, err error) error {
+	if err != nil || ada.strategy != QuarantineCorrupt {
+		return err
+	}
+	for i, p := range c.pairs {
+		var qerr error
+		if !c.replaced[i] {
+			qerr = quarantine(ada.backend, ada.quarantine, p)
+		} else if ada.quarantine != nil {
+			qerr = ada.quarantine.Put(p.k, p.v)
+		}
+		if qerr != nil {
+			ada.logger.Log(log.Error, "failed to quarantine corrupted cache item",
+				log.F("key", string(p.k)), log.Err(qerr))
+			if err == nil {
+				err = qerr
+			}
+		}
+	}
+	return err
+}
+
+// iterate decodes key-value pairs iterated by iter and passes them to iterCb.
+// Corrupted records are handled according to the corrupt strategy, but the
+// quarantine is deferred until the iteration ends since the backend might not
+// allow modifications during iteration.
+func (ada *adapter) iterate(iter func(fn func(k, v []byte) error) error, iterCb func(k string, v cache.Item) error) error {
+	var (
+		corrupted []pair
+		errs      []error
+	)
+	err := iter(func(k, v []byte) error {
+		item, err := ada.parse(k, v)
+		if err == nil {
+			// Keys are copied since callers might keep them, but stores
+			// might reuse their buffers once the callback returns.
+			return iterCb(string(k), item)
+		}
+		switch ada.strategy {
+		case SkipCorrupt:
+			ada.corrupted(pair{k, v}, err)
+			return nil
+		case QuarantineCorrupt:
+			corrupted = append(corrupted, newPair(k, v))
+			errs = append(errs, err)
+			return nil
+		}
+		return ada.corrupted(pair{k, v}, err)
+	})
+
+	for i, p := range corrupted {
+		if qerr := ada.corrupted(p, errs[i]); !IsNoKeyError(qerr) && err == nil {
+			err = qerr
+		}
+	}
+	return err
+}
+
+// corrupted handles a corrupted record. If the record is skipped or
+// quarantined, it returns cache.ErrNoSuchKey as if it does not present.
+func (ada *adapter) corrupted(p pair, err error) error {
+	key := string(p.k)
+	switch ada.strategy {
+	case SkipCorrupt:
+		ada.logger.Log(log.Warn, "skip corrupted cache item", log.F("key", key), log.Err(err))
+		return cache.ErrNoSuchKey
+	case QuarantineCorrupt:
+		if qerr := quarantine(ada.backend, ada.quarantine, p); qerr != nil {
+			ada.logger.Log(log.Error, "failed to quarantine corrupted cache item",
+				log.F("key", key), log.Err(qerr))
+			return qerr
+		}
+		ada.logger.Log(log.Warn, "quarantine corrupted cache item", log.F("key", key), log.Err(err))
+		return cache.ErrNoSuchKey
+	}
+	ada.logger.Log(log.Error, "failed to decode cache item", log.F("key", key), log.Err(err))
+	return err
+}
+
+func (ada *adapter) parse(k, data []byte) (cache.Item, error) {
+	var item cache.Item
+	if err := ada.codec.Unmarshal(data, &item); err != nil {
+		return cache.Item{}, &DecodeError{Key: string(k), Err: err}
+	}
+	return item, nil
+}
+
+func (ada *adapter) marshal(item cache.Item) ([]byte, error) {
+	data, err := ada.codec.Marshal(item)
+	if err != nil {
+		ada.logger.Log(log.Error, "failed to encode cache item",
+			log.F("key", item.Key), log.Err(err))
+		return nil, &EncodeError{Key: item.Key, Err: err}
+	}
+	return data, nil
 }
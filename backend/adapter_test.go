@@ -1,7 +1,11 @@
 package backend
 
 import (
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/meowdada/go-fcache/backend/gomap"
 	"github.com/meowdada/go-fcache/cache"
@@ -48,6 +52,58 @@ func TestAdapterIter(t *testing.T) {
 	}
 }
 
+func TestAdapterScan(t *testing.T) {
+	testcases := []struct {
+		description string
+		store       Store
+	}{
+		{"scanner", gomap.New()},
+		{"fallback", unorderedStore{gomap.New()}},
+	}
+
+	for idx, tc := range testcases {
+		ada := Adapter(tc.store, codec.Gob{})
+		for _, k := range []string{"c", "a", "b", "ab"} {
+			if err := ada.Put(k, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var keys []string
+		err := ada.(cache.Scanner).Scan("aa", func(k string, v cache.Item) error {
+			if k != v.Key {
+				t.Errorf("[#Case %d] %s: expect %v, but get %v", idx, tc.description, k, v.Key)
+			}
+			keys = append(keys, k)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(keys) != "[ab b c]" {
+			t.Errorf("[#Case %d] %s: expect %v, but get %v", idx, tc.description, "[ab b c]", keys)
+		}
+
+		err = ada.(cache.Scanner).Scan("", func(k string, v cache.Item) error { return errMock })
+		if err != errMock {
+			t.Errorf("[#Case %d] %s: expect %v, but get %v", idx, tc.description, errMock, err)
+		}
+	}
+
+	ada := Adapter(Mock{
+		IterHandler: func(func(k, v []byte) error) error { return errMock },
+	}, codec.Gob{})
+	err := ada.(cache.Scanner).Scan("", func(k string, v cache.Item) error { return nil })
+	if err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+// unorderedStore hides the Scan method of the underlying store.
+type unorderedStore struct {
+	Store
+}
+
 func TestAdapterPut(t *testing.T) {
 	testcases := []struct {
 		description string
@@ -135,6 +191,120 @@ func TestAdapterGet(t *testing.T) {
 	}
 }
 
+func TestAdapterAnnotate(t *testing.T) {
+	stores := []Store{gomap.New(), unorderedStore{gomap.New()}}
+	for idx, store := range stores {
+		ada := Adapter(store, codec.Gob{})
+		annotator := ada.(cache.Annotator)
+		if err := annotator.Annotate("123", cache.Metadata{"a": "1"}); err != cache.ErrNoSuchKey {
+			t.Errorf("[#Case %d]: expect %v, but get %v", idx, cache.ErrNoSuchKey, err)
+		}
+		if err := ada.Put("123", 456); err != nil {
+			t.Fatal(err)
+		}
+		if err := annotator.Annotate("123", cache.Metadata{"a": "1", "b": "2"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := annotator.Annotate("123", cache.Metadata{"a": "", "c": "3"}); err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := ada.Get("123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := cache.Metadata{"b": "2", "c": "3"}
+		if !reflect.DeepEqual(item.Metadata, expect) {
+			t.Errorf("[#Case %d]: expect %v, but get %v", idx, expect, item.Metadata)
+		}
+		if item.Size != 456 || !item.IsReal() {
+			t.Errorf("[#Case %d]: expect other fields untouched, but get %v", idx, item)
+		}
+	}
+}
+
+func TestAdapterPutWithMetadata(t *testing.T) {
+	var writes int
+	ada := Adapter(gomap.New(), codec.Gob{}, WithOnModify(func(old, new cache.Item) {
+		writes++
+	}))
+	annotator := ada.(cache.Annotator)
+	if err := ada.IncrRef("pseudo"); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		description string
+		key         string
+		expectErr   error
+	}{
+		{"new item", "new", nil},
+		{"pseudo item", "pseudo", nil},
+		{"duplicated item", "new", ErrDupKey},
+	}
+
+	for idx, tc := range testcases {
+		writes = 0
+		md := cache.Metadata{"a": "1", "b": ""}
+		err := annotator.PutWithMetadata(tc.key, 10, md)
+		if err != tc.expectErr {
+			t.Errorf("[#Case %d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		// The item is written with its metadata at once.
+		item, err := ada.Get(tc.key)
+		expect := cache.Metadata{"a": "1"}
+		if err != nil || !item.IsReal() || !reflect.DeepEqual(item.Metadata, expect) {
+			t.Errorf("[#Case %d] %s: expect a real item with %v, but get %v, %v", idx, tc.description, expect, item, err)
+		}
+		if writes != 1 {
+			t.Errorf("[#Case %d] %s: expect 1 write, but get %d", idx, tc.description, writes)
+		}
+	}
+}
+
+func TestAdapterReplace(t *testing.T) {
+	stores := []Store{gomap.New(), unorderedStore{gomap.New()}}
+	for idx, store := range stores {
+		ada := Adapter(store, codec.Gob{})
+		replacer := ada.(cache.Replacer)
+		if err := replacer.Replace("123", 10, nil); err != cache.ErrNoSuchKey {
+			t.Errorf("[#Case %d]: expect %v, but get %v", idx, cache.ErrNoSuchKey, err)
+		}
+		if err := ada.Put("123", 456); err != nil {
+			t.Fatal(err)
+		}
+		if err := ada.(cache.Annotator).Annotate("123", cache.Metadata{"a": "1", "b": "2"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ada.IncrRef("123"); err != nil {
+			t.Fatal(err)
+		}
+		old, err := ada.Get("123")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := replacer.Replace("123", 789, cache.Metadata{"a": "", "c": "3"}); err != nil {
+			t.Fatal(err)
+		}
+		item, err := ada.Get("123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := cache.Metadata{"b": "2", "c": "3"}
+		if !reflect.DeepEqual(item.Metadata, expect) {
+			t.Errorf("[#Case %d]: expect %v, but get %v", idx, expect, item.Metadata)
+		}
+		if item.Size != 789 || item.ID != old.ID || item.Reference() != 1 || item.CTime().Before(old.CTime()) {
+			t.Errorf("[#Case %d]: expect size replaced and others kept, but get %v from %v", idx, item, old)
+		}
+	}
+}
+
 func TestAdapterRemove(t *testing.T) {
 	testcases := []struct {
 		description string
@@ -329,6 +499,219 @@ func TestAdapterDecrRef(t *testing.T) {
 	}
 }
 
+func TestAdapterConcurrentRef(t *testing.T) {
+	const n = 50
+
+	// Adapters sharing the same store must not lose references of
+	// each other.
+	store := gomap.New()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		ada := Adapter(store, codec.Gob{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if err := ada.IncrRef("key"); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	item, err := Adapter(store, codec.Gob{}).Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Reference() != 2*n {
+		t.Errorf("expect %v, but get %v", 2*n, item.Reference())
+	}
+}
+
+func TestAdapterBatch(t *testing.T) {
+	testcases := []struct {
+		description string
+		store       func() Store
+	}{
+		{"batcher", func() Store { return newCorruptedStore() }},
+		{"updater", func() Store { return updaterStore{newCorruptedStore()} }},
+		{"fallback", func() Store { return unorderedStore{newCorruptedStore()} }},
+	}
+
+	for idx, tc := range testcases {
+		store := tc.store()
+		ada := Adapter(store, codec.Gob{})
+		if err := ada.IncrRef("a", "b", "a"); err != nil {
+			t.Fatal(err)
+		}
+		item, err := ada.Get("a")
+		if err != nil || item.Reference() != 2 {
+			t.Errorf("[#Case%d] %s: expect 2 references, but get %v, %v", idx, tc.description, item, err)
+		}
+		if err := ada.DecrRef("a", "missing"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ada.(cache.BatchRemover).BatchRemove("b", "missing", "good"); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"b", "missing", "good"} {
+			if _, err := ada.Get(key); err != cache.ErrNoSuchKey {
+				t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, cache.ErrNoSuchKey, err)
+			}
+		}
+
+		// Only a batch is all-or-nothing when meeting a corrupted record.
+		err = ada.IncrRef("a", "bad")
+		if _, ok := err.(*DecodeError); !ok {
+			t.Errorf("[#Case%d] %s: expect a decode error, but get %v", idx, tc.description, err)
+		}
+		item, _ = ada.Get("a")
+		expect := 2
+		if tc.description == "batcher" {
+			expect = 1
+		}
+		if item.Reference() != expect {
+			t.Errorf("[#Case%d] %s: expect %v references, but get %v", idx, tc.description, expect, item.Reference())
+		}
+	}
+
+	ada := Adapter(Mock{
+		RmHandler: func(k []byte) error { return errMock },
+	}, codec.Gob{})
+	if err := ada.(cache.BatchRemover).BatchRemove("a", "b"); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+// updaterStore hides the Batch method of the underlying store.
+type updaterStore struct {
+	*gomap.Map
+}
+
+func (s updaterStore) Batch() {}
+
+func TestAdapterOnModify(t *testing.T) {
+	testcases := []struct {
+		description string
+		store       func() Store
+		expectBad   int
+	}{
+		{"batcher", func() Store { return newCorruptedStore() }, 0},
+		{"updater", func() Store { return updaterStore{newCorruptedStore()} }, 1},
+		{"fallback", func() Store { return unorderedStore{newCorruptedStore()} }, 1},
+	}
+
+	for idx, tc := range testcases {
+		var mods []cache.Item
+		ada := Adapter(tc.store(), codec.Gob{}, WithOnModify(func(old, new cache.Item) {
+			mods = append(mods, old, new)
+		}))
+		if err := ada.IncrRef("a", "b", "a"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Replaying the modifications leads to the same references, each of
+		// which starts from the one reported before.
+		refs := make(map[string]int)
+		for i := 0; i < len(mods); i += 2 {
+			old, new := mods[i], mods[i+1]
+			if old.Reference() != refs[new.Key] {
+				t.Errorf("[#Case%d] %s: expect %s from %d references, but get %v", idx, tc.description, new.Key, refs[new.Key], old)
+			}
+			refs[new.Key] = new.Reference()
+		}
+		expect := map[string]int{"a": 2, "b": 1}
+		if !reflect.DeepEqual(refs, expect) {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, expect, refs)
+		}
+
+		// Nothing is reported for a rolled back batch.
+		mods = nil
+		ada.IncrRef("a", "bad")
+		if len(mods) != tc.expectBad*2 {
+			t.Errorf("[#Case%d] %s: expect %d modifications, but get %v", idx, tc.description, tc.expectBad, mods)
+		}
+	}
+}
+
+func TestAdapterPseudoTTL(t *testing.T) {
+	store := &expiryStore{Map: gomap.New()}
+	defer store.Close()
+	ada := Adapter(store, codec.Gob{}, WithPseudoTTL(time.Hour, nil))
+	if err := ada.Put("real", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		description string
+		fn          func() error
+		expect      []string
+	}{
+		{"register pseudo items", func() error { return ada.IncrRef("a", "b", "real") }, []string{"a", "b"}},
+		{"unregister pseudo items", func() error { return ada.DecrRef("a", "b") }, []string{"a", "b"}},
+		{"register a real item", func() error { return ada.IncrRef("real") }, nil},
+	}
+
+	for idx, tc := range testcases {
+		store.calls = nil
+		if err := tc.fn(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Pseudo items are made expire with a single call.
+		var expect [][]string
+		if tc.expect != nil {
+			expect = [][]string{tc.expect}
+		}
+		if !reflect.DeepEqual(store.calls, expect) {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, expect, store.calls)
+		}
+	}
+}
+
+// expiryStore records the keys of every ExpireAll call.
+type expiryStore struct {
+	*gomap.Map
+	calls [][]string
+}
+
+func (s *expiryStore) ExpireAll(ks, vs [][]byte, ttl time.Duration) error {
+	var keys []string
+	for _, k := range ks {
+		keys = append(keys, string(k))
+	}
+	s.calls = append(s.calls, keys)
+	return s.Map.ExpireAll(ks, vs, ttl)
+}
+
+func TestAdapterModifyFallback(t *testing.T) {
+	ada := Adapter(unorderedStore{gomap.New()}, codec.Gob{})
+	if err := ada.IncrRef("key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ada.Put("key", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := ada.Put("key", 10); err != ErrDupKey {
+		t.Errorf("expect %v, but get %v", ErrDupKey, err)
+	}
+	if err := ada.DecrRef("key", "missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := ada.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.IsReal() || item.Size != 10 || item.Reference() != 0 {
+		t.Errorf("expect a real item of size 10 without references, but get %v", item)
+	}
+	if _, err := ada.Get("missing"); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+}
+
 func TestAdapterClose(t *testing.T) {
 	ada := Adapter(gomap.New(), codec.Gob{})
 	err := ada.Close()
@@ -1,11 +1,15 @@
 package backend
 
 import (
+	"time"
+
 	"github.com/meowdada/go-fcache/cache"
 )
 
 // Store is a storage backend interface which provides some
-// methods to interacts with the item.
+// methods to interacts with the item. Callers may reuse the buffers
+// of keys and values once a method returns, so a store must copy
+// them if it keeps them.
 type Store interface {
 	Put(k, v []byte) error
 	Get(k []byte) (v []byte, e error)
@@ -14,6 +18,54 @@ type Store interface {
 	Close() error
 }
 
+// Scanner is an optional interface of Store. It iterates key-value pairs in
+// ascending order of keys, starting from the first key which is equal to or
+// greater than start. Like Iter, an error should be raised once error occurs
+// in iteration callback.
+type Scanner interface {
+	Scan(start []byte, iterCb func(k, v []byte) error) error
+}
+
+// Updater is an optional interface of Store. It reads, modifies and writes a
+// key-value pair atomically. The old value passed to fn is nil if the key does
+// not present. If fn returns an error, the update is aborted and the error is
+// returned. If fn returns a nil value, nothing will be written.
+type Updater interface {
+	Update(k []byte, fn func(old []byte) ([]byte, error)) error
+}
+
+// Txn reads and writes key-value pairs within a batch. Get should return
+// cache.ErrNoSuchKey if the key does not present, and it should see the
+// writes made earlier in the same batch. It is an alias of an interface
+// literal, so a store could declare the same one and implement Batcher
+// without importing this package.
+type Txn = interface {
+	Get(k []byte) (v []byte, e error)
+	Put(k, v []byte) error
+	Remove(k []byte) error
+}
+
+// Batcher is an optional interface of Store. It applies all the writes made
+// by fn atomically, usually within a single transaction. If fn returns an
+// error, none of the writes will be applied and the error is returned.
+type Batcher interface {
+	Batch(fn func(txn Txn) error) error
+}
+
+// Expirer is an optional interface of Store. Expire makes the key-value pair
+// expire after ttl, only if the value of k is still v, so a pair which has
+// been changed since v was written is never expired by mistake. It does
+// nothing if the key does not present, and a non-positive ttl clears the
+// expiry. Any write of the key clears its expiry as well. Expired pairs are
+// removed in the background, and fn given to NotifyExpired is invoked with
+// each of them after they are removed. ExpireAll is like Expire, but applies
+// to pairs of ks and vs at once, usually within a single transaction.
+type Expirer interface {
+	Expire(k, v []byte, ttl time.Duration) error
+	ExpireAll(ks, vs [][]byte, ttl time.Duration) error
+	NotifyExpired(fn func(k, v []byte))
+}
+
 // IsNoKeyError returns true if the key reprsents ErrNoSuchKey.
 func IsNoKeyError(err error) bool {
 	return err == cache.ErrNoSuchKey
@@ -0,0 +1,505 @@
+// Package backendtest provides a conformance test suite for implementations
+// of backend.Store, so that third-party backends are able to prove they
+// behave as the adapter expects.
+//
+//	func TestStore(t *testing.T) {
+//		backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+//			return mystore.New()
+//		})
+//	}
+//
+// Optional interfaces, such as backend.Scanner, backend.Updater and
+// backend.Batcher, are tested as well if the store implements them. Run the
+// suite with -race to detect data races under concurrent access.
+package backendtest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+// Factory creates a new and empty store for a test. Resources held by the
+// store other than the store itself, such as temporary files, should be
+// released with t.Cleanup. The suite closes the store when a test ends.
+type Factory func(t *testing.T) backend.Store
+
+// Option configures the suite.
+type Option interface {
+	setSuiteOption(s *suite)
+}
+
+type withKeys struct {
+	fn func(store backend.Store, name string) []byte
+}
+
+func (w withKeys) setSuiteOption(s *suite) {
+	s.key = w.fn
+}
+
+// WithKeys returns a suite option which maps names used by the suite into
+// keys of the store. It is useful for stores whose keys must be paths of
+// existing files. Keys must keep the order of names, and Iter must report
+// the mapped keys.
+func WithKeys(fn func(store backend.Store, name string) []byte) Option {
+	return withKeys{fn}
+}
+
+type suite struct {
+	factory Factory
+	key     func(store backend.Store, name string) []byte
+}
+
+var errMock = errors.New("mock error")
+
+// RunStoreTests runs the conformance test suite against stores created by
+// factory, each subtest with a new store.
+func RunStoreTests(t *testing.T, factory Factory, opts ...Option) {
+	s := &suite{
+		factory: factory,
+		key: func(store backend.Store, name string) []byte {
+			return []byte(name)
+		},
+	}
+	for _, opt := range opts {
+		opt.setSuiteOption(s)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store backend.Store)
+	}{
+		{"PutGet", s.testPutGet},
+		{"Overwrite", s.testOverwrite},
+		{"Reuse", s.testReuse},
+		{"Miss", s.testMiss},
+		{"Remove", s.testRemove},
+		{"Iter", s.testIter},
+		{"IterError", s.testIterError},
+		{"Scan", s.testScan},
+		{"Update", s.testUpdate},
+		{"Batch", s.testBatch},
+		{"Concurrent", s.testConcurrent},
+		{"Close", s.testClose},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := s.factory(t)
+			if tc.name != "Close" {
+				defer store.Close()
+			}
+			tc.fn(t, store)
+		})
+	}
+}
+
+func (s *suite) put(t *testing.T, store backend.Store, name, v string) {
+	t.Helper()
+	if err := store.Put(s.key(store, name), []byte(v)); err != nil {
+		t.Fatalf("Put(%q): expect no error, but get %v", name, err)
+	}
+}
+
+func (s *suite) expect(t *testing.T, store backend.Store, name, v string) {
+	t.Helper()
+	got, err := store.Get(s.key(store, name))
+	if err != nil {
+		t.Errorf("Get(%q): expect no error, but get %v", name, err)
+		return
+	}
+	if string(got) != v {
+		t.Errorf("Get(%q): expect %q, but get %q", name, v, got)
+	}
+}
+
+func (s *suite) expectMiss(t *testing.T, store backend.Store, name string) {
+	t.Helper()
+	if _, err := store.Get(s.key(store, name)); err != cache.ErrNoSuchKey {
+		t.Errorf("Get(%q): expect %v, but get %v", name, cache.ErrNoSuchKey, err)
+	}
+}
+
+// dump iterates the store into "k=v;" pairs sorted by keys, with keys mapped
+// back to names.
+func (s *suite) dump(t *testing.T, store backend.Store, names ...string) string {
+	t.Helper()
+	lookup := make(map[string]string, len(names))
+	for _, name := range names {
+		lookup[string(s.key(store, name))] = name
+	}
+
+	var pairs []string
+	seen := make(map[string]bool)
+	err := store.Iter(func(k, v []byte) error {
+		name, ok := lookup[string(k)]
+		if !ok {
+			name = "?" + string(k)
+		}
+		if seen[name] {
+			t.Errorf("Iter: expect each key to be iterated once, but get %q again", name)
+		}
+		seen[name] = true
+		pairs = append(pairs, fmt.Sprintf("%s=%s;", name, v))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iter: expect no error, but get %v", err)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "")
+}
+
+func (s *suite) testPutGet(t *testing.T, store backend.Store) {
+	s.put(t, store, "a", "1")
+	s.put(t, store, "b", "2")
+	s.expect(t, store, "a", "1")
+	s.expect(t, store, "b", "2")
+}
+
+func (s *suite) testOverwrite(t *testing.T, store backend.Store) {
+	s.put(t, store, "a", "1")
+	s.put(t, store, "a", "22")
+	s.expect(t, store, "a", "22")
+	if got := s.dump(t, store, "a"); got != "a=22;" {
+		t.Errorf("Iter: expect %v, but get %v", "a=22;", got)
+	}
+}
+
+// testReuse overwrites the buffers of keys and values once they have been
+// written, which must not change what the store holds.
+func (s *suite) testReuse(t *testing.T, store backend.Store) {
+	scribble := func(bufs ...[]byte) {
+		for _, buf := range bufs {
+			for i := range buf {
+				buf[i] = 'x'
+			}
+		}
+	}
+
+	k, v := s.key(store, "a"), []byte("1")
+	if err := store.Put(k, v); err != nil {
+		t.Fatalf("Put(%q): expect no error, but get %v", "a", err)
+	}
+	scribble(k, v)
+	expect := "a=1;"
+
+	if updater, ok := store.(backend.Updater); ok {
+		k, v := s.key(store, "b"), []byte("2")
+		err := updater.Update(k, func(old []byte) ([]byte, error) {
+			return v, nil
+		})
+		if err != nil {
+			t.Fatalf("Update(%q): expect no error, but get %v", "b", err)
+		}
+		scribble(k, v)
+		s.expect(t, store, "b", "2")
+		expect += "b=2;"
+	}
+
+	if batcher, ok := store.(backend.Batcher); ok {
+		k, v := s.key(store, "c"), []byte("3")
+		err := batcher.Batch(func(txn backend.Txn) error {
+			return txn.Put(k, v)
+		})
+		if err != nil {
+			t.Fatalf("Batch: expect no error, but get %v", err)
+		}
+		scribble(k, v)
+		s.expect(t, store, "c", "3")
+		expect += "c=3;"
+	}
+
+	s.expect(t, store, "a", "1")
+	if got := s.dump(t, store, "a", "b", "c"); got != expect {
+		t.Errorf("Iter: expect %v, but get %v", expect, got)
+	}
+}
+
+func (s *suite) testMiss(t *testing.T, store backend.Store) {
+	s.expectMiss(t, store, "a")
+	s.put(t, store, "a", "1")
+	s.expectMiss(t, store, "b")
+}
+
+func (s *suite) testRemove(t *testing.T, store backend.Store) {
+	s.put(t, store, "a", "1")
+	s.put(t, store, "b", "2")
+	if err := store.Remove(s.key(store, "a")); err != nil {
+		t.Errorf("Remove(%q): expect no error, but get %v", "a", err)
+	}
+	s.expectMiss(t, store, "a")
+	s.expect(t, store, "b", "2")
+
+	if err := store.Remove(s.key(store, "a")); err != nil && err != cache.ErrNoSuchKey {
+		t.Errorf("Remove(%q) again: expect no error or %v, but get %v", "a", cache.ErrNoSuchKey, err)
+	}
+	if got := s.dump(t, store, "a", "b"); got != "b=2;" {
+		t.Errorf("Iter: expect %v, but get %v", "b=2;", got)
+	}
+}
+
+func (s *suite) testIter(t *testing.T, store backend.Store) {
+	if got := s.dump(t, store); got != "" {
+		t.Errorf("Iter: expect an empty store, but get %v", got)
+	}
+
+	names := []string{"a", "b", "c", "d", "e"}
+	for i, name := range names {
+		s.put(t, store, name, fmt.Sprint(i))
+	}
+	if got := s.dump(t, store, names...); got != "a=0;b=1;c=2;d=3;e=4;" {
+		t.Errorf("Iter: expect %v, but get %v", "a=0;b=1;c=2;d=3;e=4;", got)
+	}
+}
+
+func (s *suite) testIterError(t *testing.T, store backend.Store) {
+	s.put(t, store, "a", "1")
+	s.put(t, store, "b", "2")
+
+	n := 0
+	err := store.Iter(func(k, v []byte) error {
+		n++
+		return errMock
+	})
+	if errors.Cause(err) != errMock {
+		t.Errorf("Iter: expect %v, but get %v", errMock, err)
+	}
+	if n != 1 {
+		t.Errorf("Iter: expect the iteration to stop at the first error, but get %d calls", n)
+	}
+}
+
+func (s *suite) testScan(t *testing.T, store backend.Store) {
+	scanner, ok := store.(backend.Scanner)
+	if !ok {
+		t.Skip("the store does not implement backend.Scanner")
+	}
+
+	for _, name := range []string{"d", "b", "e", "a", "c"} {
+		s.put(t, store, name, name)
+	}
+	testcases := []struct {
+		start  string
+		expect string
+	}{
+		{"", "a;b;c;d;e;"},
+		{"c", "c;d;e;"},
+		{"bb", "c;d;e;"},
+		{"f", ""},
+	}
+	for idx, tc := range testcases {
+		var buf bytes.Buffer
+		var start []byte
+		if tc.start != "" {
+			start = s.key(store, tc.start)
+		}
+		err := scanner.Scan(start, func(k, v []byte) error {
+			fmt.Fprintf(&buf, "%s;", v)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("[#Case%d] Scan(%q): expect no error, but get %v", idx, tc.start, err)
+		}
+		if buf.String() != tc.expect {
+			t.Errorf("[#Case%d] Scan(%q): expect %v, but get %v", idx, tc.start, tc.expect, buf.String())
+		}
+	}
+
+	err := scanner.Scan(nil, func(k, v []byte) error { return errMock })
+	if errors.Cause(err) != errMock {
+		t.Errorf("Scan: expect %v, but get %v", errMock, err)
+	}
+}
+
+func (s *suite) testUpdate(t *testing.T, store backend.Store) {
+	updater, ok := store.(backend.Updater)
+	if !ok {
+		t.Skip("the store does not implement backend.Updater")
+	}
+
+	err := updater.Update(s.key(store, "a"), func(old []byte) ([]byte, error) {
+		if old != nil {
+			t.Errorf("Update: expect nil old value of a missing key, but get %q", old)
+		}
+		return []byte("1"), nil
+	})
+	if err != nil {
+		t.Errorf("Update: expect no error, but get %v", err)
+	}
+	s.expect(t, store, "a", "1")
+
+	err = updater.Update(s.key(store, "a"), func(old []byte) ([]byte, error) {
+		return append(append([]byte{}, old...), '2'), nil
+	})
+	if err != nil {
+		t.Errorf("Update: expect no error, but get %v", err)
+	}
+	s.expect(t, store, "a", "12")
+
+	err = updater.Update(s.key(store, "b"), func(old []byte) ([]byte, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Errorf("Update: expect no error, but get %v", err)
+	}
+	s.expectMiss(t, store, "b")
+
+	err = updater.Update(s.key(store, "a"), func(old []byte) ([]byte, error) {
+		return []byte("3"), errMock
+	})
+	if errors.Cause(err) != errMock {
+		t.Errorf("Update: expect %v, but get %v", errMock, err)
+	}
+	s.expect(t, store, "a", "12")
+
+	// Updates of the same key must not be lost under contention.
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := updater.Update(s.key(store, "c"), func(old []byte) ([]byte, error) {
+				return append(append([]byte{}, old...), 'x'), nil
+			})
+			if err != nil {
+				t.Errorf("Update: expect no error, but get %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	s.expect(t, store, "c", strings.Repeat("x", n))
+}
+
+func (s *suite) testBatch(t *testing.T, store backend.Store) {
+	batcher, ok := store.(backend.Batcher)
+	if !ok {
+		t.Skip("the store does not implement backend.Batcher")
+	}
+	s.put(t, store, "a", "1")
+	s.put(t, store, "b", "2")
+
+	err := batcher.Batch(func(txn backend.Txn) error {
+		v, err := txn.Get(s.key(store, "a"))
+		if err != nil {
+			return err
+		}
+		if _, err := txn.Get(s.key(store, "c")); err != cache.ErrNoSuchKey {
+			t.Errorf("Txn.Get(%q): expect %v, but get %v", "c", cache.ErrNoSuchKey, err)
+		}
+		if err := txn.Put(s.key(store, "c"), append(v, '3')); err != nil {
+			return err
+		}
+		if v, err := txn.Get(s.key(store, "c")); err != nil || string(v) != "13" {
+			t.Errorf("Txn.Get(%q): expect the staged value %q, but get %q, %v", "c", "13", v, err)
+		}
+		if err := txn.Remove(s.key(store, "b")); err != nil {
+			return err
+		}
+		if _, err := txn.Get(s.key(store, "b")); err != cache.ErrNoSuchKey {
+			t.Errorf("Txn.Get(%q): expect %v after removing, but get %v", "b", cache.ErrNoSuchKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Batch: expect no error, but get %v", err)
+	}
+	if got := s.dump(t, store, "a", "b", "c"); got != "a=1;c=13;" {
+		t.Errorf("Iter: expect %v, but get %v", "a=1;c=13;", got)
+	}
+
+	// None of the writes are applied if the batch fails.
+	err = batcher.Batch(func(txn backend.Txn) error {
+		txn.Put(s.key(store, "a"), []byte("4"))
+		txn.Remove(s.key(store, "c"))
+		return errMock
+	})
+	if errors.Cause(err) != errMock {
+		t.Errorf("Batch: expect %v, but get %v", errMock, err)
+	}
+	if got := s.dump(t, store, "a", "b", "c"); got != "a=1;c=13;" {
+		t.Errorf("Iter: expect %v, but get %v", "a=1;c=13;", got)
+	}
+}
+
+func (s *suite) testConcurrent(t *testing.T, store backend.Store) {
+	const (
+		workers = 8
+		rounds  = 20
+	)
+	shared := s.key(store, "shared")
+	keys := make([][][]byte, workers)
+	for w := range keys {
+		for i := 0; i < rounds; i++ {
+			keys[w] = append(keys[w], s.key(store, fmt.Sprintf("w%d-%02d", w, i)))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i, k := range keys[w] {
+				v := []byte(fmt.Sprint(i))
+				if err := store.Put(k, v); err != nil {
+					t.Errorf("Put: expect no error, but get %v", err)
+				}
+				if got, err := store.Get(k); err != nil || !bytes.Equal(got, v) {
+					t.Errorf("Get: expect %s, but get %s, %v", v, got, err)
+				}
+				if err := store.Put(shared, v); err != nil {
+					t.Errorf("Put: expect no error, but get %v", err)
+				}
+				if _, err := store.Get(shared); err != nil && err != cache.ErrNoSuchKey {
+					t.Errorf("Get: expect no error, but get %v", err)
+				}
+				if i%2 == 1 {
+					if err := store.Remove(k); err != nil {
+						t.Errorf("Remove: expect no error, but get %v", err)
+					}
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds/4; i++ {
+				err := store.Iter(func(k, v []byte) error { return nil })
+				if err != nil {
+					t.Errorf("Iter: expect no error, but get %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	n := 0
+	err := store.Iter(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Iter: expect no error, but get %v", err)
+	}
+	if expect := workers*rounds/2 + 1; n != expect {
+		t.Errorf("Iter: expect %d key-value pairs, but get %d", expect, n)
+	}
+}
+
+func (s *suite) testClose(t *testing.T, store backend.Store) {
+	s.put(t, store, "a", "1")
+	if err := store.Close(); err != nil {
+		t.Errorf("Close: expect no error, but get %v", err)
+	}
+
+	// Closing twice may fail, but it must not panic.
+	store.Close()
+}
@@ -0,0 +1,22 @@
+package backendtest
+
+import (
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/gomap"
+)
+
+func TestRunStoreTests(t *testing.T) {
+	RunStoreTests(t, func(t *testing.T) backend.Store {
+		return gomap.New()
+	})
+}
+
+func TestWithKeys(t *testing.T) {
+	RunStoreTests(t, func(t *testing.T) backend.Store {
+		return gomap.New()
+	}, WithKeys(func(store backend.Store, name string) []byte {
+		return []byte("prefix/" + name)
+	}))
+}
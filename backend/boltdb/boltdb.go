@@ -1,25 +1,85 @@
 package boltdb
 
 import (
+	"io"
+	"sync"
+
 	"github.com/meowdada/go-fcache/cache"
 	bolt "go.etcd.io/bbolt"
 )
 
+// Open opens the boltDB and creates the bucket if not exist immediately.
+func Open(opts Options) (*BoltDB, error) {
+	b := New(opts)
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 // New is a factory method to creates a boltdb instance. Note that it will
-// not open a boltDB immediately until used.
+// not open a boltDB immediately until used, so errors of opening are raised
+// by the first call. Use Open to get them in advance.
 func New(opts Options) *BoltDB {
 	return &BoltDB{
-		core:   nil,
-		opts:   opts,
-		bucket: []byte(opts.Bucket),
+		core:    nil,
+		opts:    opts,
+		buckets: [][]byte{[]byte(opts.Bucket)},
 	}
 }
 
 // BoltDB implements backend.Store interface.
 type BoltDB struct {
-	core   *bolt.DB
-	opts   Options
-	bucket []byte
+	core *bolt.DB
+	opts Options
+
+	// buckets is the path of nested buckets which stores key-value pairs,
+	// from the outermost one.
+	buckets [][]byte
+
+	// shared is true if the database is opened by another store.
+	shared bool
+
+	// notify is invoked with expired key-value pairs, and stop and done
+	// control the sweeper removing them.
+	notify func(k, v []byte)
+	stop   chan struct{}
+	done   chan struct{}
+
+	// mu guards opening the database lazily, and the fields of expiry.
+	mu sync.Mutex
+}
+
+// Namespace returns a store sharing the database with b, whose key-value
+// pairs are stored in a bucket nested in the one of b with given names,
+// from the outermost one. It lets several caches share a boltDB file, which
+// could not be opened twice. Buckets are created if not exist. The database
+// is only closed by the store created by Open or New.
+func (b *BoltDB) Namespace(names ...string) (*BoltDB, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	buckets := append([][]byte{}, b.buckets...)
+	for _, name := range names {
+		buckets = append(buckets, []byte(name))
+	}
+	ns := &BoltDB{core: b.core, opts: b.opts, buckets: buckets, shared: true}
+	var pending bool
+	if err := b.core.Update(func(tx *bolt.Tx) error {
+		if err := createBuckets(tx, buckets); err != nil {
+			return err
+		}
+		pending = hasExpiry(ns.bucket(tx))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if pending {
+		ns.mu.Lock()
+		ns.sweep()
+		ns.mu.Unlock()
+	}
+	return ns, nil
 }
 
 // Put puts a key-value pair into the boltDB.
@@ -27,26 +87,95 @@ func (b *BoltDB) Put(k, v []byte) error {
 	if err := b.init(); err != nil {
 		return err
 	}
-	return b.core.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(b.bucket)
-		return bucket.Put(k, v)
+	return b.write(func(tx *bolt.Tx) error {
+		return txn{b.bucket(tx)}.Put(k, v)
 	})
 }
 
-// Get gets a value by given key from boltDB.
+// Get gets a value by given key from boltDB. The value is copied since the
+// one returned by bolt is only valid within the transaction.
 func (b *BoltDB) Get(k []byte) (v []byte, e error) {
 	if err := b.init(); err != nil {
 		return nil, err
 	}
 	e = b.core.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(b.bucket)
-		v = bucket.Get(k)
+		if val := b.bucket(tx).Get(k); val != nil {
+			v = append([]byte{}, val...)
+		}
 		return nil
 	})
+	if e != nil {
+		return nil, e
+	}
+	if v == nil {
+		return nil, cache.ErrNoSuchKey
+	}
+	return v, nil
+}
+
+// Update reads, modifies and writes a key-value pair within a single
+// transaction. The old value passed to fn is nil if the key does not
+// present, and it is only valid within fn. If fn returns an error, the
+// transaction will be rolled back. If fn returns a nil value, nothing
+// will be written.
+func (b *BoltDB) Update(k []byte, fn func(old []byte) ([]byte, error)) error {
+	if err := b.init(); err != nil {
+		return err
+	}
+	return b.core.Update(func(tx *bolt.Tx) error {
+		bucket := b.bucket(tx)
+		v, err := fn(bucket.Get(k))
+		if err != nil || v == nil {
+			return err
+		}
+		return txn{bucket}.Put(k, v)
+	})
+}
+
+// Batch applies all the writes made by fn within a single transaction. Values
+// got from the transaction are only valid within fn. If fn returns an error,
+// the transaction will be rolled back.
+func (b *BoltDB) Batch(fn func(txn Txn) error) error {
+	if err := b.init(); err != nil {
+		return err
+	}
+	return b.core.Update(func(tx *bolt.Tx) error {
+		return fn(txn{b.bucket(tx)})
+	})
+}
+
+// Txn is identical to backend.Txn.
+type Txn = interface {
+	Get(k []byte) (v []byte, e error)
+	Put(k, v []byte) error
+	Remove(k []byte) error
+}
+
+// txn implements Txn with a bucket of a writable transaction.
+type txn struct {
+	bucket *bolt.Bucket
+}
+
+func (t txn) Get(k []byte) ([]byte, error) {
+	v := t.bucket.Get(k)
 	if v == nil {
 		return nil, cache.ErrNoSuchKey
 	}
-	return v, e
+	return v, nil
+}
+
+func (t txn) Put(k, v []byte) error {
+	if err := clearExpiry(t.bucket, k); err != nil {
+		return err
+	}
+	return t.bucket.Put(k, v)
+}
+
+func (t txn) Remove(k []byte) error {
+	if err := clearExpiry(t.bucket, k); err != nil {
+		return err
+	}
+	return t.bucket.Delete(k)
 }
 
 // Remove removes a key-value pair from the boltDB.
@@ -54,53 +183,145 @@ func (b *BoltDB) Remove(k []byte) error {
 	if err := b.init(); err != nil {
 		return err
 	}
-	return b.core.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(b.bucket)
-		return bucket.Delete(k)
+	return b.write(func(tx *bolt.Tx) error {
+		return txn{b.bucket(tx)}.Remove(k)
 	})
 }
 
-// Iter iterates all key-value pairs from the boltDB.
+// Iter iterates all key-value pairs from the boltDB. Nested buckets of
+// namespaces are skipped.
 func (b *BoltDB) Iter(iterCb func(k, v []byte) error) error {
 	if err := b.init(); err != nil {
 		return err
 	}
 	return b.core.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(b.bucket)
-		return bucket.ForEach(iterCb)
+		return b.bucket(tx).ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			return iterCb(k, v)
+		})
+	})
+}
+
+// Scan iterates key-value pairs from the boltDB in ascending order of keys,
+// starting from the first key which is equal to or greater than start.
+func (b *BoltDB) Scan(start []byte, iterCb func(k, v []byte) error) error {
+	if err := b.init(); err != nil {
+		return err
+	}
+	return b.core.View(func(tx *bolt.Tx) error {
+		c := b.bucket(tx).Cursor()
+		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if err := iterCb(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Backup writes a consistent copy of the whole boltDB, including all the
+// namespaces, to w. It runs within a read-only transaction, so it does not
+// block writers. It returns the number of bytes written.
+func (b *BoltDB) Backup(w io.Writer) (n int64, err error) {
+	if err := b.init(); err != nil {
+		return 0, err
+	}
+	err = b.core.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
 	})
+	return n, err
 }
 
-// Close cloes the boltDB.
+// Close stops removing expired key-value pairs, and cloes the boltDB if it
+// is not shared from another store.
 func (b *BoltDB) Close() error {
-	if b.core == nil {
+	b.stopSweep()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.core == nil || b.shared {
 		return nil
 	}
 	return b.core.Close()
 }
 
-func (b *BoltDB) init() (err error) {
-	if b.core == nil {
-		opts := b.opts
+// init opens the boltDB if it is not opened yet. It is safe to be called
+// concurrently.
+func (b *BoltDB) init() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.core != nil {
+		return nil
+	}
+	opts := b.opts
 
-		// Open the boltDB.
-		b.core, err = bolt.Open(opts.Path, opts.Mode, opts.Options)
-		if err != nil {
+	// Open the boltDB.
+	core, err := bolt.Open(opts.Path, opts.Mode, opts.Options)
+	if err != nil {
+		return err
+	}
+	core.NoSync = opts.NoSync
+	if opts.MaxBatchSize > 0 {
+		core.MaxBatchSize = opts.MaxBatchSize
+	}
+	if opts.MaxBatchDelay > 0 {
+		core.MaxBatchDelay = opts.MaxBatchDelay
+	}
+
+	// Create bucket if not exist, and closes the database connection if
+	// any error occurs.
+	var pending bool
+	err = core.Update(func(tx *bolt.Tx) error {
+		if err := createBuckets(tx, b.buckets); err != nil {
 			return err
 		}
+		pending = hasExpiry(b.bucket(tx))
+		return nil
+	})
+	if err != nil {
+		core.Close()
+		return err
+	}
+	b.core = core
 
-		// Closes the database connection if any error occurs.
-		defer func() {
-			if err != nil && b.core != nil {
-				b.core.Close()
-			}
-		}()
+	// Resume removing pairs expired while the database was closed.
+	if pending {
+		b.sweep()
+	}
+	return nil
+}
 
-		// Create bucket if not exist.
-		err = b.core.Update(func(tx *bolt.Tx) error {
-			_, err := tx.CreateBucketIfNotExists(b.bucket)
-			return err
-		})
+// write applies fn within a writable transaction, which might be coalesced
+// with others if batching is enabled. fn must be idempotent since it might
+// be retried.
+func (b *BoltDB) write(fn func(tx *bolt.Tx) error) error {
+	if b.opts.batching() {
+		return b.core.Batch(fn)
+	}
+	return b.core.Update(fn)
+}
+
+// bucket returns the bucket which stores key-value pairs.
+func (b *BoltDB) bucket(tx *bolt.Tx) *bolt.Bucket {
+	bucket := tx.Bucket(b.buckets[0])
+	for _, name := range b.buckets[1:] {
+		bucket = bucket.Bucket(name)
+	}
+	return bucket
+}
+
+func createBuckets(tx *bolt.Tx, names [][]byte) error {
+	bucket, err := tx.CreateBucketIfNotExists(names[0])
+	for _, name := range names[1:] {
+		if err != nil {
+			break
+		}
+		bucket, err = bucket.CreateBucketIfNotExists(name)
 	}
 	return err
 }
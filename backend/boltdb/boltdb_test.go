@@ -3,9 +3,15 @@ package boltdb
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
 	"github.com/meowdada/go-fcache/cache"
 )
 
@@ -313,6 +319,170 @@ func TestIter(t *testing.T) {
 	}
 }
 
+func TestScan(t *testing.T) {
+	db := New(Options{
+		Path:    "bolt.db",
+		Mode:    0666,
+		Bucket:  "cache",
+		Options: nil,
+	})
+	defer os.Remove("bolt.db")
+	defer db.Close()
+
+	for _, k := range []string{"c", "a", "b", "ab"} {
+		err := db.Put([]byte(k), []byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	err := db.Scan([]byte("aa"), func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[ab b c]" {
+		t.Errorf("expect %v, but get %v", "[ab b c]", keys)
+	}
+
+	err = db.Scan(nil, func(k, v []byte) error { return cache.ErrNoSuchKey })
+	if err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// Invalid Open
+	db2 := New(Options{
+		Path:    "/dev/null",
+		Mode:    0666,
+		Bucket:  "cache",
+		Options: nil,
+	})
+	if err := db2.Scan(nil, nil); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := New(Options{
+		Path:    "bolt.db",
+		Mode:    0666,
+		Bucket:  "cache",
+		Options: nil,
+	})
+	defer os.Remove("bolt.db")
+	defer db.Close()
+
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		description string
+		k           []byte
+		ret         []byte
+		retErr      error
+		expectOld   []byte
+		expectV     []byte
+	}{
+		{"update existing key", []byte("a"), []byte("2"), nil, []byte("1"), []byte("2")},
+		{"write nothing", []byte("a"), nil, nil, []byte("2"), []byte("2")},
+		{"roll back by error", []byte("a"), []byte("3"), cache.ErrNoSuchKey, []byte("2"), []byte("2")},
+		{"insert missing key", []byte("b"), []byte("1"), nil, nil, []byte("1")},
+	}
+
+	for idx, tc := range testcases {
+		desc := tc.description
+		err := db.Update(tc.k, func(old []byte) ([]byte, error) {
+			if !bytes.Equal(old, tc.expectOld) {
+				t.Errorf("[Case#%d]%s: expect old value %s, but get %s", idx, desc, tc.expectOld, old)
+			}
+			return tc.ret, tc.retErr
+		})
+		if err != tc.retErr {
+			t.Errorf("[Case#%d]%s: expect %v, but get %v", idx, desc, tc.retErr, err)
+		}
+		if v, _ := db.Get(tc.k); !bytes.Equal(v, tc.expectV) {
+			t.Errorf("[Case#%d]%s: expect %s, but get %s", idx, desc, tc.expectV, v)
+		}
+	}
+
+	// Invalid Open
+	db2 := New(Options{
+		Path:    "/dev/null",
+		Mode:    0666,
+		Bucket:  "cache",
+		Options: nil,
+	})
+	if err := db2.Update(nil, nil); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	db := New(Options{
+		Path:    "bolt.db",
+		Mode:    0666,
+		Bucket:  "cache",
+		Options: nil,
+	})
+	defer os.Remove("bolt.db")
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("2"))
+
+	err := db.Batch(func(txn Txn) error {
+		txn.Put([]byte("a"), []byte("3"))
+		txn.Remove([]byte("b"))
+		if v, err := txn.Get([]byte("a")); err != nil || string(v) != "3" {
+			t.Errorf("expect %s, but get %s, %v", "3", v, err)
+		}
+		if _, err := txn.Get([]byte("b")); err != cache.ErrNoSuchKey {
+			t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("a")); err != nil || string(v) != "3" {
+		t.Errorf("expect %s, but get %s, %v", "3", v, err)
+	}
+	if _, err := db.Get([]byte("b")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// None of the writes will be applied if an error occurs.
+	err = db.Batch(func(txn Txn) error {
+		txn.Remove([]byte("a"))
+		txn.Put([]byte("d"), []byte("4"))
+		return cache.ErrNoSuchKey
+	})
+	if err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if _, err := db.Get([]byte("a")); err != nil {
+		t.Errorf("expect no error, but get %v", err)
+	}
+	if _, err := db.Get([]byte("d")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// Invalid Open
+	db2 := New(Options{
+		Path:    "/dev/null",
+		Mode:    0666,
+		Bucket:  "cache",
+		Options: nil,
+	})
+	if err := db2.Batch(nil); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
 func TestClose(t *testing.T) {
 	db := New(Options{
 		Path:    "/dev/nill",
@@ -344,3 +514,231 @@ func TestClose(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func tempPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "bolt.db")
+}
+
+func TestOpen(t *testing.T) {
+	testcases := []struct {
+		description string
+		opts        Options
+		expectErr   bool
+	}{
+		{"valid open", Options{Path: tempPath(t), Mode: 0666, Bucket: "cache"}, false},
+		{"invalid path", Options{Path: "/dev/null", Mode: 0666, Bucket: "cache"}, true},
+		{"invalid bucket", Options{Path: tempPath(t), Mode: 0666}, true},
+		{"tuned", Options{Path: tempPath(t), Mode: 0666, Bucket: "cache", NoSync: true, MaxBatchSize: 10, MaxBatchDelay: time.Millisecond}, false},
+	}
+
+	for idx, tc := range testcases {
+		desc := tc.description
+		db, err := Open(tc.opts)
+		if err != nil && !tc.expectErr {
+			t.Errorf("[Case#%d] %s: expect no errors, but get %v", idx, desc, err)
+		}
+		if err == nil && tc.expectErr {
+			t.Errorf("[Case#%d] %s: expect error occurs, but get no errors", idx, desc)
+		}
+		if err != nil {
+			continue
+		}
+		if db.core.NoSync != tc.opts.NoSync {
+			t.Errorf("[Case#%d] %s: expect NoSync %v, but get %v", idx, desc, tc.opts.NoSync, db.core.NoSync)
+		}
+		if tc.opts.MaxBatchSize > 0 && db.core.MaxBatchSize != tc.opts.MaxBatchSize {
+			t.Errorf("[Case#%d] %s: expect MaxBatchSize %v, but get %v", idx, desc, tc.opts.MaxBatchSize, db.core.MaxBatchSize)
+		}
+		db.Close()
+	}
+}
+
+func TestConcurrentInit(t *testing.T) {
+	db := New(Options{Path: tempPath(t), Mode: 0666, Bucket: "cache"})
+	defer db.Close()
+
+	// The first calls open the database concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.Put([]byte(fmt.Sprint(i)), []byte("v")); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	n := 0
+	db.Iter(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	if n != 8 {
+		t.Errorf("expect %v, but get %v", 8, n)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	path := tempPath(t)
+	db, err := Open(Options{Path: path, Mode: 0666, Bucket: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := db.Namespace("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.Namespace("b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("k"), []byte("root"))
+	a.Put([]byte("k"), []byte("a"))
+	b.Put([]byte("k"), []byte("b"))
+
+	dump := func(s *BoltDB) string {
+		var buf bytes.Buffer
+		s.Iter(func(k, v []byte) error {
+			fmt.Fprintf(&buf, "%s=%s;", k, v)
+			return nil
+		})
+		s.Scan(nil, func(k, v []byte) error {
+			fmt.Fprintf(&buf, "%s=%s;", k, v)
+			return nil
+		})
+		return buf.String()
+	}
+
+	testcases := []struct {
+		description string
+		store       *BoltDB
+		expect      string
+	}{
+		{"nested buckets are skipped", db, "k=root;k=root;"},
+		{"namespace a", a, "k=a;k=a;"},
+		{"nested namespace", b, "k=b;k=b;"},
+	}
+	for idx, tc := range testcases {
+		if got := dump(tc.store); got != tc.expect {
+			t.Errorf("[Case#%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, got)
+		}
+	}
+	if _, err := db.Get([]byte("a")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if _, err := db.Namespace(""); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+
+	// Closing a namespace leaves the database opened.
+	if err := a.Close(); err != nil {
+		t.Error(err)
+	}
+	if v, err := b.Get([]byte("k")); err != nil || string(v) != "b" {
+		t.Errorf("expect %v, but get %s, %v", "b", v, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := b.Get([]byte("k")); err == nil {
+		t.Errorf("expect error occurs after closing, but get no error")
+	}
+
+	// Namespaces persist in the file.
+	db, err = Open(Options{Path: path, Mode: 0666, Bucket: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	b, _ = db.Namespace("b", "c")
+	if v, err := b.Get([]byte("k")); err != nil || string(v) != "b" {
+		t.Errorf("expect %v, but get %s, %v", "b", v, err)
+	}
+
+	if _, err := New(Options{Path: "/dev/null", Bucket: "cache"}).Namespace("a"); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db, err := Open(Options{Path: tempPath(t), Mode: 0666, Bucket: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ns, _ := db.Namespace("ns")
+	db.Put([]byte("a"), []byte("1"))
+	ns.Put([]byte("b"), []byte("2"))
+
+	var buf bytes.Buffer
+	n, err := db.Backup(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("expect %d bytes written, but get %d, %v", buf.Len(), n, err)
+	}
+
+	path := tempPath(t)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(Options{Path: path, Mode: 0666, Bucket: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if v, err := restored.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Errorf("expect %v, but get %s, %v", "1", v, err)
+	}
+	rns, _ := restored.Namespace("ns")
+	if v, err := rns.Get([]byte("b")); err != nil || string(v) != "2" {
+		t.Errorf("expect %v, but get %s, %v", "2", v, err)
+	}
+
+	if _, err := New(Options{Path: "/dev/null", Bucket: "cache"}).Backup(&buf); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	testcases := []struct {
+		description string
+		opts        Options
+		namespace   []string
+	}{
+		{"default", Options{Bucket: "cache"}, nil},
+		{"namespace", Options{Bucket: "cache"}, []string{"a", "b"}},
+		{"tuned", Options{Bucket: "cache", NoSync: true, MaxBatchDelay: time.Millisecond}, nil},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+				opts := tc.opts
+				opts.Path, opts.Mode = tempPath(t), 0666
+				db, err := Open(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tc.namespace == nil {
+					return db
+				}
+				t.Cleanup(func() { db.Close() })
+
+				// Pairs outside of the namespace must be invisible.
+				db.Put([]byte("k"), []byte("other"))
+				ns, err := db.Namespace(tc.namespace...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ns
+			})
+		})
+	}
+}
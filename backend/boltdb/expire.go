@@ -0,0 +1,224 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultExpireInterval is the default interval of removing expired
+// key-value pairs.
+const DefaultExpireInterval = time.Second
+
+var (
+	// ttlBucket is the reserved bucket nested in the one storing key-value
+	// pairs, which holds the expiry index.
+	ttlBucket = []byte("\x00fcache-ttl")
+
+	// indexBucket maps deadline | key to nothing, so deadlines are sorted.
+	indexBucket = []byte("index")
+
+	// deadlineBucket maps a key to its deadline.
+	deadlineBucket = []byte("deadline")
+)
+
+// Expire makes the key-value pair expire after ttl if the value of k is
+// still v. Deadlines are stored in the boltDB, so they survive reopening.
+// Expired pairs are removed every Options.ExpireInterval.
+func (b *BoltDB) Expire(k, v []byte, ttl time.Duration) error {
+	return b.ExpireAll([][]byte{k}, [][]byte{v}, ttl)
+}
+
+// ExpireAll is like Expire, but sets the deadlines of all pairs within a
+// single transaction.
+func (b *BoltDB) ExpireAll(ks, vs [][]byte, ttl time.Duration) error {
+	if len(ks) != len(vs) {
+		return errors.New("numbers of keys and values mismatch")
+	}
+	if err := b.init(); err != nil {
+		return err
+	}
+	deadline := make([]byte, 8)
+	binary.BigEndian.PutUint64(deadline, uint64(time.Now().Add(ttl).UnixNano()))
+	err := b.core.Update(func(tx *bolt.Tx) error {
+		bucket := b.bucket(tx)
+		for i, k := range ks {
+			if err := setExpiry(bucket, k, vs[i], deadline, ttl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || ttl <= 0 {
+		return err
+	}
+
+	b.mu.Lock()
+	b.sweep()
+	b.mu.Unlock()
+	return nil
+}
+
+// setExpiry sets the deadline of a key if its value is still v, or clears
+// it if ttl is not positive.
+func setExpiry(bucket *bolt.Bucket, k, v, deadline []byte, ttl time.Duration) error {
+	if cur := bucket.Get(k); cur == nil || !bytes.Equal(cur, v) {
+		return nil
+	}
+	if err := clearExpiry(bucket, k); err != nil || ttl <= 0 {
+		return err
+	}
+
+	ttlb, err := bucket.CreateBucketIfNotExists(ttlBucket)
+	if err != nil {
+		return err
+	}
+	index, err := ttlb.CreateBucketIfNotExists(indexBucket)
+	if err != nil {
+		return err
+	}
+	deadlines, err := ttlb.CreateBucketIfNotExists(deadlineBucket)
+	if err != nil {
+		return err
+	}
+	if err := index.Put(append(append([]byte{}, deadline...), k...), []byte{}); err != nil {
+		return err
+	}
+	return deadlines.Put(k, deadline)
+}
+
+// NotifyExpired makes fn invoked with each expired key-value pair after
+// the transaction removing it is committed.
+func (b *BoltDB) NotifyExpired(fn func(k, v []byte)) {
+	b.mu.Lock()
+	b.notify = fn
+	b.mu.Unlock()
+}
+
+// clearExpiry removes the deadline of a key from the expiry index, if any.
+func clearExpiry(bucket *bolt.Bucket, k []byte) error {
+	ttlb := bucket.Bucket(ttlBucket)
+	if ttlb == nil {
+		return nil
+	}
+	deadlines := ttlb.Bucket(deadlineBucket)
+	deadline := deadlines.Get(k)
+	if deadline == nil {
+		return nil
+	}
+	ik := append(append([]byte{}, deadline...), k...)
+	if err := ttlb.Bucket(indexBucket).Delete(ik); err != nil {
+		return err
+	}
+	return deadlines.Delete(k)
+}
+
+// hasExpiry returns if any key of the bucket has a deadline.
+func hasExpiry(bucket *bolt.Bucket) bool {
+	ttlb := bucket.Bucket(ttlBucket)
+	if ttlb == nil {
+		return false
+	}
+	k, _ := ttlb.Bucket(indexBucket).Cursor().First()
+	return k != nil
+}
+
+// sweep starts the sweeper removing expired key-value pairs if it is not
+// started yet. It must be called with b.mu held.
+func (b *BoltDB) sweep() {
+	if b.stop != nil {
+		return
+	}
+	interval := b.opts.ExpireInterval
+	if interval <= 0 {
+		interval = DefaultExpireInterval
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.sweepLoop(interval, b.stop, b.done)
+}
+
+func (b *BoltDB) sweepLoop(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// The database is closed by the store it is shared from.
+			if err := b.expire(time.Now()); err == bolt.ErrDatabaseNotOpen {
+				return
+			}
+		}
+	}
+}
+
+// stopSweep stops the sweeper, if any.
+func (b *BoltDB) stopSweep() {
+	b.mu.Lock()
+	stop, done := b.stop, b.done
+	b.stop, b.done = nil, nil
+	b.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// expire removes key-value pairs whose deadlines are not after now within
+// a single transaction, and notifies them after it is committed.
+func (b *BoltDB) expire(now time.Time) error {
+	var expired [][2][]byte
+	err := b.core.Update(func(tx *bolt.Tx) error {
+		expired = expired[:0]
+		bucket := b.bucket(tx)
+		ttlb := bucket.Bucket(ttlBucket)
+		if ttlb == nil {
+			return nil
+		}
+		index := ttlb.Bucket(indexBucket)
+
+		var due [][]byte
+		c := index.Cursor()
+		for ik, _ := c.First(); ik != nil; ik, _ = c.Next() {
+			if int64(binary.BigEndian.Uint64(ik[:8])) > now.UnixNano() {
+				break
+			}
+			due = append(due, append([]byte{}, ik...))
+		}
+		for _, ik := range due {
+			k := ik[8:]
+			v := append([]byte{}, bucket.Get(k)...)
+			if err := index.Delete(ik); err != nil {
+				return err
+			}
+			if err := ttlb.Bucket(deadlineBucket).Delete(k); err != nil {
+				return err
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			expired = append(expired, [2][]byte{k, v})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	notify := b.notify
+	b.mu.Unlock()
+	if notify == nil {
+		return nil
+	}
+	for _, p := range expired {
+		notify(p[0], p[1])
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package boltdb
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	testcases := []struct {
+		description string
+		k           string
+		v           string
+		fn          func(db *BoltDB, k, v []byte)
+		expired     bool
+	}{
+		{
+			"expire a pair",
+			"a", "1",
+			func(db *BoltDB, k, v []byte) { db.Expire(k, v, time.Millisecond) },
+			true,
+		},
+		{
+			"expire with a stale value",
+			"b", "2",
+			func(db *BoltDB, k, v []byte) { db.Expire(k, []byte("x"), time.Millisecond) },
+			false,
+		},
+		{
+			"put after expire",
+			"c", "3",
+			func(db *BoltDB, k, v []byte) {
+				db.Expire(k, v, time.Millisecond)
+				db.Put(k, v)
+			},
+			false,
+		},
+		{
+			"clear the expiry",
+			"d", "4",
+			func(db *BoltDB, k, v []byte) {
+				db.Expire(k, v, time.Millisecond)
+				db.Expire(k, v, 0)
+			},
+			false,
+		},
+		{
+			"update after expire",
+			"e", "5",
+			func(db *BoltDB, k, v []byte) {
+				db.Expire(k, v, time.Millisecond)
+				db.Update(k, func(old []byte) ([]byte, error) { return old, nil })
+			},
+			false,
+		},
+		{
+			"batch after expire",
+			"f", "6",
+			func(db *BoltDB, k, v []byte) {
+				db.Expire(k, v, time.Millisecond)
+				db.Batch(func(txn Txn) error { return txn.Put(k, v) })
+			},
+			false,
+		},
+		{
+			"expire all with a missing key",
+			"h", "8",
+			func(db *BoltDB, k, v []byte) {
+				db.ExpireAll([][]byte{k, []byte("missing")}, [][]byte{v, v}, time.Millisecond)
+			},
+			true,
+		},
+		{
+			"expire all with a stale value",
+			"i", "9",
+			func(db *BoltDB, k, v []byte) {
+				db.ExpireAll([][]byte{k}, [][]byte{[]byte("x")}, time.Millisecond)
+			},
+			false,
+		},
+		{
+			"expire later",
+			"g", "7",
+			func(db *BoltDB, k, v []byte) { db.Expire(k, v, time.Hour) },
+			false,
+		},
+	}
+
+	var (
+		mu      sync.Mutex
+		expired []string
+	)
+	db, err := Open(Options{Path: tempPath(t), Mode: 0666, Bucket: "cache", ExpireInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.NotifyExpired(func(k, v []byte) {
+		mu.Lock()
+		expired = append(expired, string(k)+"="+string(v))
+		mu.Unlock()
+	})
+	for _, tc := range testcases {
+		db.Put([]byte(tc.k), []byte(tc.v))
+		tc.fn(db, []byte(tc.k), []byte(tc.v))
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	sort.Strings(expired)
+	if len(expired) != 2 || expired[0] != "a=1" || expired[1] != "h=8" {
+		t.Errorf("expect expired pairs [a=1 h=8], but get %v", expired)
+	}
+	mu.Unlock()
+	for idx, tc := range testcases {
+		_, err := db.Get([]byte(tc.k))
+		if (err != nil) != tc.expired {
+			t.Errorf("[Case#%d] %s: expect expired %v, but get %v", idx, tc.description, tc.expired, err)
+		}
+	}
+}
+
+func TestExpireReopen(t *testing.T) {
+	path := tempPath(t)
+	opts := Options{Path: path, Mode: 0666, Bucket: "cache", ExpireInterval: time.Hour}
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, _ := db.Namespace("ns")
+	db.Put([]byte("a"), []byte("1"))
+	ns.Put([]byte("b"), []byte("2"))
+	db.Expire([]byte("a"), []byte("1"), time.Millisecond)
+	ns.Expire([]byte("b"), []byte("2"), time.Millisecond)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deadlines persist, and the sweeper is resumed by reopening.
+	opts.ExpireInterval = 10 * time.Millisecond
+	db, err = Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ns, _ = db.Namespace("ns")
+	time.Sleep(200 * time.Millisecond)
+	if _, err := db.Get([]byte("a")); err == nil {
+		t.Error("expect a expired after reopening")
+	}
+	if _, err := ns.Get([]byte("b")); err == nil {
+		t.Error("expect b expired after reopening")
+	}
+
+	// The expiry index is hidden from iterating.
+	db.Put([]byte("c"), []byte("3"))
+	db.Expire([]byte("c"), []byte("3"), time.Hour)
+	var keys []string
+	db.Iter(func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("expect keys [c], but get %v", keys)
+	}
+}
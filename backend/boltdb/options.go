@@ -2,14 +2,38 @@ package boltdb
 
 import (
 	"os"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 // Options configures boltdb instance.
 type Options struct {
-	Path    string
-	Mode    os.FileMode
-	Bucket  string
+	Path   string
+	Mode   os.FileMode
+	Bucket string
+
+	// NoSync skips fsync after each commit. It is faster, but changes might
+	// be lost or the database might be corrupted on a system crash.
+	NoSync bool
+
+	// MaxBatchSize and MaxBatchDelay enable coalescing concurrent Put and
+	// Remove calls into a single transaction, which reduces the number of
+	// fsync calls under heavy writes. A call waits at most MaxBatchDelay for
+	// others, and at most MaxBatchSize calls are coalesced. Coalescing is
+	// disabled if both of them are not positive, and the default of bolt is
+	// used for the one which is not positive otherwise.
+	MaxBatchSize  int
+	MaxBatchDelay time.Duration
+
+	// ExpireInterval is the interval of removing expired key-value pairs,
+	// DefaultExpireInterval by default.
+	ExpireInterval time.Duration
+
 	Options *bolt.Options
 }
+
+// batching returns if Put and Remove are coalesced.
+func (opts Options) batching() bool {
+	return opts.MaxBatchSize > 0 || opts.MaxBatchDelay > 0
+}
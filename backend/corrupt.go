@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+)
+
+// CorruptStrategy decides how the adapter handles records which are unable
+// to be decoded.
+type CorruptStrategy int
+
+const (
+	// FailOnCorrupt makes the adapter return a DecodeError once it meets a
+	// corrupted record. It is the default strategy.
+	FailOnCorrupt CorruptStrategy = iota
+
+	// SkipCorrupt makes the adapter treat a corrupted record as missing. It
+	// will be overwritten when the key is put or registered again.
+	SkipCorrupt
+
+	// QuarantineCorrupt makes the adapter move a corrupted record into the
+	// quarantine store, and treat it as missing. If there is no quarantine
+	// store, the record will be dropped.
+	QuarantineCorrupt
+)
+
+// String returns the name of the strategy.
+func (s CorruptStrategy) String() string {
+	switch s {
+	case FailOnCorrupt:
+		return "fail"
+	case SkipCorrupt:
+		return "skip"
+	case QuarantineCorrupt:
+		return "quarantine"
+	}
+	return "unknown"
+}
+
+// RepairOptions configures Repair.
+type RepairOptions struct {
+	// Quarantine is the store where corrupted records are moved into. If it
+	// is nil, corrupted records will be dropped.
+	Quarantine Store
+
+	// DryRun makes Repair only report corrupted records without touching them.
+	DryRun bool
+}
+
+// RepairReport reports the result of Repair.
+type RepairReport struct {
+	// Scanned is the number of records scanned.
+	Scanned int
+
+	// Corrupted are the keys of corrupted records.
+	Corrupted []string
+}
+
+// Repair scans all records in the store, and cleans up those which are unable
+// to be decoded by the codec. It is not safe to repair a store which is being
+// used by a cache manager.
+func Repair(store Store, codec codec.Codec, opts RepairOptions) (report RepairReport, err error) {
+	var corrupted []pair
+	err = store.Iter(func(k, v []byte) error {
+		report.Scanned++
+		var item cache.Item
+		if err := codec.Unmarshal(v, &item); err != nil {
+			corrupted = append(corrupted, newPair(k, v))
+			report.Corrupted = append(report.Corrupted, string(k))
+		}
+		return nil
+	})
+	if err != nil || opts.DryRun {
+		return report, err
+	}
+
+	for _, p := range corrupted {
+		if err := quarantine(store, opts.Quarantine, p); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// quarantine moves a record from the store into the quarantine store.
+func quarantine(store, quarantine Store, p pair) error {
+	if quarantine != nil {
+		if err := quarantine.Put(p.k, p.v); err != nil {
+			return err
+		}
+	}
+	return store.Remove(p.k)
+}
@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+func newCorruptedStore() *gomap.Map {
+	store := gomap.New()
+	data, _ := codec.Gob{}.Marshal(cache.New(1, "good", 10))
+	store.Put([]byte("good"), data)
+	store.Put([]byte("bad"), []byte("corrupted"))
+	return store
+}
+
+func TestCorruptStrategyString(t *testing.T) {
+	testcases := []struct {
+		strategy CorruptStrategy
+		expect   string
+	}{
+		{FailOnCorrupt, "fail"},
+		{SkipCorrupt, "skip"},
+		{QuarantineCorrupt, "quarantine"},
+		{CorruptStrategy(-1), "unknown"},
+	}
+
+	for idx, tc := range testcases {
+		if tc.strategy.String() != tc.expect {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, tc.strategy.String())
+		}
+	}
+}
+
+func TestAdapterFailOnCorrupt(t *testing.T) {
+	testcases := []struct {
+		description string
+		scenario    func(pool cache.Pool) error
+	}{
+		{"get", func(pool cache.Pool) error { _, err := pool.Get("bad"); return err }},
+		{"put", func(pool cache.Pool) error { return pool.Put("bad", 10) }},
+		{"incr ref", func(pool cache.Pool) error { return pool.IncrRef("bad") }},
+		{"decr ref", func(pool cache.Pool) error { return pool.DecrRef("bad") }},
+		{"iter", func(pool cache.Pool) error {
+			return pool.Iter(func(k string, v cache.Item) error { return nil })
+		}},
+		{"scan", func(pool cache.Pool) error {
+			return pool.(cache.Scanner).Scan("", func(k string, v cache.Item) error { return nil })
+		}},
+	}
+
+	for idx, tc := range testcases {
+		pool := Adapter(newCorruptedStore(), codec.Gob{})
+		err := tc.scenario(pool)
+		derr, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("[#Case%d] %s: expect a decode error, but get %v", idx, tc.description, err)
+			continue
+		}
+		if derr.Key != "bad" || derr.Unwrap() == nil || derr.Cause() != derr.Err {
+			t.Errorf("[#Case%d] %s: unexpect decode error %#v", idx, tc.description, derr)
+		}
+		if !IsDecodeError(err) {
+			t.Errorf("[#Case%d] %s: expect IsDecodeError to be true", idx, tc.description)
+		}
+	}
+}
+
+func TestAdapterSkipCorrupt(t *testing.T) {
+	store := newCorruptedStore()
+	pool := Adapter(store, codec.Gob{}, WithCorruptStrategy(SkipCorrupt))
+
+	var keys []string
+	err := pool.Iter(func(k string, v cache.Item) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "good" {
+		t.Errorf("expect %v, but get %v", []string{"good"}, keys)
+	}
+
+	if _, err := pool.Get("bad"); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// Putting the key again overwrites the corrupted record.
+	if err := pool.Put("bad", 10); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := pool.Get("bad"); err != nil || !item.IsReal() {
+		t.Errorf("expect a real item, but get %v, %v", item, err)
+	}
+}
+
+func TestAdapterChecksumCorrupt(t *testing.T) {
+	c := codec.Checksummed(codec.Gob{})
+	store := gomap.New()
+	pool := Adapter(store, c, WithCorruptStrategy(SkipCorrupt))
+	pool.Put("good", 10)
+	pool.Put("bad", 10)
+
+	// A flipped bit might still be decodable, but never passes the checksum.
+	data, _ := store.Get([]byte("bad"))
+	data[len(data)/2] ^= 0x01
+
+	if _, err := Adapter(store, c).Get("bad"); !IsDecodeError(err) || !codec.IsChecksumError(errors.Cause(err)) {
+		t.Errorf("expect a checksum error, but get %v", err)
+	}
+	var keys []string
+	pool.Iter(func(k string, v cache.Item) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != "good" {
+		t.Errorf("expect %v, but get %v", []string{"good"}, keys)
+	}
+}
+
+func TestAdapterQuarantineCorrupt(t *testing.T) {
+	testcases := []struct {
+		description string
+		scenario    func(pool cache.Pool) error
+	}{
+		{"get", func(pool cache.Pool) error {
+			_, err := pool.Get("bad")
+			if err != cache.ErrNoSuchKey {
+				return err
+			}
+			return nil
+		}},
+		{"scan", func(pool cache.Pool) error {
+			return pool.(cache.Scanner).Scan("", func(k string, v cache.Item) error {
+				if k == "bad" {
+					t.Errorf("expect corrupted record not to be scanned")
+				}
+				return nil
+			})
+		}},
+	}
+
+	for idx, tc := range testcases {
+		store, q := newCorruptedStore(), gomap.New()
+		pool := Adapter(store, codec.Gob{}, WithQuarantine(q))
+		if err := tc.scenario(pool); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+		if _, err := store.Get([]byte("bad")); err != cache.ErrNoSuchKey {
+			t.Errorf("[#Case%d] %s: expect the record to be removed, but get %v", idx, tc.description, err)
+		}
+		if v, err := q.Get([]byte("bad")); err != nil || string(v) != "corrupted" {
+			t.Errorf("[#Case%d] %s: expect the record to be quarantined, but get %s, %v", idx, tc.description, v, err)
+		}
+	}
+
+	// Quarantine failure is reported.
+	pool := Adapter(newCorruptedStore(), codec.Gob{}, WithQuarantine(Mock{
+		PutHandler: func(k, v []byte) error { return errMock },
+	}))
+	if _, err := pool.Get("bad"); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+	err := pool.Iter(func(k string, v cache.Item) error { return nil })
+	if err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestAdapterReplaceCorrupt(t *testing.T) {
+	testcases := []struct {
+		description    string
+		strategy       Option
+		scenario       func(pool cache.Pool) error
+		expectReplaced bool
+	}{
+		{"skip put", WithCorruptStrategy(SkipCorrupt), func(pool cache.Pool) error { return pool.Put("bad", 10) }, true},
+		{"skip incr ref", WithCorruptStrategy(SkipCorrupt), func(pool cache.Pool) error { return pool.IncrRef("bad") }, true},
+		{"skip decr ref", WithCorruptStrategy(SkipCorrupt), func(pool cache.Pool) error { return pool.DecrRef("bad") }, false},
+		{"quarantine put", WithQuarantine(gomap.New()), func(pool cache.Pool) error { return pool.Put("bad", 10) }, true},
+		{"quarantine decr ref", WithQuarantine(gomap.New()), func(pool cache.Pool) error { return pool.DecrRef("bad") }, false},
+	}
+
+	for idx, tc := range testcases {
+		store := newCorruptedStore()
+		pool := Adapter(store, codec.Gob{}, tc.strategy)
+		if err := tc.scenario(pool); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+
+		v, err := store.Get([]byte("bad"))
+		replaced := err == nil && string(v) != "corrupted"
+		if tc.expectReplaced != replaced {
+			t.Errorf("[#Case%d] %s: expect replaced to be %v, but get %v", idx, tc.description, tc.expectReplaced, replaced)
+		}
+	}
+
+	// The corrupted record is kept in the quarantine even if it is replaced.
+	store, q := newCorruptedStore(), gomap.New()
+	pool := Adapter(store, codec.Gob{}, WithQuarantine(q))
+	if err := pool.IncrRef("bad"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := q.Get([]byte("bad")); err != nil || string(v) != "corrupted" {
+		t.Errorf("expect the record to be quarantined, but get %s, %v", v, err)
+	}
+	if item, err := pool.Get("bad"); err != nil || item.Reference() != 1 {
+		t.Errorf("expect a referenced item, but get %v, %v", item, err)
+	}
+	if _, err := store.Get([]byte("bad")); err != nil {
+		t.Errorf("expect the record to be replaced, but get %v", err)
+	}
+
+	// DecrRef on a corrupted record removes it since nothing replaces it.
+	store, q = newCorruptedStore(), gomap.New()
+	pool = Adapter(store, codec.Gob{}, WithQuarantine(q))
+	if err := pool.DecrRef("bad"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get([]byte("bad")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// Quarantine failure is reported.
+	pool = Adapter(newCorruptedStore(), codec.Gob{}, WithQuarantine(Mock{
+		PutHandler: func(k, v []byte) error { return errMock },
+	}))
+	if err := pool.Put("bad", 10); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestAdapterEncodeError(t *testing.T) {
+	pool := Adapter(gomap.New(), codec.Mock{
+		MarshalFn: func(v interface{}) ([]byte, error) { return nil, errMock },
+	})
+
+	err := pool.Put("123", 10)
+	if eerr, ok := err.(*EncodeError); !ok || eerr.Key != "123" || eerr.Unwrap() != errMock || eerr.Cause() != errMock {
+		t.Errorf("expect an encode error, but get %v", err)
+	}
+	if err := pool.IncrRef("123"); err == nil || err.Error() != `failed to encode cache item "123": mock error` {
+		t.Errorf("expect an encode error, but get %v", err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	testcases := []struct {
+		description   string
+		opts          func(q Store) RepairOptions
+		expectRemoved bool
+		expectQuarant bool
+	}{
+		{"dry run", func(q Store) RepairOptions { return RepairOptions{DryRun: true} }, false, false},
+		{"drop", func(q Store) RepairOptions { return RepairOptions{} }, true, false},
+		{"quarantine", func(q Store) RepairOptions { return RepairOptions{Quarantine: q} }, true, true},
+	}
+
+	for idx, tc := range testcases {
+		store, q := newCorruptedStore(), gomap.New()
+		report, err := Repair(store, codec.Gob{}, tc.opts(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Scanned != 2 || len(report.Corrupted) != 1 || report.Corrupted[0] != "bad" {
+			t.Errorf("[#Case%d] %s: unexpect report %+v", idx, tc.description, report)
+		}
+		_, err = store.Get([]byte("bad"))
+		if removed := err == cache.ErrNoSuchKey; removed != tc.expectRemoved {
+			t.Errorf("[#Case%d] %s: expect removed %v, but get %v", idx, tc.description, tc.expectRemoved, removed)
+		}
+		_, err = q.Get([]byte("bad"))
+		if quarantined := err == nil; quarantined != tc.expectQuarant {
+			t.Errorf("[#Case%d] %s: expect quarantined %v, but get %v", idx, tc.description, tc.expectQuarant, quarantined)
+		}
+		if _, err := store.Get([]byte("good")); err != nil {
+			t.Errorf("[#Case%d] %s: expect good record remains, but get %v", idx, tc.description, err)
+		}
+	}
+
+	_, err := Repair(Mock{
+		IterHandler: func(func(k, v []byte) error) error { return errMock },
+	}, codec.Gob{}, RepairOptions{})
+	if err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
@@ -1,6 +1,8 @@
 package backend
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 )
 
@@ -8,3 +10,45 @@ import (
 var ErrDupKey = errors.New("cache key duplicates")
 
 var errMock = errors.New("mock error")
+
+// DecodeError raises when a stored record is unable to be decoded into a
+// cache item, which usually means the record is corrupted or encoded by
+// another codec.
+type DecodeError struct {
+	Key string
+	Err error
+}
+
+// Error implements error interface.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode cache item %q: %v", e.Key, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *DecodeError) Cause() error { return e.Err }
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// EncodeError raises when a cache item is unable to be encoded.
+type EncodeError struct {
+	Key string
+	Err error
+}
+
+// Error implements error interface.
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("failed to encode cache item %q: %v", e.Key, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *EncodeError) Cause() error { return e.Err }
+
+// Unwrap returns the underlying error.
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// IsDecodeError returns true if the error is a DecodeError.
+func IsDecodeError(err error) bool {
+	_, ok := err.(*DecodeError)
+	return ok
+}
@@ -0,0 +1,203 @@
+package gomap
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/meowdada/go-fcache/pkg/ioutil"
+)
+
+// wheelSlots is the number of slots of the timer wheel.
+const wheelSlots = 256
+
+// expireTick is the granularity of expiration. Expired key-value pairs are
+// removed within two ticks after their deadlines.
+var expireTick = 100 * time.Millisecond
+
+// wheel is a hashed timer wheel of deadlines. A key is put into the slot
+// of its deadline, and the slot of the elapsed tick is examined on every
+// tick. Keys whose deadlines are more than a round away are kept in their
+// slots until the round comes. It is guarded by the lock of the map.
+type wheel struct {
+	tick      time.Duration
+	slots     [wheelSlots]map[string]struct{}
+	deadlines map[string]deadline
+	last      int64
+	notify    func(k, v []byte)
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newWheel(tick time.Duration) *wheel {
+	w := &wheel{
+		tick:      tick,
+		deadlines: make(map[string]deadline),
+		stop:      make(chan struct{}),
+	}
+	for i := range w.slots {
+		w.slots[i] = make(map[string]struct{})
+	}
+	w.last = time.Now().UnixNano() / int64(tick)
+	return w
+}
+
+// deadline is the time a key expires at, and the tick of the slot it is
+// put into.
+type deadline struct {
+	at   time.Time
+	tick int64
+}
+
+func (w *wheel) slot(tick int64) map[string]struct{} {
+	return w.slots[tick%wheelSlots]
+}
+
+// add puts a key into the slot of its deadline. A deadline within a tick
+// which has been examined goes to the next tick, or it would wait for a
+// whole round.
+func (w *wheel) add(key string, at time.Time) {
+	tick := at.UnixNano() / int64(w.tick)
+	if tick < w.last {
+		tick = w.last
+	}
+	w.deadlines[key] = deadline{at: at, tick: tick}
+	w.slot(tick)[key] = struct{}{}
+}
+
+// Expire makes the key-value pair expire after ttl if the value of k is
+// still v. Deadlines are kept in memory only, so they are lost when a
+// persistent map is loaded again.
+func (m *Map) Expire(k, v []byte, ttl time.Duration) error {
+	return m.ExpireAll([][]byte{k}, [][]byte{v}, ttl)
+}
+
+// ExpireAll is like Expire, but sets the deadlines of all pairs with the lock
+// held once.
+func (m *Map) ExpireAll(ks, vs [][]byte, ttl time.Duration) error {
+	if len(ks) != len(vs) {
+		return errors.New("numbers of keys and values mismatch")
+	}
+	at := time.Now().Add(ttl)
+	m.lockFn(func() {
+		for i, k := range ks {
+			key := ioutil.Bytes2Str(k)
+			if cur, ok := m.ma[key]; !ok || !bytes.Equal(cur, vs[i]) {
+				continue
+			}
+			if ttl <= 0 {
+				m.unexpire(key)
+				continue
+			}
+			m.wheel().add(string(k), at)
+		}
+	})
+	return nil
+}
+
+// NotifyExpired makes fn invoked with each expired key-value pair after it
+// is removed. fn is invoked without the lock held.
+func (m *Map) NotifyExpired(fn func(k, v []byte)) {
+	m.lockFn(func() {
+		m.wheel().notify = fn
+	})
+}
+
+// wheel returns the timer wheel, and starts it if it is not started yet.
+// It must be called with the write lock held.
+func (m *Map) wheel() *wheel {
+	if m.w == nil {
+		m.w = newWheel(expireTick)
+		m.w.wg.Add(1)
+		go m.expireLoop(m.w)
+	}
+	return m.w
+}
+
+// unexpire clears the deadline of a key. Its entry in the slot is dropped
+// lazily when the slot is examined. It must be called with the write lock
+// held.
+func (m *Map) unexpire(key string) {
+	if m.w != nil {
+		delete(m.w.deadlines, key)
+	}
+}
+
+func (m *Map) expireLoop(w *wheel) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			m.expire(w, now)
+		}
+	}
+}
+
+// expire removes key-value pairs whose deadlines have passed, examining
+// the slot of every tick elapsed since the last call, and notifies them
+// after unlocking.
+func (m *Map) expire(w *wheel, now time.Time) {
+	var (
+		expired []pair
+		notify  func(k, v []byte)
+	)
+	m.lockFn(func() {
+		notify = w.notify
+		cur := now.UnixNano() / int64(w.tick)
+		from := w.last
+		if cur-from > wheelSlots {
+			from = cur - wheelSlots
+		}
+		for t := from; t < cur; t++ {
+			slot := w.slot(t)
+			for key := range slot {
+				// Drop entries whose deadlines are cleared or moved to
+				// another slot, and keep the ones due in a later round.
+				d, ok := w.deadlines[key]
+				if !ok || d.tick%wheelSlots != t%wheelSlots {
+					delete(slot, key)
+					continue
+				}
+				if d.tick > t {
+					continue
+				}
+				v := m.ma[key]
+				if err := m.log(opRemove, []byte(key), nil); err != nil {
+					continue
+				}
+				delete(m.ma, key)
+				delete(w.deadlines, key)
+				delete(slot, key)
+				expired = append(expired, pair{[]byte(key), v})
+			}
+		}
+		w.last = cur
+	})
+	if notify == nil {
+		return
+	}
+	for _, p := range expired {
+		notify(p.k, p.v)
+	}
+}
+
+// stopExpire stops the timer wheel, if any.
+func (m *Map) stopExpire() {
+	var w *wheel
+	m.lockFn(func() {
+		w, m.w = m.w, nil
+	})
+	if w != nil {
+		close(w.stop)
+		w.wg.Wait()
+	}
+}
+
+type pair struct {
+	k, v []byte
+}
@@ -0,0 +1,139 @@
+package gomap
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	defer func(tick time.Duration) { expireTick = tick }(expireTick)
+	expireTick = 10 * time.Millisecond
+
+	testcases := []struct {
+		description string
+		k           string
+		v           string
+		fn          func(m *Map, k, v []byte)
+		expired     bool
+	}{
+		{
+			"expire a pair",
+			"a", "1",
+			func(m *Map, k, v []byte) { m.Expire(k, v, 20*time.Millisecond) },
+			true,
+		},
+		{
+			"expire with a stale value",
+			"b", "2",
+			func(m *Map, k, v []byte) { m.Expire(k, []byte("x"), 20*time.Millisecond) },
+			false,
+		},
+		{
+			"put after expire",
+			"c", "3",
+			func(m *Map, k, v []byte) {
+				m.Expire(k, v, 20*time.Millisecond)
+				m.Put(k, v)
+			},
+			false,
+		},
+		{
+			"clear the expiry",
+			"d", "4",
+			func(m *Map, k, v []byte) {
+				m.Expire(k, v, 20*time.Millisecond)
+				m.Expire(k, v, 0)
+			},
+			false,
+		},
+		{
+			"remove after expire",
+			"e", "5",
+			func(m *Map, k, v []byte) {
+				m.Expire(k, v, 20*time.Millisecond)
+				m.Remove(k)
+				m.Put(k, v)
+			},
+			false,
+		},
+		{
+			"expire all with a missing key",
+			"h", "8",
+			func(m *Map, k, v []byte) {
+				m.ExpireAll([][]byte{k, []byte("missing")}, [][]byte{v, v}, 20*time.Millisecond)
+			},
+			true,
+		},
+		{
+			"expire all with a stale value",
+			"i", "9",
+			func(m *Map, k, v []byte) {
+				m.ExpireAll([][]byte{k}, [][]byte{[]byte("x")}, 20*time.Millisecond)
+			},
+			false,
+		},
+		{
+			"expire in a later round",
+			"f", "6",
+			func(m *Map, k, v []byte) { m.Expire(k, v, wheelSlots*expireTick+20*time.Millisecond) },
+			false,
+		},
+	}
+
+	var (
+		mu      sync.Mutex
+		expired []string
+	)
+	m := New()
+	defer m.Close()
+	m.NotifyExpired(func(k, v []byte) {
+		mu.Lock()
+		expired = append(expired, string(k)+"="+string(v))
+		mu.Unlock()
+	})
+	for _, tc := range testcases {
+		m.Put([]byte(tc.k), []byte(tc.v))
+		tc.fn(m, []byte(tc.k), []byte(tc.v))
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	sort.Strings(expired)
+	if len(expired) != 2 || expired[0] != "a=1" || expired[1] != "h=8" {
+		t.Errorf("expect expired pairs [a=1 h=8], but get %v", expired)
+	}
+	mu.Unlock()
+	for idx, tc := range testcases {
+		_, err := m.Get([]byte(tc.k))
+		if (err != nil) != tc.expired {
+			t.Errorf("[Case#%d]%s: expect expired %v, but get %v", idx, tc.description, tc.expired, err)
+		}
+	}
+}
+
+func TestExpirePersistent(t *testing.T) {
+	defer func(tick time.Duration) { expireTick = tick }(expireTick)
+	expireTick = 10 * time.Millisecond
+
+	opts, cleanup := tempOptions(t, PersistOptions{WAL: true})
+	defer cleanup()
+	m := load(t, opts)
+	m.Put([]byte("a"), []byte("1"))
+	m.Put([]byte("b"), []byte("2"))
+	m.Expire([]byte("a"), []byte("1"), time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := m.Get([]byte("a")); err == nil {
+		t.Fatal("expect a expired")
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m = load(t, opts)
+	defer m.Close()
+	if got := dump(t, m); got != "b=2;" {
+		t.Errorf("expect b=2; after reload, but get %s", got)
+	}
+}
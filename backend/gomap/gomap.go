@@ -1,6 +1,7 @@
 package gomap
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/meowdada/go-fcache/cache"
@@ -16,16 +17,27 @@ func New() *Map {
 type Map struct {
 	ma map[string][]byte
 	mu sync.RWMutex
+	p  *persister
+
+	// w is the timer wheel of deadlines, started by the first call to
+	// Expire or NotifyExpired.
+	w *wheel
 }
 
 // Put is a concurrent safe method which puts a byte array k as key
 // and byte array v as value into this map. If the key duplicates,
 // the new one will replace the old one and returns with no error.
-func (m *Map) Put(k, v []byte) error {
-	m.mu.Lock()
-	m.ma[ioutil.Bytes2Str(k)] = v
-	m.mu.Unlock()
-	return nil
+// Both k and v are copied, so it is fine to reuse them after putting.
+func (m *Map) Put(k, v []byte) (err error) {
+	v = append([]byte{}, v...)
+	m.lockFn(func() {
+		if err = m.log(opPut, k, v); err != nil {
+			return
+		}
+		m.unexpire(ioutil.Bytes2Str(k))
+		m.ma[string(k)] = v
+	})
+	return err
 }
 
 // Get is a concurrent safe method which gets a value of byte array v
@@ -41,14 +53,102 @@ func (m *Map) Get(k []byte) (v []byte, e error) {
 	return nil, cache.ErrNoSuchKey
 }
 
+// Update is a concurrent safe method which reads, modifies and writes
+// a key-value pair with the lock held, so it will not interleave with
+// other modifications. The old value passed to fn is nil if the key
+// does not present. If fn returns a nil value, nothing will be written.
+func (m *Map) Update(k []byte, fn func(old []byte) ([]byte, error)) (err error) {
+	m.lockFn(func() {
+		key := ioutil.Bytes2Str(k)
+		var v []byte
+		v, err = fn(m.ma[key])
+		if err != nil || v == nil {
+			return
+		}
+		if err = m.log(opPut, k, v); err != nil {
+			return
+		}
+		m.unexpire(key)
+		m.ma[string(k)] = append([]byte{}, v...)
+	})
+	return err
+}
+
+// Batch is a concurrent safe method which applies all the writes
+// made by fn with the lock held. Writes are staged until fn returns,
+// so none of them will be applied if fn returns an error.
+func (m *Map) Batch(fn func(txn Txn) error) (err error) {
+	m.lockFn(func() {
+		t := &txn{base: m.ma, writes: make(map[string][]byte)}
+		if err = fn(t); err != nil {
+			return
+		}
+		if err = m.logBatch(t.writes); err != nil {
+			return
+		}
+		for k, v := range t.writes {
+			m.unexpire(k)
+			if v == nil {
+				delete(m.ma, k)
+				continue
+			}
+			m.ma[k] = v
+		}
+	})
+	return err
+}
+
+// Txn is identical to backend.Txn.
+type Txn = interface {
+	Get(k []byte) (v []byte, e error)
+	Put(k, v []byte) error
+	Remove(k []byte) error
+}
+
+// txn stages writes of a batch. A staged nil value stands for
+// a removed key.
+type txn struct {
+	base   map[string][]byte
+	writes map[string][]byte
+}
+
+func (t *txn) Get(k []byte) ([]byte, error) {
+	key := ioutil.Bytes2Str(k)
+	v, ok := t.writes[key]
+	if !ok {
+		v, ok = t.base[key]
+	}
+	if !ok || v == nil {
+		return nil, cache.ErrNoSuchKey
+	}
+	return v, nil
+}
+
+func (t *txn) Put(k, v []byte) error {
+	t.writes[string(k)] = append([]byte{}, v...)
+	return nil
+}
+
+func (t *txn) Remove(k []byte) error {
+	t.writes[string(k)] = nil
+	return nil
+}
+
 // Remove is a concurrent safe method which removes an entry
 // from the map. It will return no error even if the key does
 // not present in the map.
-func (m *Map) Remove(k []byte) error {
-	m.mu.Lock()
-	delete(m.ma, ioutil.Bytes2Str(k))
-	m.mu.Unlock()
-	return nil
+func (m *Map) Remove(k []byte) (err error) {
+	m.lockFn(func() {
+		if _, ok := m.ma[ioutil.Bytes2Str(k)]; !ok {
+			return
+		}
+		if err = m.log(opRemove, k, nil); err != nil {
+			return
+		}
+		m.unexpire(ioutil.Bytes2Str(k))
+		delete(m.ma, ioutil.Bytes2Str(k))
+	})
+	return err
 }
 
 // Iter is a concurrent safe method which iterates key-value pairs
@@ -68,9 +168,46 @@ func (m *Map) Iter(iterCb func(k, v []byte) error) (err error) {
 	return err
 }
 
-// Close closes the map, actually it does nothing.
+// Scan is a concurrent safe method which iterates key-value pairs
+// in ascending order of keys, starting from the first key which is
+// equal to or greater than start. Keys are sorted on every call, and
+// like Iter, do not modify the map during scanning.
+func (m *Map) Scan(start []byte, iterCb func(k, v []byte) error) (err error) {
+	m.rlockFn(func() {
+		from := ioutil.Bytes2Str(start)
+		keys := make([]string, 0, len(m.ma))
+		for k := range m.ma {
+			if k >= from {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			err = iterCb(ioutil.Str2Bytes(k), m.ma[k])
+			if err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
+
+// Close closes the map. It stops expiring key-value pairs, and does
+// nothing else unless the map is loaded by Load, in which case the last
+// snapshot is taken.
 func (m *Map) Close() error {
-	return nil
+	m.stopExpire()
+	if m.p == nil {
+		return nil
+	}
+	return m.close()
+}
+
+func (m *Map) lockFn(fn func()) {
+	m.mu.Lock()
+	fn()
+	m.mu.Unlock()
 }
 
 func (m *Map) rlockFn(fn func()) {
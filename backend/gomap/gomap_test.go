@@ -3,8 +3,13 @@ package gomap
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
 	"github.com/meowdada/go-fcache/cache"
 )
 
@@ -208,6 +213,122 @@ func TestIter(t *testing.T) {
 	}
 }
 
+func TestScan(t *testing.T) {
+	m := New()
+	for _, k := range []string{"c", "a", "b", "ab"} {
+		m.Put([]byte(k), []byte(k))
+	}
+
+	var keys []string
+	err := m.Scan([]byte("aa"), func(k, v []byte) error {
+		if !bytes.Equal(k, v) {
+			t.Errorf("expect %s, but get %s", k, v)
+		}
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[ab b c]" {
+		t.Errorf("expect %v, but get %v", "[ab b c]", keys)
+	}
+
+	err = m.Scan(nil, func(k, v []byte) error { return cache.ErrNoSuchKey })
+	if err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m := New()
+	m.Put([]byte("a"), []byte("1"))
+
+	testcases := []struct {
+		description string
+		k           []byte
+		ret         []byte
+		retErr      error
+		expectOld   []byte
+		expectV     []byte
+	}{
+		{"update existing key", []byte("a"), []byte("2"), nil, []byte("1"), []byte("2")},
+		{"write nothing", []byte("a"), nil, nil, []byte("2"), []byte("2")},
+		{"abort by error", []byte("a"), []byte("3"), cache.ErrNoSuchKey, []byte("2"), []byte("2")},
+		{"insert missing key", []byte("b"), []byte("1"), nil, nil, []byte("1")},
+	}
+
+	for idx, tc := range testcases {
+		desc := tc.description
+		err := m.Update(tc.k, func(old []byte) ([]byte, error) {
+			if !bytes.Equal(old, tc.expectOld) {
+				t.Errorf("[Case#%d]%s: expect old value %s, but get %s", idx, desc, tc.expectOld, old)
+			}
+			return tc.ret, tc.retErr
+		})
+		if err != tc.retErr {
+			t.Errorf("[Case#%d]%s: expect %v, but get %v", idx, desc, tc.retErr, err)
+		}
+		if v, _ := m.Get(tc.k); !bytes.Equal(v, tc.expectV) {
+			t.Errorf("[Case#%d]%s: expect %s, but get %s", idx, desc, tc.expectV, v)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	m := New()
+	m.Put([]byte("a"), []byte("1"))
+	m.Put([]byte("b"), []byte("2"))
+
+	err := m.Batch(func(txn Txn) error {
+		txn.Put([]byte("a"), []byte("3"))
+		txn.Remove([]byte("b"))
+		txn.Put([]byte("c"), nil)
+		if v, err := txn.Get([]byte("a")); err != nil || string(v) != "3" {
+			t.Errorf("expect %s, but get %s, %v", "3", v, err)
+		}
+		if _, err := txn.Get([]byte("b")); err != cache.ErrNoSuchKey {
+			t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		k         []byte
+		expectV   []byte
+		expectErr error
+	}{
+		{[]byte("a"), []byte("3"), nil},
+		{[]byte("b"), nil, cache.ErrNoSuchKey},
+		{[]byte("c"), []byte{}, nil},
+	}
+	for idx, tc := range testcases {
+		v, err := m.Get(tc.k)
+		if err != tc.expectErr || !bytes.Equal(v, tc.expectV) {
+			t.Errorf("[Case#%d]: expect %s, %v, but get %s, %v", idx, tc.expectV, tc.expectErr, v, err)
+		}
+	}
+
+	// None of the writes will be applied if an error occurs.
+	err = m.Batch(func(txn Txn) error {
+		txn.Remove([]byte("a"))
+		txn.Put([]byte("d"), []byte("4"))
+		return cache.ErrNoSuchKey
+	})
+	if err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if _, err := m.Get([]byte("a")); err != nil {
+		t.Errorf("expect no error, but get %v", err)
+	}
+	if _, err := m.Get([]byte("d")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+}
+
 func TestClose(t *testing.T) {
 	m := New()
 	err := m.Close()
@@ -215,3 +336,24 @@ func TestClose(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+		return New()
+	})
+}
+
+func TestPersistentConformance(t *testing.T) {
+	backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+		dir, err := ioutil.TempDir("", "gomap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		m, err := Load(PersistOptions{Path: filepath.Join(dir, "fcache.snapshot"), WAL: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	})
+}
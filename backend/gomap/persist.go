@@ -0,0 +1,276 @@
+package gomap
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	fioutil "github.com/meowdada/go-fcache/pkg/ioutil"
+	"github.com/pkg/errors"
+)
+
+// snapshotMagic prefixes a snapshot file.
+const snapshotMagic = "FCMAPv1\n"
+
+// ErrBadSnapshot raises when a snapshot file is unable to be restored.
+var ErrBadSnapshot = errors.New("bad snapshot of gomap")
+
+// PersistOptions configures persistence of a map.
+type PersistOptions struct {
+	// Path is the snapshot file. The write-ahead log, if enabled, is the
+	// file with ".wal" suffix.
+	Path string
+	Mode os.FileMode
+
+	// Interval is the interval of periodic snapshots. A snapshot is also
+	// taken on Close. Periodic snapshots are disabled if it is not positive.
+	Interval time.Duration
+
+	// WAL enables the write-ahead log, which records changes between
+	// snapshots. Without it, changes after the last snapshot will be lost
+	// on crash.
+	WAL bool
+
+	// Sync makes every change flushed to the write-ahead log on disk before
+	// returning.
+	Sync bool
+}
+
+// Load restores a map from the snapshot and the write-ahead log given by
+// opts, and makes the map persist itself with opts. A missing snapshot is
+// restored as an empty map, and a torn tail of the write-ahead log, which is
+// left by a crash during writing, is truncated.
+func Load(opts PersistOptions) (*Map, error) {
+	if opts.Mode == 0 {
+		opts.Mode = 0644
+	}
+
+	m := New()
+	if err := readSnapshot(m.ma, opts.Path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	p := &persister{opts: opts, done: make(chan struct{})}
+	if opts.WAL {
+		var err error
+		if p.wal, err = openWAL(opts.Path+".wal", opts.Mode, m.ma); err != nil {
+			return nil, err
+		}
+	}
+	m.p = p
+
+	if opts.Interval > 0 {
+		p.wg.Add(1)
+		go m.snapshotLoop()
+	}
+	return m, nil
+}
+
+// Read restores a map from the snapshot at path and its write-ahead log, if
+// any, without modifying either of them, which is useful to read a map
+// persisted by another process. The map is not persisted, and a torn tail of
+// the write-ahead log is ignored. Unlike Load, a missing snapshot is an error.
+func Read(path string) (*Map, error) {
+	m := New()
+	if err := readSnapshot(m.ma, path); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path + ".wal")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	replay(m.ma, data)
+	return m, nil
+}
+
+// readSnapshot replays the snapshot at path into ma.
+func readSnapshot(ma map[string][]byte, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return ErrBadSnapshot
+	}
+	if _, err := replay(ma, data[len(snapshotMagic):]); err != nil {
+		return errors.Wrap(ErrBadSnapshot, err.Error())
+	}
+	return nil
+}
+
+// persister persists a map with snapshots and an optional write-ahead log.
+type persister struct {
+	opts PersistOptions
+	wal  *os.File
+
+	// snapMu serializes snapshots, and guards closing and closed.
+	snapMu  sync.Mutex
+	closing bool
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// openWAL replays the write-ahead log into ma, truncates its torn tail and
+// opens it for appending.
+func openWAL(path string, mode os.FileMode, ma map[string][]byte) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err == nil {
+		valid, _ := replay(ma, data)
+		if valid < len(data) {
+			err = f.Truncate(int64(valid))
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Snapshot writes all key-value pairs of the map into the snapshot file,
+// and truncates the write-ahead log. The snapshot is written into a
+// temporary file and then renamed, so the old one is kept if it fails.
+// Writers are blocked during the snapshot but readers are not. It does
+// nothing if the map is not loaded by Load.
+func (m *Map) Snapshot() (err error) {
+	if m.p == nil {
+		return nil
+	}
+	m.p.snapMu.Lock()
+	defer m.p.snapMu.Unlock()
+	if m.p.closed {
+		return nil
+	}
+	m.rlockFn(func() {
+		err = m.p.snapshot(m.ma)
+	})
+	return err
+}
+
+func (p *persister) snapshot(ma map[string][]byte) (err error) {
+	tmp := p.opts.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, p.opts.Mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	w.WriteString(snapshotMagic)
+	var buf []byte
+	for k, v := range ma {
+		buf = appendRecord(buf[:0], opPut, []byte(k), v)
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, p.opts.Path); err != nil {
+		return err
+	}
+	fioutil.SyncDir(filepath.Dir(p.opts.Path))
+
+	// Changes in the write-ahead log are all in the snapshot now, and
+	// no one is able to write it since writers are blocked.
+	if p.wal != nil {
+		return p.wal.Truncate(0)
+	}
+	return nil
+}
+
+// log appends a record to the write-ahead log, if any. It must be called
+// with the write lock held, before the change is applied.
+func (m *Map) log(op byte, k, v []byte) error {
+	if m.p == nil || m.p.wal == nil {
+		return nil
+	}
+	if _, err := m.p.wal.Write(appendRecord(nil, op, k, v)); err != nil {
+		return err
+	}
+	if m.p.opts.Sync {
+		return m.p.wal.Sync()
+	}
+	return nil
+}
+
+// logBatch appends staged writes of a batch to the write-ahead log as a
+// single record.
+func (m *Map) logBatch(writes map[string][]byte) error {
+	if m.p == nil || m.p.wal == nil || len(writes) == 0 {
+		return nil
+	}
+	var body []byte
+	for k, v := range writes {
+		if v == nil {
+			body = appendRecord(body, opRemove, []byte(k), nil)
+			continue
+		}
+		body = appendRecord(body, opPut, []byte(k), v)
+	}
+	return m.log(opBatch, nil, body)
+}
+
+// snapshotLoop takes snapshots periodically until the map is closed. Errors
+// are ignored since the snapshot will be retried later.
+func (m *Map) snapshotLoop() {
+	defer m.p.wg.Done()
+	ticker := time.NewTicker(m.p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.p.done:
+			return
+		case <-ticker.C:
+			m.Snapshot()
+		}
+	}
+}
+
+// close stops periodic snapshots, takes the last snapshot and closes the
+// write-ahead log.
+func (m *Map) close() error {
+	p := m.p
+	p.snapMu.Lock()
+	if p.closing {
+		p.snapMu.Unlock()
+		return nil
+	}
+	p.closing = true
+	p.snapMu.Unlock()
+
+	close(p.done)
+	p.wg.Wait()
+
+	err := m.Snapshot()
+	p.snapMu.Lock()
+	p.closed = true
+	p.snapMu.Unlock()
+	if p.wal != nil {
+		if cerr := p.wal.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
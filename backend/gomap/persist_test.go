@@ -0,0 +1,244 @@
+package gomap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempOptions(t *testing.T, opts PersistOptions) (PersistOptions, func()) {
+	dir, err := ioutil.TempDir("", "gomap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Path = filepath.Join(dir, "fcache.snapshot")
+	return opts, func() { os.RemoveAll(dir) }
+}
+
+func dump(t *testing.T, m *Map) string {
+	var buf bytes.Buffer
+	err := m.Scan(nil, func(k, v []byte) error {
+		fmt.Fprintf(&buf, "%s=%s;", k, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func load(t *testing.T, opts PersistOptions) *Map {
+	m, err := Load(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func mutate(t *testing.T, m *Map) {
+	m.Put([]byte("a"), []byte("1"))
+	m.Put([]byte("b"), []byte("2"))
+	m.Put([]byte("c"), []byte("3"))
+	m.Remove([]byte("b"))
+	m.Update([]byte("a"), func(old []byte) ([]byte, error) {
+		return append(old, '1'), nil
+	})
+	err := m.Batch(func(txn Txn) error {
+		txn.Put([]byte("d"), []byte("4"))
+		return txn.Remove([]byte("c"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	testcases := []struct {
+		description string
+		opts        PersistOptions
+		close       bool
+		expect      string
+	}{
+		{"snapshot on close", PersistOptions{}, true, "a=11;d=4;"},
+		{"snapshot on close with wal", PersistOptions{WAL: true, Sync: true}, true, "a=11;d=4;"},
+		{"recover from wal", PersistOptions{WAL: true}, false, "a=11;d=4;"},
+		{"lose changes without wal", PersistOptions{}, false, ""},
+	}
+
+	for idx, tc := range testcases {
+		opts, cleanup := tempOptions(t, tc.opts)
+		m := load(t, opts)
+		if s := dump(t, m); s != "" {
+			t.Errorf("[#Case%d] %s: expect an empty map, but get %v", idx, tc.description, s)
+		}
+		mutate(t, m)
+		if tc.close {
+			if err := m.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		m2 := load(t, opts)
+		if s := dump(t, m2); s != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, s)
+		}
+		m2.Close()
+		m.Close()
+		cleanup()
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	opts, cleanup := tempOptions(t, PersistOptions{WAL: true})
+	defer cleanup()
+
+	m := load(t, opts)
+	m.Put([]byte("a"), []byte("1"))
+	if err := m.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(opts.Path + ".wal"); err != nil || fi.Size() != 0 {
+		t.Errorf("expect the wal to be truncated, but get %v", err)
+	}
+	m.Put([]byte("b"), []byte("2"))
+
+	// Changes after the snapshot are recovered from the wal.
+	m2 := load(t, opts)
+	if s := dump(t, m2); s != "a=1;b=2;" {
+		t.Errorf("expect %v, but get %v", "a=1;b=2;", s)
+	}
+	m.Close()
+	m2.Close()
+
+	if err := m.Snapshot(); err != nil {
+		t.Errorf("expect no error after closing, but get %v", err)
+	}
+	if err := New().Snapshot(); err != nil {
+		t.Errorf("expect no error for a map without persistence, but get %v", err)
+	}
+}
+
+func TestPeriodicSnapshot(t *testing.T) {
+	opts, cleanup := tempOptions(t, PersistOptions{Interval: time.Millisecond})
+	defer cleanup()
+
+	m := load(t, opts)
+	defer m.Close()
+	m.Put([]byte("a"), []byte("1"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m2 := load(t, PersistOptions{Path: opts.Path})
+		s := dump(t, m2)
+		m2.Close()
+		if s == "a=1;" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect a snapshot to be taken periodically")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRecoverWAL(t *testing.T) {
+	opts, cleanup := tempOptions(t, PersistOptions{WAL: true})
+	defer cleanup()
+
+	m := load(t, opts)
+	m.Put([]byte("a"), []byte("1"))
+	m.Put([]byte("b"), []byte("2"))
+	fi, _ := os.Stat(opts.Path + ".wal")
+	valid := fi.Size()
+
+	batch := appendRecord(nil, opPut, []byte("c"), []byte("3"))
+	tails := [][]byte{
+		{opPut},
+		appendRecord(nil, opPut, []byte("c"), []byte("3"))[:5],
+		appendRecord(nil, opBatch, nil, batch)[:10],
+		append(appendRecord(nil, opPut, []byte("c"), []byte("3"))[:5], 0, 0, 0, 0),
+		appendRecord(nil, opBatch, nil, appendRecord(nil, opBatch, nil, nil)),
+	}
+	for idx, tail := range tails {
+		f, err := os.OpenFile(opts.Path+".wal", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(tail)
+		f.Close()
+
+		m2 := load(t, opts)
+		if s := dump(t, m2); s != "a=1;b=2;" {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, "a=1;b=2;", s)
+		}
+		if fi, _ := os.Stat(opts.Path + ".wal"); fi.Size() != valid {
+			t.Errorf("[#Case%d]: expect the tail to be truncated to %d, but get %d", idx, valid, fi.Size())
+		}
+	}
+}
+
+func TestLoadBadSnapshot(t *testing.T) {
+	opts, cleanup := tempOptions(t, PersistOptions{})
+	defer cleanup()
+
+	testcases := []struct {
+		description string
+		data        []byte
+	}{
+		{"bad magic", []byte("bad")},
+		{"corrupted record", append([]byte(snapshotMagic), 1, 2, 3)},
+	}
+
+	for idx, tc := range testcases {
+		ioutil.WriteFile(opts.Path, tc.data, 0644)
+		if _, err := Load(opts); err == nil {
+			t.Errorf("[#Case%d] %s: expect error occurs, but get no error", idx, tc.description)
+		}
+	}
+
+	if _, err := Load(PersistOptions{Path: "/dev/null/fcache", WAL: true}); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestRead(t *testing.T) {
+	opts, cleanup := tempOptions(t, PersistOptions{WAL: true})
+	defer cleanup()
+
+	if _, err := Read(opts.Path); !os.IsNotExist(err) {
+		t.Errorf("expect a missing snapshot error, but get %v", err)
+	}
+	if _, err := os.Stat(opts.Path + ".wal"); !os.IsNotExist(err) {
+		t.Errorf("expect no write-ahead log created, but get %v", err)
+	}
+
+	// Changes after the snapshot are read from the write-ahead log, which
+	// is left intact.
+	m := load(t, opts)
+	defer m.Close()
+	m.Put([]byte("a"), []byte("1"))
+	if err := m.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	m.Put([]byte("b"), []byte("2"))
+	wal, _ := ioutil.ReadFile(opts.Path + ".wal")
+
+	r, err := Read(opts.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Put([]byte("c"), []byte("3"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dump(t, r); got != "a=1;b=2;c=3;" {
+		t.Errorf("expect a=1;b=2;c=3;, but get %s", got)
+	}
+	if got, _ := ioutil.ReadFile(opts.Path + ".wal"); !bytes.Equal(got, wal) {
+		t.Errorf("expect the write-ahead log unchanged, but get %q", got)
+	}
+}
@@ -0,0 +1,123 @@
+package gomap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/pkg/errors"
+)
+
+// Both snapshots and write-ahead logs are sequences of records laid out as:
+//
+//	op (1) | key length (uvarint) | value length (uvarint) | key | value | crc (4)
+//
+// where crc is the CRC32C checksum of the rest of the record. A batch is a
+// record whose value consists of the records in the batch, so that it is
+// either replayed as a whole or not at all.
+const (
+	opPut byte = iota + 1
+	opRemove
+	opBatch
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errTorn     = errors.New("torn record")
+	errChecksum = errors.New("checksum mismatch")
+)
+
+// appendRecord appends an encoded record to buf.
+func appendRecord(buf []byte, op byte, k, v []byte) []byte {
+	start := len(buf)
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, op)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(k)))]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(v)))]...)
+	buf = append(buf, k...)
+	buf = append(buf, v...)
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.Checksum(buf[start:], castagnoli))
+	return append(buf, sum[:]...)
+}
+
+// decodeRecord decodes the first record of buf and returns its encoded
+// size. Key and value refer to buf.
+func decodeRecord(buf []byte) (op byte, k, v []byte, n int, err error) {
+	if len(buf) < 1 {
+		return 0, nil, nil, 0, errTorn
+	}
+	klen, n1 := binary.Uvarint(buf[1:])
+	if n1 <= 0 {
+		return 0, nil, nil, 0, errTorn
+	}
+	vlen, n2 := binary.Uvarint(buf[1+n1:])
+	if n2 <= 0 {
+		return 0, nil, nil, 0, errTorn
+	}
+	hdr := uint64(1 + n1 + n2)
+	if klen > uint64(len(buf)) || vlen > uint64(len(buf)) || hdr+klen+vlen+4 > uint64(len(buf)) {
+		return 0, nil, nil, 0, errTorn
+	}
+
+	end := int(hdr + klen + vlen)
+	if crc32.Checksum(buf[:end], castagnoli) != binary.LittleEndian.Uint32(buf[end:]) {
+		return 0, nil, nil, 0, errChecksum
+	}
+	op = buf[0]
+	if op < opPut || op > opBatch {
+		return 0, nil, nil, 0, errChecksum
+	}
+	k = buf[hdr : hdr+klen]
+	v = buf[hdr+klen : end]
+	return op, k, v, end + 4, nil
+}
+
+// replay applies records of buf to ma, and returns the size of the valid
+// records. It stops at the first invalid record with its error.
+func replay(ma map[string][]byte, buf []byte) (int, error) {
+	off := 0
+	for off < len(buf) {
+		op, k, v, n, err := decodeRecord(buf[off:])
+		if err == nil && op == opBatch {
+			err = validBatch(v)
+		}
+		if err != nil {
+			return off, err
+		}
+		apply(ma, op, k, v)
+		off += n
+	}
+	return off, nil
+}
+
+func validBatch(body []byte) error {
+	for len(body) > 0 {
+		op, _, _, n, err := decodeRecord(body)
+		if err != nil {
+			return err
+		}
+		if op == opBatch {
+			return errChecksum
+		}
+		body = body[n:]
+	}
+	return nil
+}
+
+// apply applies a valid record to ma. Values are copied since they refer
+// to the buffer they are decoded from.
+func apply(ma map[string][]byte, op byte, k, v []byte) {
+	switch op {
+	case opPut:
+		ma[string(k)] = append([]byte{}, v...)
+	case opRemove:
+		delete(ma, string(k))
+	case opBatch:
+		for len(v) > 0 {
+			op, k, v2, n, _ := decodeRecord(v)
+			apply(ma, op, k, v2)
+			v = v[n:]
+		}
+	}
+}
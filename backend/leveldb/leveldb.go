@@ -0,0 +1,169 @@
+// Package leveldb implements backend.Store with goleveldb, a pure Go port of
+// LevelDB. Keys are scoped by a prefix so that several caches are able to
+// share one database.
+package leveldb
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/meowdada/go-fcache/cache"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Open opens the database at opts.Path and returns a store scoped by
+// opts.Prefix. The database will be closed by Close.
+func Open(opts Options) (*LevelDB, error) {
+	db, err := goleveldb.OpenFile(opts.Path, opts.Options)
+	if err != nil {
+		return nil, err
+	}
+	l := New(db, opts.Prefix)
+	l.owned = true
+	return l, nil
+}
+
+// New is a factory method to create a store scoped by prefix with an opened
+// database, which is useful to share a database between caches or with other
+// parts of an application. The database will not be closed by Close.
+//
+// Keys are stored with the length of the prefix before it, so prefixes such
+// as "cache" and "cache2" never see keys of each other. An empty prefix
+// scopes nothing, which makes the store see every key of the database, so
+// it should not share the database with others.
+func New(db *goleveldb.DB, prefix string) *LevelDB {
+	return &LevelDB{core: db, prefix: namespace(prefix)}
+}
+
+// namespace encodes a prefix with its length, so no namespace is a prefix
+// of another one.
+func namespace(prefix string) []byte {
+	if prefix == "" {
+		return nil
+	}
+	ns := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(prefix))
+	n := binary.PutUvarint(ns, uint64(len(prefix)))
+	return append(ns[:n], prefix...)
+}
+
+// LevelDB implements backend.Store interface.
+type LevelDB struct {
+	core   *goleveldb.DB
+	prefix []byte
+	owned  bool
+
+	// mu serializes writes of the store, so that Update and Batch are
+	// atomic with respect to other writes. Stores with different non-empty
+	// prefixes never touch the same key, so they need not to share it.
+	mu sync.Mutex
+}
+
+// Put puts a key-value pair into the database.
+func (l *LevelDB) Put(k, v []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.core.Put(l.key(k), v, nil)
+}
+
+// Get gets a value by given key from the database. If the key does not
+// present, it returns cache.ErrNoSuchKey.
+func (l *LevelDB) Get(k []byte) ([]byte, error) {
+	return l.get(k)
+}
+
+// Update reads, modifies and writes a key-value pair with the write lock
+// held. The old value passed to fn is nil if the key does not present. If
+// fn returns a nil value, nothing will be written.
+func (l *LevelDB) Update(k []byte, fn func(old []byte) ([]byte, error)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old, err := l.get(k)
+	if err != nil && err != cache.ErrNoSuchKey {
+		return err
+	}
+	v, err := fn(old)
+	if err != nil || v == nil {
+		return err
+	}
+	return l.core.Put(l.key(k), v, nil)
+}
+
+// Batch applies all the writes made by fn as a single leveldb batch, which
+// is written atomically. Writes are staged until fn returns, so none of them
+// will be applied if fn returns an error.
+func (l *LevelDB) Batch(fn func(txn Txn) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t := &txn{l: l, writes: make(map[string][]byte)}
+	if err := fn(t); err != nil {
+		return err
+	}
+	if len(t.writes) == 0 {
+		return nil
+	}
+	batch := new(goleveldb.Batch)
+	for k, v := range t.writes {
+		if v == nil {
+			batch.Delete(l.key([]byte(k)))
+			continue
+		}
+		batch.Put(l.key([]byte(k)), v)
+	}
+	return l.core.Write(batch, nil)
+}
+
+// Remove removes a key-value pair from the database. It will return no error
+// even if the key does not present.
+func (l *LevelDB) Remove(k []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.core.Delete(l.key(k), nil)
+}
+
+// Iter iterates all key-value pairs of the store in ascending order of keys.
+// Keys and values are only valid within iterCb.
+func (l *LevelDB) Iter(iterCb func(k, v []byte) error) error {
+	return l.Scan(nil, iterCb)
+}
+
+// Scan iterates key-value pairs of the store in ascending order of keys,
+// starting from the first key which is equal to or greater than start.
+// Keys and values are only valid within iterCb.
+func (l *LevelDB) Scan(start []byte, iterCb func(k, v []byte) error) error {
+	r := util.BytesPrefix(l.prefix)
+	if len(start) > 0 {
+		r.Start = l.key(start)
+	}
+	it := l.core.NewIterator(r, nil)
+	defer it.Release()
+	for it.Next() {
+		if err := iterCb(it.Key()[len(l.prefix):], it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// Close closes the database if it is opened by Open.
+func (l *LevelDB) Close() error {
+	if !l.owned {
+		return nil
+	}
+	return l.core.Close()
+}
+
+func (l *LevelDB) get(k []byte) ([]byte, error) {
+	v, err := l.core.Get(l.key(k), nil)
+	if err == goleveldb.ErrNotFound {
+		return nil, cache.ErrNoSuchKey
+	}
+	return v, err
+}
+
+// key prepends the namespace to k.
+func (l *LevelDB) key(k []byte) []byte {
+	key := make([]byte, 0, len(l.prefix)+len(k))
+	key = append(key, l.prefix...)
+	return append(key, k...)
+}
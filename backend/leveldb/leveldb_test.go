@@ -0,0 +1,225 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+var errMock = errors.New("mock error")
+
+func tempDB(t *testing.T, prefix string) (*LevelDB, func()) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(Options{Path: filepath.Join(dir, "db"), Prefix: prefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, func() { l.Close(); os.RemoveAll(dir) }
+}
+
+func dump(t *testing.T, l *LevelDB, start string) string {
+	var buf bytes.Buffer
+	err := l.Scan([]byte(start), func(k, v []byte) error {
+		fmt.Fprintf(&buf, "%s=%s;", k, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestLevelDB(t *testing.T) {
+	l, cleanup := tempDB(t, "cache/")
+	defer cleanup()
+
+	testcases := []struct {
+		description string
+		scenario    func() error
+		expect      string
+	}{
+		{"put", func() error { return l.Put([]byte("b"), []byte("1")) }, "b=1;"},
+		{"put another", func() error { return l.Put([]byte("a"), []byte("2")) }, "a=2;b=1;"},
+		{"overwrite", func() error { return l.Put([]byte("b"), []byte("3")) }, "a=2;b=3;"},
+		{"remove", func() error { return l.Remove([]byte("a")) }, "b=3;"},
+		{"remove missing key", func() error { return l.Remove([]byte("a")) }, "b=3;"},
+		{"update", func() error {
+			return l.Update([]byte("b"), func(old []byte) ([]byte, error) {
+				return append(old, '4'), nil
+			})
+		}, "b=34;"},
+		{"update without writing", func() error {
+			return l.Update([]byte("c"), func(old []byte) ([]byte, error) {
+				if old != nil {
+					t.Errorf("expect nil old value, but get %s", old)
+				}
+				return nil, nil
+			})
+		}, "b=34;"},
+		{"abort update", func() error {
+			err := l.Update([]byte("b"), func(old []byte) ([]byte, error) {
+				return []byte("5"), errMock
+			})
+			if err != errMock {
+				return errors.Errorf("expect %v, but get %v", errMock, err)
+			}
+			return nil
+		}, "b=34;"},
+		{"batch", func() error {
+			return l.Batch(func(txn Txn) error {
+				txn.Put([]byte("c"), []byte("6"))
+				txn.Put([]byte("d"), []byte("7"))
+				txn.Remove([]byte("d"))
+				if _, err := txn.Get([]byte("d")); err != cache.ErrNoSuchKey {
+					return errors.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+				}
+				v, err := txn.Get([]byte("b"))
+				if err != nil {
+					return err
+				}
+				return txn.Put([]byte("a"), v)
+			})
+		}, "a=34;b=34;c=6;"},
+		{"abort batch", func() error {
+			err := l.Batch(func(txn Txn) error {
+				txn.Remove([]byte("a"))
+				return errMock
+			})
+			if err != errMock {
+				return errors.Errorf("expect %v, but get %v", errMock, err)
+			}
+			return nil
+		}, "a=34;b=34;c=6;"},
+	}
+
+	for idx, tc := range testcases {
+		if err := tc.scenario(); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+		if got := dump(t, l, ""); got != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, got)
+		}
+	}
+
+	if v, err := l.Get([]byte("c")); err != nil || string(v) != "6" {
+		t.Errorf("expect %v, but get %s, %v", "6", v, err)
+	}
+	if _, err := l.Get([]byte("d")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if got := dump(t, l, "b"); got != "b=34;c=6;" {
+		t.Errorf("expect %v, but get %v", "b=34;c=6;", got)
+	}
+	if err := l.Iter(func(k, v []byte) error { return errMock }); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	l, cleanup := tempDB(t, "")
+	defer cleanup()
+
+	// Prefixes overlapping each other are still isolated.
+	a, b, c := New(l.core, "a/"), New(l.core, "b/"), New(l.core, "a/2")
+	a.Put([]byte("1"), []byte("a1"))
+	a.Put([]byte("2"), []byte("a2"))
+	b.Put([]byte("1"), []byte("b1"))
+	c.Put([]byte("x"), []byte("cx"))
+
+	testcases := []struct {
+		description string
+		store       *LevelDB
+		start       string
+		expect      string
+	}{
+		{"iterate a", a, "", "1=a1;2=a2;"},
+		{"scan a", a, "2", "2=a2;"},
+		{"iterate b", b, "", "1=b1;"},
+		{"scan b after its last key", b, "2", ""},
+		{"iterate a prefix extending another one", c, "", "x=cx;"},
+		{"iterate the whole database", l, "", "\x02a/1=a1;\x02a/2=a2;\x02b/1=b1;\x03a/2x=cx;"},
+	}
+
+	for idx, tc := range testcases {
+		if got := dump(t, tc.store, tc.start); got != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, got)
+		}
+	}
+
+	if _, err := b.Get([]byte("2")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// Stores created by New never close the shared database.
+	if err := a.Close(); err != nil {
+		t.Error(err)
+	}
+	if v, err := b.Get([]byte("1")); err != nil || string(v) != "b1" {
+		t.Errorf("expect %v, but get %s, %v", "b1", v, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open(Options{Path: "/dev/null/db"}); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+
+	l, cleanup := tempDB(t, "cache/")
+	defer cleanup()
+	l.Put([]byte("a"), []byte("1"))
+	db := l.core
+	l.Close()
+	if err := db.Put([]byte("b"), nil, nil); err == nil {
+		t.Errorf("expect the database to be closed, but get no error")
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	l, cleanup := tempDB(t, "cache/")
+	defer cleanup()
+
+	pool := backend.Adapter(l, codec.Gob{})
+	if err := pool.Put("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.IncrRef("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	item, err := pool.Get("a")
+	if err != nil || !item.IsReal() || item.Reference() != 1 {
+		t.Errorf("expect a referenced real item, but get %v, %v", item, err)
+	}
+
+	var keys []string
+	pool.(cache.Scanner).Scan("", func(k string, v cache.Item) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if fmt.Sprint(keys) != "[a b]" {
+		t.Errorf("expect %v, but get %v", "[a b]", keys)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+		l, cleanup := tempDB(t, "cache/")
+		t.Cleanup(cleanup)
+
+		// Keys of other prefixes must be invisible to the store.
+		New(l.core, "cachf/").Put([]byte("a"), []byte("other"))
+		New(l.core, "cache").Put([]byte("a"), []byte("other"))
+		return l
+	})
+}
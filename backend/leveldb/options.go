@@ -0,0 +1,15 @@
+package leveldb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Options configures leveldb instance.
+type Options struct {
+	Path string
+
+	// Prefix scopes keys of the store, so that several caches are able to
+	// share one database with different non-empty prefixes.
+	Prefix  string
+	Options *opt.Options
+}
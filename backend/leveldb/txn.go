@@ -0,0 +1,39 @@
+package leveldb
+
+import (
+	"github.com/meowdada/go-fcache/cache"
+)
+
+// Txn is identical to backend.Txn.
+type Txn = interface {
+	Get(k []byte) (v []byte, e error)
+	Put(k, v []byte) error
+	Remove(k []byte) error
+}
+
+// txn stages writes of a batch over the database. A staged nil value stands
+// for a removed key. The write lock is held by Batch.
+type txn struct {
+	l      *LevelDB
+	writes map[string][]byte
+}
+
+func (t *txn) Get(k []byte) ([]byte, error) {
+	if v, ok := t.writes[string(k)]; ok {
+		if v == nil {
+			return nil, cache.ErrNoSuchKey
+		}
+		return v, nil
+	}
+	return t.l.get(k)
+}
+
+func (t *txn) Put(k, v []byte) error {
+	t.writes[string(k)] = append([]byte{}, v...)
+	return nil
+}
+
+func (t *txn) Remove(k []byte) error {
+	t.writes[string(k)] = nil
+	return nil
+}
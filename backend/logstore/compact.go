@@ -0,0 +1,107 @@
+package logstore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/meowdada/go-fcache/pkg/ioutil"
+)
+
+// Compact rewrites the log with live records only, and replaces the old log
+// with it atomically. Live records are copied without blocking others, and
+// only records appended during copying are replayed with the lock held.
+func (l *Log) Compact() error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	var (
+		end  int64
+		live []liveEntry
+	)
+	err := l.rlockFn(func() error {
+		end = l.size
+		live = make([]liveEntry, 0, len(l.index))
+		for k, e := range l.index {
+			live = append(live, liveEntry{k, e})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := l.opts.Path + ".compact"
+	out, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, l.opts.Mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	// The old log is only appended until it is replaced, which is
+	// serialized by compactMu, so records before end are safe to be
+	// read without the lock. Read them in order of offsets.
+	sort.Slice(live, func(i, j int) bool { return live[i].e.off < live[j].e.off })
+	var (
+		w     = bufio.NewWriter(out)
+		index = make(map[string]entry, len(live))
+		size  int64
+		buf   []byte
+	)
+	for _, le := range live {
+		var v []byte
+		if v, err = l.read(le.e); err != nil {
+			return err
+		}
+		buf = appendRecord(buf[:0], opPut, []byte(le.k), v)
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+		n := int64(len(buf))
+		index[le.k] = entry{off: size + n - le.e.vlen, vlen: le.e.vlen, n: n}
+		size += n
+	}
+
+	err = l.lockFn(func() error {
+		// Replay records appended during copying.
+		tail := io.NewSectionReader(l.f, end, l.size-end)
+		if _, err := io.Copy(w, tail); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		var garbage int64
+		newSize, err := scan(out, size, size+l.size-end, func(rec record, off, n int64) error {
+			garbage += apply(index, rec, off, n)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, l.opts.Path); err != nil {
+			return err
+		}
+		ioutil.SyncDir(filepath.Dir(l.opts.Path))
+
+		l.f.Close()
+		l.f, l.size, l.garbage, l.index = out, newSize, garbage, index
+		return nil
+	})
+	return err
+}
+
+type liveEntry struct {
+	k string
+	e entry
+}
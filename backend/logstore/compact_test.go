@@ -0,0 +1,113 @@
+package logstore
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	l, cleanup := tempLog(t, Options{})
+	defer cleanup()
+
+	for i := 0; i < 100; i++ {
+		l.Put([]byte(fmt.Sprint(i%10)), []byte(fmt.Sprint(i)))
+	}
+	l.Remove([]byte("0"))
+	expect := dump(t, l)
+	before := l.size
+
+	// Keep writing during compaction, these writes must not be lost.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := l.Put([]byte(fmt.Sprintf("new-%03d", i)), []byte("v")); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		expect += fmt.Sprintf("new-%03d=v;", i)
+	}
+	if s := dump(t, l); s != expect {
+		t.Errorf("expect %v, but get %v", expect, s)
+	}
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if l.garbage != 0 || l.size >= before+100*size([]byte("new-000"), []byte("v")) {
+		t.Errorf("expect the log to be compacted, but get size %d with garbage %d", l.size, l.garbage)
+	}
+	if _, err := os.Stat(l.opts.Path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expect the temporary file to be removed, but get %v", err)
+	}
+
+	l = reopen(t, l)
+	if s := dump(t, l); s != expect {
+		t.Errorf("expect %v, but get %v", expect, s)
+	}
+
+	l.Close()
+	if err := l.Compact(); err != ErrClosed {
+		t.Errorf("expect %v, but get %v", ErrClosed, err)
+	}
+}
+
+func TestBackgroundCompact(t *testing.T) {
+	l, cleanup := tempLog(t, Options{
+		CompactInterval:   time.Millisecond,
+		CompactMinGarbage: 1,
+	})
+	defer cleanup()
+
+	for i := 0; i < 100; i++ {
+		l.Put([]byte("key"), []byte(fmt.Sprint(i)))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !l.compacted() {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the log to be compacted in background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, err := l.Get([]byte("key")); err != nil || string(v) != "99" {
+		t.Errorf("expect %v, but get %s, %v", "99", v, err)
+	}
+}
+
+func (l *Log) compacted() (ok bool) {
+	l.rlockFn(func() error {
+		ok = l.garbage == 0
+		return nil
+	})
+	return ok
+}
+
+func TestCompactInterval(t *testing.T) {
+	testcases := []struct {
+		interval time.Duration
+		expect   time.Duration
+	}{
+		{0, DefaultCompactInterval},
+		{time.Second, time.Second},
+		{-1, -1},
+	}
+
+	for idx, tc := range testcases {
+		l, cleanup := tempLog(t, Options{CompactInterval: tc.interval})
+		if l.opts.CompactInterval != tc.expect {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, l.opts.CompactInterval)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,381 @@
+// Package logstore implements backend.Store as an append-only log file with an
+// in-memory index of keys. Every write appends a CRC-checked record to the log,
+// so it costs a single sequential write no matter how large the store is.
+// Overwritten and removed records are reclaimed by compaction.
+package logstore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/ioutil"
+	"github.com/pkg/errors"
+)
+
+// ErrClosed raises when operating a closed log store.
+var ErrClosed = errors.New("log store is closed")
+
+// Open opens a log store, or creates one if the file does not exist. The log
+// is replayed to build the index, and a torn or corrupted tail, which is left
+// by a crash during writing, is truncated.
+func Open(opts Options) (*Log, error) {
+	if opts.Mode == 0 {
+		opts.Mode = 0644
+	}
+	if opts.CompactRatio <= 0 {
+		opts.CompactRatio = DefaultCompactRatio
+	}
+	if opts.CompactMinGarbage <= 0 {
+		opts.CompactMinGarbage = DefaultCompactMinGarbage
+	}
+	if opts.CompactInterval == 0 {
+		opts.CompactInterval = DefaultCompactInterval
+	}
+
+	f, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, opts.Mode)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &Log{
+		opts:  opts,
+		f:     f,
+		index: make(map[string]entry),
+		done:  make(chan struct{}),
+	}
+	l.size, err = scan(f, 0, fi.Size(), func(rec record, off, n int64) error {
+		l.garbage += apply(l.index, rec, off, n)
+		return nil
+	})
+	if err == nil && l.size < fi.Size() {
+		err = f.Truncate(l.size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if opts.CompactInterval > 0 {
+		l.wg.Add(1)
+		go l.compactLoop()
+	}
+	return l, nil
+}
+
+// Log implements backend.Store interface.
+type Log struct {
+	opts    Options
+	f       *os.File
+	size    int64
+	garbage int64
+	index   map[string]entry
+	closed  bool
+	mu      sync.RWMutex
+
+	// compactMu serializes compactions.
+	compactMu sync.Mutex
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// entry locates the value of a key in the log.
+type entry struct {
+	off  int64 // offset of the value
+	vlen int64 // length of the value
+	n    int64 // size of the whole record
+}
+
+// Put appends a record to put a key-value pair into the log.
+func (l *Log) Put(k, v []byte) error {
+	return l.lockFn(func() error {
+		return l.append(opPut, k, v)
+	})
+}
+
+// Get gets the value of given key from the log. If the key does not
+// present, it returns cache.ErrNoSuchKey.
+func (l *Log) Get(k []byte) (v []byte, err error) {
+	err = l.rlockFn(func() error {
+		e, ok := l.index[ioutil.Bytes2Str(k)]
+		if !ok {
+			return cache.ErrNoSuchKey
+		}
+		v, err = l.read(e)
+		return err
+	})
+	return v, err
+}
+
+// Update reads, modifies and writes a key-value pair with the lock held.
+// The old value passed to fn is nil if the key does not present. If fn
+// returns a nil value, nothing will be written.
+func (l *Log) Update(k []byte, fn func(old []byte) ([]byte, error)) error {
+	return l.lockFn(func() error {
+		var old []byte
+		if e, ok := l.index[ioutil.Bytes2Str(k)]; ok {
+			var err error
+			if old, err = l.read(e); err != nil {
+				return err
+			}
+		}
+		v, err := fn(old)
+		if err != nil || v == nil {
+			return err
+		}
+		return l.append(opPut, k, v)
+	})
+}
+
+// Batch applies all the writes made by fn with the lock held. The writes
+// are appended as a single record, so they are recovered all-or-nothing
+// after a crash. If fn returns an error, nothing will be written.
+func (l *Log) Batch(fn func(txn Txn) error) error {
+	return l.lockFn(func() error {
+		t := &txn{l: l, writes: make(map[string][]byte)}
+		if err := fn(t); err != nil || len(t.body) == 0 {
+			return err
+		}
+		return l.append(opBatch, nil, t.body)
+	})
+}
+
+// Remove appends a record to remove a key from the log. It will return no
+// error even if the key does not present.
+func (l *Log) Remove(k []byte) error {
+	return l.lockFn(func() error {
+		if _, ok := l.index[ioutil.Bytes2Str(k)]; !ok {
+			return nil
+		}
+		return l.append(opRemove, k, nil)
+	})
+}
+
+// Iter iterates all key-value pairs in the log. The order is
+// nondeterministic. Do not modify the log during iterating.
+func (l *Log) Iter(iterCb func(k, v []byte) error) error {
+	return l.rlockFn(func() error {
+		for k, e := range l.index {
+			if err := l.each(k, e, iterCb); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Scan iterates key-value pairs in ascending order of keys, starting from
+// the first key which is equal to or greater than start. Do not modify the
+// log during scanning.
+func (l *Log) Scan(start []byte, iterCb func(k, v []byte) error) error {
+	return l.rlockFn(func() error {
+		keys := make([]string, 0, len(l.index))
+		for k := range l.index {
+			if k >= ioutil.Bytes2Str(start) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := l.each(k, l.index[k], iterCb); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops background compaction and closes the log file. It is fine
+// to close a log store more than once.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	close(l.done)
+	l.wg.Wait()
+
+	// Wait for the running compaction, if any.
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	return l.f.Close()
+}
+
+// append appends a record to the log and applies it to the index. If the
+// record is not completely written, the partial one will be truncated.
+func (l *Log) append(op byte, k, v []byte) error {
+	buf := appendRecord(nil, op, k, v)
+	off := l.size
+	_, err := l.f.WriteAt(buf, off)
+	if err == nil && l.opts.Sync {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		l.f.Truncate(off)
+		return err
+	}
+	l.size += int64(len(buf))
+	l.garbage += apply(l.index, record{op: op, key: k, val: v}, off, int64(len(buf)))
+	return nil
+}
+
+func (l *Log) read(e entry) ([]byte, error) {
+	v := make([]byte, e.vlen)
+	if _, err := l.f.ReadAt(v, e.off); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (l *Log) each(k string, e entry, iterCb func(k, v []byte) error) error {
+	v, err := l.read(e)
+	if err != nil {
+		return err
+	}
+	return iterCb(ioutil.Str2Bytes(k), v)
+}
+
+func (l *Log) lockFn(fn func() error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return fn()
+}
+
+func (l *Log) rlockFn(fn func() error) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return fn()
+}
+
+// apply applies a record at off of the log to the index, and returns the
+// size of garbage made by it.
+func apply(index map[string]entry, rec record, off, n int64) (garbage int64) {
+	key := ioutil.Bytes2Str(rec.key)
+	old, ok := index[key]
+	switch rec.op {
+	case opPut:
+		index[string(rec.key)] = entry{
+			off:  off + headerSize + int64(len(rec.key)),
+			vlen: int64(len(rec.val)),
+			n:    n,
+		}
+		if ok {
+			garbage = old.n
+		}
+	case opRemove:
+		delete(index, key)
+		garbage = n
+		if ok {
+			garbage += old.n
+		}
+	case opBatch:
+		// Records of a batch have been verified by scan, or encoded
+		// by Batch itself.
+		garbage = headerSize + int64(len(rec.key))
+		off += garbage
+		for buf := rec.val; len(buf) > 0; {
+			inner, m, _ := decode(buf)
+			garbage += apply(index, inner, off, m)
+			off, buf = off+m, buf[m:]
+		}
+	}
+	return garbage
+}
+
+// scan reads records of the log between start and end, and passes them to
+// fn. It stops at the first torn or corrupted record, and returns the end
+// offset of the last valid record.
+func scan(r io.ReaderAt, start, end int64, fn func(rec record, off, n int64) error) (int64, error) {
+	var (
+		br  = bufio.NewReader(io.NewSectionReader(r, start, end-start))
+		off = start
+		buf []byte
+	)
+	for {
+		var hdr [headerSize]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return off, nil
+		}
+		n := headerSize + bodySize(hdr[:])
+		if off+n > end {
+			return off, nil
+		}
+		if int64(cap(buf)) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		copy(buf, hdr[:])
+		if _, err := io.ReadFull(br, buf[headerSize:]); err != nil {
+			return off, nil
+		}
+		rec, _, err := decode(buf)
+		if err != nil || !valid(rec) {
+			return off, nil
+		}
+		if err := fn(rec, off, n); err != nil {
+			return off, err
+		}
+		off += n
+	}
+}
+
+// valid reports whether records of a batch are all intact.
+func valid(rec record) bool {
+	if rec.op != opBatch {
+		return true
+	}
+	for buf := rec.val; len(buf) > 0; {
+		inner, m, err := decode(buf)
+		if err != nil || inner.op == opBatch {
+			return false
+		}
+		buf = buf[m:]
+	}
+	return true
+}
+
+// compactLoop compacts the log in background until the log store is closed.
+// Errors are ignored since the compaction will be retried later.
+func (l *Log) compactLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if l.shouldCompact() {
+				l.Compact()
+			}
+		}
+	}
+}
+
+func (l *Log) shouldCompact() (ok bool) {
+	l.rlockFn(func() error {
+		ok = l.garbage >= l.opts.CompactMinGarbage &&
+			float64(l.garbage) >= l.opts.CompactRatio*float64(l.size)
+		return nil
+	})
+	return ok
+}
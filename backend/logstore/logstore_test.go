@@ -0,0 +1,269 @@
+package logstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+var errMock = errors.New("mock error")
+
+func tempLog(t *testing.T, opts Options) (*Log, func()) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Path = filepath.Join(dir, "fcache.log")
+	l, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func reopen(t *testing.T, l *Log) *Log {
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l2, err := Open(l.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l2
+}
+
+func dump(t *testing.T, l *Log) string {
+	var buf bytes.Buffer
+	err := l.Scan(nil, func(k, v []byte) error {
+		fmt.Fprintf(&buf, "%s=%s;", k, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestLog(t *testing.T) {
+	l, cleanup := tempLog(t, Options{})
+	defer cleanup()
+
+	testcases := []struct {
+		description string
+		scenario    func() error
+		expect      string
+	}{
+		{"put", func() error { return l.Put([]byte("a"), []byte("1")) }, "a=1;"},
+		{"put another", func() error { return l.Put([]byte("b"), []byte("2")) }, "a=1;b=2;"},
+		{"overwrite", func() error { return l.Put([]byte("a"), []byte("3")) }, "a=3;b=2;"},
+		{"put empty value", func() error { return l.Put([]byte("c"), nil) }, "a=3;b=2;c=;"},
+		{"remove", func() error { return l.Remove([]byte("b")) }, "a=3;c=;"},
+		{"remove missing key", func() error { return l.Remove([]byte("b")) }, "a=3;c=;"},
+		{"update", func() error {
+			return l.Update([]byte("a"), func(old []byte) ([]byte, error) {
+				return append(old, '4'), nil
+			})
+		}, "a=34;c=;"},
+		{"update without writing", func() error {
+			return l.Update([]byte("d"), func(old []byte) ([]byte, error) {
+				if old != nil {
+					t.Errorf("expect nil old value, but get %s", old)
+				}
+				return nil, nil
+			})
+		}, "a=34;c=;"},
+	}
+
+	for idx, tc := range testcases {
+		if err := tc.scenario(); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+		if s := dump(t, l); s != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, s)
+		}
+	}
+
+	if _, err := l.Get([]byte("b")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if v, err := l.Get([]byte("a")); err != nil || string(v) != "34" {
+		t.Errorf("expect %v, but get %s, %v", "34", v, err)
+	}
+
+	// Everything survives a restart.
+	l = reopen(t, l)
+	if s := dump(t, l); s != "a=34;c=;" {
+		t.Errorf("expect %v, but get %v", "a=34;c=;", s)
+	}
+
+	count := 0
+	err := l.Iter(func(k, v []byte) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Errorf("expect 2 pairs, but get %d, %v", count, err)
+	}
+	if err := l.Iter(func(k, v []byte) error { return errMock }); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+	if err := l.Scan([]byte("b"), func(k, v []byte) error { return errMock }); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("expect closing twice to be fine, but get %v", err)
+	}
+	if err := l.Put([]byte("a"), nil); err != ErrClosed {
+		t.Errorf("expect %v, but get %v", ErrClosed, err)
+	}
+	if _, err := l.Get([]byte("a")); err != ErrClosed {
+		t.Errorf("expect %v, but get %v", ErrClosed, err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	l, cleanup := tempLog(t, Options{})
+	defer cleanup()
+
+	l.Put([]byte("a"), []byte("1"))
+	l.Put([]byte("b"), []byte("2"))
+
+	err := l.Batch(func(txn Txn) error {
+		txn.Put([]byte("a"), []byte("3"))
+		txn.Remove([]byte("b"))
+		txn.Put([]byte("c"), []byte("4"))
+		if v, err := txn.Get([]byte("a")); err != nil || string(v) != "3" {
+			t.Errorf("expect %s, but get %s, %v", "3", v, err)
+		}
+		if _, err := txn.Get([]byte("b")); err != cache.ErrNoSuchKey {
+			t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+		}
+		if _, err := txn.Get([]byte("d")); err != cache.ErrNoSuchKey {
+			t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := dump(t, l); s != "a=3;c=4;" {
+		t.Errorf("expect %v, but get %v", "a=3;c=4;", s)
+	}
+
+	// None of the writes will be applied if an error occurs.
+	err = l.Batch(func(txn Txn) error {
+		txn.Remove([]byte("a"))
+		return errMock
+	})
+	if err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+
+	l = reopen(t, l)
+	if s := dump(t, l); s != "a=3;c=4;" {
+		t.Errorf("expect %v, but get %v", "a=3;c=4;", s)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	batch := appendRecord(nil, opPut, []byte("c"), []byte("3"))
+	batch = appendRecord(batch, opPut, []byte("d"), []byte("4"))
+
+	testcases := []struct {
+		description string
+		tail        []byte
+	}{
+		{"torn header", []byte{1, 2, 3}},
+		{"torn record", appendRecord(nil, opPut, []byte("c"), []byte("3"))[:headerSize+1]},
+		{"torn batch", appendRecord(nil, opBatch, nil, batch)[:headerSize+int(size(nil, nil))+4]},
+		{"corrupted record", append(appendRecord(nil, opPut, []byte("c"), []byte("3"))[:headerSize+1], 'x', 'y')},
+		{"oversized length", appendRecord(nil, opPut, []byte("c"), bytes.Repeat([]byte("3"), 1024))[:64]},
+	}
+
+	for idx, tc := range testcases {
+		l, cleanup := tempLog(t, Options{})
+		l.Put([]byte("a"), []byte("1"))
+		l.Put([]byte("b"), []byte("2"))
+		valid := l.size
+		l.Close()
+
+		f, err := os.OpenFile(l.opts.Path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(tc.tail)
+		f.Close()
+
+		l, err = Open(l.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := dump(t, l); s != "a=1;b=2;" {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, "a=1;b=2;", s)
+		}
+		if fi, _ := os.Stat(l.opts.Path); fi.Size() != valid {
+			t.Errorf("[#Case%d] %s: expect the tail to be truncated to %d, but get %d", idx, tc.description, valid, fi.Size())
+		}
+
+		// New records are appended after the valid ones.
+		l.Put([]byte("e"), []byte("5"))
+		l = reopen(t, l)
+		if s := dump(t, l); s != "a=1;b=2;e=5;" {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, "a=1;b=2;e=5;", s)
+		}
+		l.Close()
+		cleanup()
+	}
+
+	if _, err := Open(Options{Path: "/dev/null/fcache.log"}); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	l, cleanup := tempLog(t, Options{})
+	defer cleanup()
+
+	pool := backend.Adapter(l, codec.Gob{})
+	if err := pool.Put("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.IncrRef("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	l = reopen(t, l)
+	pool = backend.Adapter(l, codec.Gob{})
+	item, err := pool.Get("a")
+	if err != nil || !item.IsReal() || item.Reference() != 1 {
+		t.Errorf("expect a referenced real item, but get %v, %v", item, err)
+	}
+	item, err = pool.Get("b")
+	if err != nil || item.IsReal() || item.Reference() != 1 {
+		t.Errorf("expect a referenced pseudo item, but get %v, %v", item, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+		l, cleanup := tempLog(t, Options{})
+		t.Cleanup(cleanup)
+		return l
+	})
+}
@@ -0,0 +1,43 @@
+package logstore
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// DefaultCompactRatio is the default ratio of garbage to the log size
+	// which triggers a background compaction.
+	DefaultCompactRatio = 0.5
+
+	// DefaultCompactMinGarbage is the default minimum size of garbage in
+	// bytes which triggers a background compaction.
+	DefaultCompactMinGarbage = 1 << 20
+
+	// DefaultCompactInterval is the default interval to check whether the
+	// log should be compacted in background.
+	DefaultCompactInterval = time.Minute
+)
+
+// Options configures a log store.
+type Options struct {
+	Path string
+	Mode os.FileMode
+
+	// Sync makes every write flushed to the disk before returning. Without
+	// it, recent writes might be lost on crash, but the log is still able
+	// to be recovered.
+	Sync bool
+
+	// CompactInterval is the interval to check whether the log should be
+	// compacted in background, DefaultCompactInterval by default. Background
+	// compaction is disabled if it is negative.
+	CompactInterval time.Duration
+
+	// CompactRatio and CompactMinGarbage decide when to compact the log in
+	// background. A compaction is triggered once the size of garbage, which
+	// are overwritten and removed records, exceeds both CompactMinGarbage
+	// and CompactRatio of the log size.
+	CompactRatio      float64
+	CompactMinGarbage int64
+}
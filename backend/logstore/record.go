@@ -0,0 +1,89 @@
+package logstore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// A record is laid out as:
+//
+//	crc (4) | op (1) | key length (4) | value length (4) | key | value
+//
+// where crc is the CRC32C checksum of the rest of the record. A batch is
+// a record whose value consists of the records in the batch, so that it
+// is either recovered as a whole or not at all.
+const headerSize = 13
+
+const (
+	opPut byte = iota + 1
+	opRemove
+	opBatch
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errChecksum = errors.New("checksum mismatch")
+	errBadOp    = errors.New("unknown record operation")
+)
+
+// record is a decoded record. Its key and value refer to the buffer it is
+// decoded from.
+type record struct {
+	op  byte
+	key []byte
+	val []byte
+}
+
+// size returns the encoded size of a record with given key and value.
+func size(k, v []byte) int64 {
+	return headerSize + int64(len(k)) + int64(len(v))
+}
+
+// appendRecord appends an encoded record to buf.
+func appendRecord(buf []byte, op byte, k, v []byte) []byte {
+	start := len(buf)
+	var hdr [headerSize]byte
+	hdr[4] = op
+	binary.LittleEndian.PutUint32(hdr[5:], uint32(len(k)))
+	binary.LittleEndian.PutUint32(hdr[9:], uint32(len(v)))
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, k...)
+	buf = append(buf, v...)
+	binary.LittleEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+4:], castagnoli))
+	return buf
+}
+
+// bodySize returns the size of key and value of a record from its header.
+func bodySize(hdr []byte) int64 {
+	return int64(binary.LittleEndian.Uint32(hdr[5:])) + int64(binary.LittleEndian.Uint32(hdr[9:]))
+}
+
+// decode decodes the first record of buf and returns its encoded size. It
+// returns io.ErrUnexpectedEOF if buf does not contain the whole record.
+func decode(buf []byte) (record, int64, error) {
+	if len(buf) < headerSize {
+		return record{}, 0, io.ErrUnexpectedEOF
+	}
+	n := headerSize + bodySize(buf)
+	if int64(len(buf)) < n {
+		return record{}, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(buf[4:n], castagnoli) != binary.LittleEndian.Uint32(buf) {
+		return record{}, 0, errChecksum
+	}
+
+	klen := headerSize + int64(binary.LittleEndian.Uint32(buf[5:]))
+	rec := record{
+		op:  buf[4],
+		key: buf[headerSize:klen],
+		val: buf[klen:n],
+	}
+	if rec.op < opPut || rec.op > opBatch {
+		return record{}, 0, errBadOp
+	}
+	return rec, n, nil
+}
@@ -0,0 +1,41 @@
+package logstore
+
+import (
+	"io"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	buf := appendRecord(nil, opPut, []byte("key"), []byte("value"))
+
+	rec, n, err := decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size([]byte("key"), []byte("value")) || rec.op != opPut ||
+		string(rec.key) != "key" || string(rec.val) != "value" {
+		t.Errorf("unexpected record %v of size %d", rec, n)
+	}
+
+	corrupted := append([]byte{}, buf...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	badOp := appendRecord(nil, opBatch+1, nil, nil)
+
+	testcases := []struct {
+		description string
+		buf         []byte
+		expectErr   error
+	}{
+		{"short header", buf[:headerSize-1], io.ErrUnexpectedEOF},
+		{"short body", buf[:len(buf)-1], io.ErrUnexpectedEOF},
+		{"checksum mismatch", corrupted, errChecksum},
+		{"unknown operation", badOp, errBadOp},
+	}
+
+	for idx, tc := range testcases {
+		_, _, err := decode(tc.buf)
+		if err != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+	}
+}
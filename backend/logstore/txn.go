@@ -0,0 +1,49 @@
+package logstore
+
+import (
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/ioutil"
+)
+
+// Txn is identical to backend.Txn.
+type Txn = interface {
+	Get(k []byte) (v []byte, e error)
+	Put(k, v []byte) error
+	Remove(k []byte) error
+}
+
+// txn encodes writes of a batch into records, and keeps the written values
+// so they are visible to later reads in the same batch. A nil value stands
+// for a removed key.
+type txn struct {
+	l      *Log
+	body   []byte
+	writes map[string][]byte
+}
+
+func (t *txn) Get(k []byte) ([]byte, error) {
+	key := ioutil.Bytes2Str(k)
+	if v, ok := t.writes[key]; ok {
+		if v == nil {
+			return nil, cache.ErrNoSuchKey
+		}
+		return v, nil
+	}
+	e, ok := t.l.index[key]
+	if !ok {
+		return nil, cache.ErrNoSuchKey
+	}
+	return t.l.read(e)
+}
+
+func (t *txn) Put(k, v []byte) error {
+	t.body = appendRecord(t.body, opPut, k, v)
+	t.writes[string(k)] = append([]byte{}, v...)
+	return nil
+}
+
+func (t *txn) Remove(k []byte) error {
+	t.body = appendRecord(t.body, opRemove, k, nil)
+	t.writes[string(k)] = nil
+	return nil
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/log"
+)
+
+// Option configures the adapter created by Adapter.
+type Option interface {
+	setAdapterOption(ada *adapter)
+}
+
+type withLogger struct {
+	logger log.Logger
+}
+
+func (w withLogger) setAdapterOption(ada *adapter) {
+	ada.logger = log.OrNop(w.logger)
+}
+
+// WithLogger returns an adapter option which makes the adapter record
+// failures, such as codec errors, with the given logger.
+func WithLogger(logger log.Logger) Option {
+	return withLogger{logger}
+}
+
+type withCorruptStrategy struct {
+	strategy CorruptStrategy
+}
+
+func (w withCorruptStrategy) setAdapterOption(ada *adapter) {
+	ada.strategy = w.strategy
+}
+
+// WithCorruptStrategy returns an adapter option which decides how the adapter
+// handles corrupted records.
+func WithCorruptStrategy(strategy CorruptStrategy) Option {
+	return withCorruptStrategy{strategy}
+}
+
+type withQuarantine struct {
+	store Store
+}
+
+func (w withQuarantine) setAdapterOption(ada *adapter) {
+	ada.strategy = QuarantineCorrupt
+	ada.quarantine = w.store
+}
+
+// WithQuarantine returns an adapter option which makes the adapter move
+// corrupted records into the given store.
+func WithQuarantine(store Store) Option {
+	return withQuarantine{store}
+}
+
+type withPseudoTTL struct {
+	ttl      time.Duration
+	onExpire func(item cache.Item)
+}
+
+func (w withPseudoTTL) setAdapterOption(ada *adapter) {
+	ada.pseudoTTL = w.ttl
+	if expirer, ok := ada.backend.(Expirer); ok && w.onExpire != nil {
+		expirer.NotifyExpired(ada.expired(w.onExpire))
+	}
+}
+
+// WithPseudoTTL returns an adapter option which makes pseudo items, created
+// by IncrRef but never put, expire after ttl since their references are last
+// changed, no matter how many references they have, so they do not pile up. onExpire, if not nil, is invoked with each expired
+// item. It takes effect only if the store implements Expirer.
+func WithPseudoTTL(ttl time.Duration, onExpire func(item cache.Item)) Option {
+	return withPseudoTTL{ttl, onExpire}
+}
+
+type withOnModify struct {
+	fn func(old, new cache.Item)
+}
+
+func (w withOnModify) setAdapterOption(ada *adapter) {
+	ada.onModify = w.fn
+}
+
+// WithOnModify returns an adapter option which makes fn invoked with a cache
+// item before and after every modification, such as Put, IncrRef and DecrRef,
+// once it has been written. old is a zero item if the key did not present.
+// Removals are not reported. It lets callers keep track of cache items
+// without reading them again, and it must not access the adapter.
+func WithOnModify(fn func(old, new cache.Item)) Option {
+	return withOnModify{fn}
+}
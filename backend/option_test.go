@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/pkg/log"
+)
+
+type recorder struct {
+	msgs []string
+}
+
+func (r *recorder) Log(level log.Level, msg string, fields ...log.Field) {
+	r.msgs = append(r.msgs, msg)
+}
+
+func TestWithLogger(t *testing.T) {
+	rec := &recorder{}
+	store := gomap.New()
+	store.Put([]byte("123"), []byte("corrupted"))
+
+	ada := Adapter(store, codec.Gob{}, WithLogger(rec))
+	ada.Get("123")
+
+	if len(rec.msgs) != 1 || rec.msgs[0] != "failed to decode cache item" {
+		t.Errorf("expect a decode failure logged, but get %v", rec.msgs)
+	}
+
+	// A nil logger falls back to a no-op one.
+	ada = Adapter(store, codec.Gob{}, WithLogger(nil))
+	ada.Get("123")
+}
+
+func TestWithPseudoTTL(t *testing.T) {
+	// Encrypted codecs encode the same item into different bytes every time.
+	keys := codec.NewKeyring()
+	if err := keys.Rotate(1, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	codecs := []codec.Codec{codec.Gob{}, codec.Encrypted(codec.Gob{}, keys)}
+
+	for idx, c := range codecs {
+		var (
+			mu      sync.Mutex
+			expired []string
+		)
+		store := gomap.New()
+		ada := Adapter(store, c, WithPseudoTTL(time.Millisecond, func(item cache.Item) {
+			mu.Lock()
+			expired = append(expired, item.Key)
+			mu.Unlock()
+		}))
+
+		ada.IncrRef("pseudo", "real")
+		ada.Put("real", 1)
+		ada.DecrRef("real")
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			n := len(expired)
+			mu.Unlock()
+			if n > 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		mu.Lock()
+		if len(expired) != 1 || expired[0] != "pseudo" {
+			t.Errorf("[#Case%d]: expect [pseudo] expired, but get %v", idx, expired)
+		}
+		mu.Unlock()
+		if _, err := ada.Get("pseudo"); !IsNoKeyError(err) {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, cache.ErrNoSuchKey, err)
+		}
+		if item, err := ada.Get("real"); err != nil || !item.IsReal() {
+			t.Errorf("[#Case%d]: expect a real item kept, but get %v, %v", idx, item, err)
+		}
+		store.Close()
+	}
+
+	// Stores without expiry ignore the option.
+	mock := Mock{
+		GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
+		PutHandler: func(k, v []byte) error { return nil },
+	}
+	ada := Adapter(mock, codec.Gob{}, WithPseudoTTL(time.Millisecond, nil))
+	if err := ada.IncrRef("pseudo"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,268 @@
+// Package shardmap implements backend.Store as an in-memory map split into
+// shards, each of which is guarded by its own lock. Operations on keys of
+// different shards do not contend with each other, which makes it scale
+// better than gomap under highly concurrent workloads.
+package shardmap
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/ioutil"
+)
+
+// DefaultShards is the number of shards if it is not specified.
+const DefaultShards = 32
+
+// New is a factory method to create a instance of Map with n shards. If n
+// is not positive, DefaultShards will be used.
+func New(n int) *Map {
+	if n <= 0 {
+		n = DefaultShards
+	}
+	m := &Map{shards: make([]shard, n)}
+	for i := range m.shards {
+		m.shards[i].ma = make(map[string][]byte)
+	}
+	return m
+}
+
+// Map implements backend.Store interface.
+type Map struct {
+	shards []shard
+}
+
+type shard struct {
+	ma map[string][]byte
+	mu sync.RWMutex
+}
+
+// Put puts a key-value pair into the map. The value is copied, so it is
+// fine to reuse it after putting. If the key duplicates, the new one will
+// replace the old one and returns with no error.
+func (m *Map) Put(k, v []byte) error {
+	s := m.shard(k)
+	v = clone(v)
+	s.mu.Lock()
+	s.ma[string(k)] = v
+	s.mu.Unlock()
+	return nil
+}
+
+// Get gets the value of given key from the map. If the key does not
+// present, it will return a nil value and cache.ErrNoSuchKey.
+func (m *Map) Get(k []byte) ([]byte, error) {
+	s := m.shard(k)
+	s.mu.RLock()
+	v, ok := s.ma[ioutil.Bytes2Str(k)]
+	s.mu.RUnlock()
+	if ok {
+		return v, nil
+	}
+	return nil, cache.ErrNoSuchKey
+}
+
+// Update reads, modifies and writes a key-value pair with the lock of its
+// shard held. The old value passed to fn is nil if the key does not present.
+// If fn returns a nil value, nothing will be written.
+func (m *Map) Update(k []byte, fn func(old []byte) ([]byte, error)) error {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := fn(s.ma[ioutil.Bytes2Str(k)])
+	if err != nil || v == nil {
+		return err
+	}
+	s.ma[string(k)] = clone(v)
+	return nil
+}
+
+// Batch applies all the writes made by fn with locks of the shards of keys
+// it accesses held, so batches of keys in different shards do not contend.
+// Shards are locked in index order to avoid deadlocks. Once fn accesses a
+// shard preceding the locked ones, all the locks are released, and fn is
+// invoked again with the shards known so far locked in order, so fn might be
+// invoked more than once. Writes are staged until fn returns, so none of them
+// will be applied if fn returns an error.
+func (m *Map) Batch(fn func(txn Txn) error) error {
+	locks := &shardLocks{m: m, held: make([]bool, len(m.shards)), last: -1}
+	defer locks.release()
+
+	t := &txn{m: m, locks: locks}
+	for {
+		t.writes = make(map[string][]byte)
+		t.missed = t.missed[:0]
+		err := fn(t)
+		if len(t.missed) > 0 {
+			locks.relock(t.missed)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	for k, v := range t.writes {
+		s := m.shard(ioutil.Str2Bytes(k))
+		if v == nil {
+			delete(s.ma, k)
+			continue
+		}
+		s.ma[k] = v
+	}
+	return nil
+}
+
+// Remove removes a key from the map. It will return no error even if the
+// key does not present.
+func (m *Map) Remove(k []byte) error {
+	s := m.shard(k)
+	s.mu.Lock()
+	delete(s.ma, ioutil.Bytes2Str(k))
+	s.mu.Unlock()
+	return nil
+}
+
+// Iter iterates a snapshot of key-value pairs in the map. The order is
+// nondeterministic. Locks are only held while taking the snapshot, so the
+// callback does not block writers, and it is fine to modify the map in it.
+func (m *Map) Iter(iterCb func(k, v []byte) error) error {
+	for _, p := range m.snapshot(nil) {
+		if err := iterCb(ioutil.Str2Bytes(p.k), p.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan is similar to Iter, but iterates key-value pairs in ascending order
+// of keys, starting from the first key which is equal to or greater than
+// start.
+func (m *Map) Scan(start []byte, iterCb func(k, v []byte) error) error {
+	from := ioutil.Bytes2Str(start)
+	pairs := m.snapshot(func(k string) bool { return k >= from })
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].k < pairs[j].k })
+	for _, p := range pairs {
+		if err := iterCb(ioutil.Str2Bytes(p.k), p.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the map, actually it does nothing.
+func (m *Map) Close() error {
+	return nil
+}
+
+type pair struct {
+	k string
+	v []byte
+}
+
+// snapshot collects key-value pairs accepted by filter with read locks of
+// all shards held, so it is a consistent view of the map. Values are never
+// modified in place, so they are safe to be referenced after unlocking.
+func (m *Map) snapshot(filter func(k string) bool) []pair {
+	for i := range m.shards {
+		m.shards[i].mu.RLock()
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].mu.RUnlock()
+		}
+	}()
+
+	n := 0
+	for i := range m.shards {
+		n += len(m.shards[i].ma)
+	}
+	pairs := make([]pair, 0, n)
+	for i := range m.shards {
+		for k, v := range m.shards[i].ma {
+			if filter == nil || filter(k) {
+				pairs = append(pairs, pair{k, v})
+			}
+		}
+	}
+	return pairs
+}
+
+// shard selects the shard of a key.
+func (m *Map) shard(k []byte) *shard {
+	return &m.shards[m.index(k)]
+}
+
+// index returns the index of the shard of a key by its FNV-1a hash.
+func (m *Map) index(k []byte) int {
+	const (
+		offset = 2166136261
+		prime  = 16777619
+	)
+	h := uint32(offset)
+	for _, c := range k {
+		h ^= uint32(c)
+		h *= prime
+	}
+	return int(h % uint32(len(m.shards)))
+}
+
+// shardLocks are locks of shards held by a batch, which are acquired in
+// index order only.
+type shardLocks struct {
+	m    *Map
+	held []bool
+
+	// last is the index of the last shard locked, or -1 if none.
+	last int
+}
+
+// acquire locks the shard of index i if it is not locked yet. It fails if
+// the shard precedes the last one locked.
+func (l *shardLocks) acquire(i int) bool {
+	if l.held[i] {
+		return true
+	}
+	if i < l.last {
+		return false
+	}
+	l.m.shards[i].mu.Lock()
+	l.held[i] = true
+	l.last = i
+	return true
+}
+
+// relock releases all the locks, and then acquires them again along with
+// the shards of indexes in index order.
+func (l *shardLocks) relock(indexes []int) {
+	for i, held := range l.held {
+		if held {
+			l.m.shards[i].mu.Unlock()
+		}
+	}
+	for _, i := range indexes {
+		l.held[i] = true
+	}
+	for i, held := range l.held {
+		if held {
+			l.m.shards[i].mu.Lock()
+			l.last = i
+		}
+	}
+}
+
+// release releases all the locks.
+func (l *shardLocks) release() {
+	for i, held := range l.held {
+		if held {
+			l.m.shards[i].mu.Unlock()
+			l.held[i] = false
+		}
+	}
+	l.last = -1
+}
+
+func clone(v []byte) []byte {
+	return append([]byte{}, v...)
+}
@@ -0,0 +1,368 @@
+package shardmap
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+var errMock = errors.New("mock error")
+
+func dump(t *testing.T, m *Map) string {
+	var buf bytes.Buffer
+	err := m.Scan(nil, func(k, v []byte) error {
+		fmt.Fprintf(&buf, "%s=%s;", k, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMap(t *testing.T) {
+	m := New(4)
+
+	testcases := []struct {
+		description string
+		scenario    func() error
+		expect      string
+	}{
+		{"put", func() error { return m.Put([]byte("a"), []byte("1")) }, "a=1;"},
+		{"put another", func() error { return m.Put([]byte("b"), []byte("2")) }, "a=1;b=2;"},
+		{"overwrite", func() error { return m.Put([]byte("a"), []byte("3")) }, "a=3;b=2;"},
+		{"remove", func() error { return m.Remove([]byte("b")) }, "a=3;"},
+		{"remove missing key", func() error { return m.Remove([]byte("b")) }, "a=3;"},
+		{"update", func() error {
+			return m.Update([]byte("a"), func(old []byte) ([]byte, error) {
+				return append(old, '4'), nil
+			})
+		}, "a=34;"},
+		{"update without writing", func() error {
+			return m.Update([]byte("c"), func(old []byte) ([]byte, error) {
+				if old != nil {
+					t.Errorf("expect nil old value, but get %s", old)
+				}
+				return nil, nil
+			})
+		}, "a=34;"},
+		{"abort update", func() error {
+			err := m.Update([]byte("a"), func(old []byte) ([]byte, error) {
+				return []byte("5"), errMock
+			})
+			if err != errMock {
+				return errors.Errorf("expect %v, but get %v", errMock, err)
+			}
+			return nil
+		}, "a=34;"},
+		{"batch", func() error {
+			return m.Batch(func(txn Txn) error {
+				txn.Put([]byte("b"), []byte("2"))
+				txn.Put([]byte("c"), []byte("3"))
+				txn.Remove([]byte("a"))
+				if v, err := txn.Get([]byte("b")); err != nil || string(v) != "2" {
+					return errors.Errorf("expect %s, but get %s, %v", "2", v, err)
+				}
+				if _, err := txn.Get([]byte("a")); err != cache.ErrNoSuchKey {
+					return errors.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+				}
+				return nil
+			})
+		}, "b=2;c=3;"},
+		{"abort batch", func() error {
+			err := m.Batch(func(txn Txn) error {
+				txn.Remove([]byte("b"))
+				return errMock
+			})
+			if err != errMock {
+				return errors.Errorf("expect %v, but get %v", errMock, err)
+			}
+			return nil
+		}, "b=2;c=3;"},
+	}
+
+	for idx, tc := range testcases {
+		if err := tc.scenario(); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+		if s := dump(t, m); s != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, s)
+		}
+	}
+
+	if _, err := m.Get([]byte("a")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if err := m.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutCopiesValue(t *testing.T) {
+	m := New(0)
+	v := []byte("1")
+	m.Put([]byte("a"), v)
+	v[0] = '2'
+	if got, _ := m.Get([]byte("a")); string(got) != "1" {
+		t.Errorf("expect %v, but get %s", "1", got)
+	}
+}
+
+func TestIter(t *testing.T) {
+	m := New(0)
+	for i := 0; i < 100; i++ {
+		m.Put([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+
+	// The callback is able to modify the map since no lock is held.
+	count := 0
+	err := m.Iter(func(k, v []byte) error {
+		count++
+		if !bytes.Equal(k, v) {
+			t.Errorf("expect %s, but get %s", k, v)
+		}
+		return m.Remove(k)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 100 || dump(t, m) != "" {
+		t.Errorf("expect 100 pairs to be iterated and removed, but get %d, %v", count, dump(t, m))
+	}
+
+	m.Put([]byte("a"), nil)
+	if err := m.Iter(func(k, v []byte) error { return errMock }); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	m := New(0)
+	for _, k := range []string{"c", "a", "b", "ab"} {
+		m.Put([]byte(k), []byte(k))
+	}
+
+	var keys []string
+	err := m.Scan([]byte("aa"), func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[ab b c]" {
+		t.Errorf("expect %v, but get %v", "[ab b c]", keys)
+	}
+	if err := m.Scan(nil, func(k, v []byte) error { return errMock }); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	const n = 100
+
+	m := New(0)
+	pool := backend.Adapter(m, codec.Gob{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				key := fmt.Sprint(j % 10)
+				if err := pool.IncrRef(key); err != nil {
+					t.Error(err)
+				}
+				if _, err := pool.Get(key); err != nil {
+					t.Error(err)
+				}
+				pool.Iter(func(k string, v cache.Item) error { return nil })
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	pool.Iter(func(k string, v cache.Item) error {
+		total += v.Reference()
+		return nil
+	})
+	if total != 4*n {
+		t.Errorf("expect %v references, but get %v", 4*n, total)
+	}
+}
+
+func TestBatchLocks(t *testing.T) {
+	m := New(4)
+
+	// Find keys of the first three shards, in descending order of shards.
+	keys := make([][]byte, 3)
+	for i := 0; keys[0] == nil || keys[1] == nil || keys[2] == nil; i++ {
+		k := []byte(fmt.Sprint(i))
+		if idx := m.index(k); idx < 3 && keys[2-idx] == nil {
+			keys[2-idx] = k
+		}
+	}
+
+	// The last shard is not involved, so it does not block the batch, while
+	// shards accessed out of order make fn invoked again.
+	m.shards[3].mu.Lock()
+	defer m.shards[3].mu.Unlock()
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Batch(func(txn Txn) error {
+			calls++
+			for _, k := range keys {
+				if _, err := txn.Get(k); err != cache.ErrNoSuchKey {
+					return err
+				}
+				if err := txn.Put(k, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the batch not blocked by an unrelated shard")
+	}
+	if calls != 3 {
+		t.Errorf("expect fn invoked %d times, but get %d", 3, calls)
+	}
+	for _, k := range keys {
+		if v, err := m.Get(k); err != nil || !bytes.Equal(v, k) {
+			t.Errorf("expect %s, but get %s, %v", k, v, err)
+		}
+	}
+}
+
+// BenchmarkRegisterMulti registers two keys at once, which is done in a
+// batch.
+func BenchmarkRegisterMulti(b *testing.B) {
+	benchmarks := []struct {
+		description string
+		store       func() backend.Store
+	}{
+		{"gomap", func() backend.Store { return gomap.New() }},
+		{"shardmap", func() backend.Store { return New(0) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.description, func(b *testing.B) {
+			pool := backend.Adapter(bm.store(), codec.Gob{})
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+				pool.Put(keys[i], 1)
+			}
+
+			var seed uint32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddUint32(&seed, 1)) * 131
+				for pb.Next() {
+					pool.IncrRef(keys[i%len(keys)], keys[(i+7)%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkRegisterGet(b *testing.B) {
+	benchmarks := []struct {
+		description string
+		store       func() backend.Store
+	}{
+		{"gomap", func() backend.Store { return gomap.New() }},
+		{"shardmap", func() backend.Store { return New(0) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.description, func(b *testing.B) {
+			pool := backend.Adapter(bm.store(), codec.Gob{})
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+				pool.Put(keys[i], 1)
+			}
+
+			// Start goroutines at different keys, or they would
+			// contend for the same shard all the time.
+			var seed uint32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddUint32(&seed, 1)) * 131
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						pool.IncrRef(key)
+					} else {
+						pool.Get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkPutGet(b *testing.B) {
+	benchmarks := []struct {
+		description string
+		store       func() backend.Store
+	}{
+		{"gomap", func() backend.Store { return gomap.New() }},
+		{"shardmap", func() backend.Store { return New(0) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.description, func(b *testing.B) {
+			store := bm.store()
+			keys := make([][]byte, 1024)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("key-%d", i))
+				store.Put(keys[i], keys[i])
+			}
+
+			// Start goroutines at different keys, or they would
+			// contend for the same shard all the time.
+			var seed uint32
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddUint32(&seed, 1)) * 131
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						store.Put(key, key)
+					} else {
+						store.Get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+		return New(4)
+	})
+}
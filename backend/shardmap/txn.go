@@ -0,0 +1,70 @@
+package shardmap
+
+import (
+	"errors"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/ioutil"
+)
+
+// Txn is identical to backend.Txn.
+type Txn = interface {
+	Get(k []byte) (v []byte, e error)
+	Put(k, v []byte) error
+	Remove(k []byte) error
+}
+
+// errRelock raises when a batch has to lock shards in order again.
+var errRelock = errors.New("shards are locked out of order")
+
+// txn stages writes of a batch. A staged nil value stands for a removed
+// key. Shards of keys accessed are locked on demand, and the ones unable to
+// be locked in order are collected in missed, which makes Batch invoke fn
+// again.
+type txn struct {
+	m      *Map
+	locks  *shardLocks
+	writes map[string][]byte
+	missed []int
+}
+
+// lock locks the shard of a key for the rest of the batch.
+func (t *txn) lock(k []byte) error {
+	i := t.m.index(k)
+	if !t.locks.acquire(i) {
+		t.missed = append(t.missed, i)
+		return errRelock
+	}
+	return nil
+}
+
+func (t *txn) Get(k []byte) ([]byte, error) {
+	if err := t.lock(k); err != nil {
+		return nil, err
+	}
+	key := ioutil.Bytes2Str(k)
+	v, ok := t.writes[key]
+	if !ok {
+		v, ok = t.m.shard(k).ma[key]
+	}
+	if !ok || v == nil {
+		return nil, cache.ErrNoSuchKey
+	}
+	return v, nil
+}
+
+func (t *txn) Put(k, v []byte) error {
+	if err := t.lock(k); err != nil {
+		return err
+	}
+	t.writes[string(k)] = clone(v)
+	return nil
+}
+
+func (t *txn) Remove(k []byte) error {
+	if err := t.lock(k); err != nil {
+		return err
+	}
+	t.writes[string(k)] = nil
+	return nil
+}
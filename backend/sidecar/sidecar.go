@@ -0,0 +1,289 @@
+// Package sidecar implements backend.Store by storing each record as a small
+// file, so metadata lives next to the cached files, survives copying the
+// directory and needs no database. Records are written into temporary files
+// and then renamed, so a partial write never replaces a complete record.
+package sidecar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// Layout decides where records are stored.
+type Layout int
+
+const (
+	// Sidecar stores the record of a key, which is the path to a cached
+	// file, at the path with the suffix appended.
+	Sidecar Layout = iota
+
+	// Hashed stores records inside a hidden directory under the root,
+	// named by the SHA-256 hash of their keys.
+	Hashed
+)
+
+const (
+	// DefaultSuffix is the suffix of record files of the Sidecar layout.
+	DefaultSuffix = ".fcache-meta"
+
+	// HiddenDir is the directory under the root which stores records of
+	// the Hashed layout.
+	HiddenDir = ".fcache"
+
+	// magic prefixes every record file.
+	magic = "FCMETA1\n"
+
+	// tmpMarker marks temporary files which are not yet renamed.
+	tmpMarker = ".fcache-tmp"
+)
+
+// ErrCorrupted raises when a record file is unable to be parsed.
+var ErrCorrupted = errors.New("corrupted record file")
+
+// Options configures a sidecar store.
+type Options struct {
+	// Root is the directory walked by Iter. With the Hashed layout, records
+	// are stored in HiddenDir under it. With the Sidecar layout, keys are
+	// expected to be paths under it joined like filepath.Join(Root, name),
+	// which is also how Iter reports them.
+	Root   string
+	Layout Layout
+
+	// Suffix is the suffix of record files of the Sidecar layout,
+	// DefaultSuffix by default.
+	Suffix string
+
+	// Mode is the permission of record files, 0644 by default.
+	Mode os.FileMode
+
+	// Sync makes every record flushed to the disk before it is renamed.
+	Sync bool
+
+	// Logger records record files skipped by Iter, no logging by default.
+	Logger log.Logger
+}
+
+// New is a factory method to create a sidecar store.
+func New(opts Options) *Store {
+	if opts.Suffix == "" {
+		opts.Suffix = DefaultSuffix
+	}
+	if opts.Mode == 0 {
+		opts.Mode = 0644
+	}
+	opts.Logger = log.OrNop(opts.Logger)
+	return &Store{opts: opts}
+}
+
+// Store implements backend.Store interface.
+type Store struct {
+	opts Options
+
+	// mu serializes writes so Update is atomic. Reads need no lock since
+	// records are replaced by renaming.
+	mu sync.Mutex
+}
+
+// Put writes a record file for a key-value pair.
+func (s *Store) Put(k, v []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(k, v)
+}
+
+// Get reads the value from the record file of a key. If the file does not
+// exist, it returns cache.ErrNoSuchKey.
+func (s *Store) Get(k []byte) ([]byte, error) {
+	v, err := s.read(k)
+	if os.IsNotExist(err) {
+		return nil, cache.ErrNoSuchKey
+	}
+	return v, err
+}
+
+// Update reads, modifies and writes a key-value pair with the write lock
+// held. The old value passed to fn is nil if the key does not present. If
+// fn returns a nil value, nothing will be written.
+func (s *Store) Update(k []byte, fn func(old []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.read(k)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	v, err := fn(old)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.write(k, v)
+}
+
+// Remove removes the record file of a key. It will return no error even
+// if the key does not present.
+func (s *Store) Remove(k []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(k))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Iter walks the tree to iterate all key-value pairs. The order is
+// nondeterministic. Temporary files left by interrupted writes are skipped,
+// so are files which are unable to be parsed, such as foreign files with
+// the suffix or corrupted ones, which are logged instead of failing the
+// whole iteration.
+func (s *Store) Iter(iterCb func(k, v []byte) error) error {
+	root := s.opts.Root
+	if s.opts.Layout == Hashed {
+		root = filepath.Join(root, HiddenDir)
+	}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		// Records removed during the walk are skipped.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.Contains(info.Name(), tmpMarker) {
+			return nil
+		}
+		if s.opts.Layout == Sidecar && !strings.HasSuffix(path, s.opts.Suffix) {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k, v, err := parse(path, data)
+		if err != nil {
+			s.opts.Logger.Log(log.Warn, "skip unparsable record file",
+				log.F("path", path), log.Err(err))
+			return nil
+		}
+
+		// Keys of sidecar records follow their location, so they are
+		// still correct after the directory is copied or moved.
+		if s.opts.Layout == Sidecar {
+			k = []byte(strings.TrimSuffix(path, s.opts.Suffix))
+		}
+		return iterCb(k, v)
+	})
+}
+
+// Close closes the store, actually it does nothing.
+func (s *Store) Close() error {
+	return nil
+}
+
+// path returns the path to the record file of a key.
+func (s *Store) path(k []byte) string {
+	if s.opts.Layout == Hashed {
+		sum := sha256.Sum256(k)
+		h := hex.EncodeToString(sum[:])
+		return filepath.Join(s.opts.Root, HiddenDir, h[:2], h)
+	}
+	return string(k) + s.opts.Suffix
+}
+
+func (s *Store) read(k []byte) ([]byte, error) {
+	path := s.path(k)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, v, err := parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// A hashed record file belongs to another key only if the hash
+	// collides, which is treated as missing.
+	if s.opts.Layout == Hashed && !bytes.Equal(key, k) {
+		return nil, os.ErrNotExist
+	}
+	return v, nil
+}
+
+// write writes a record file into a temporary file in the same directory,
+// and then renames it. The directory is created if it does not exist, since
+// a key might be registered before its file is created.
+func (s *Store) write(k, v []byte) (err error) {
+	path := s.path(k)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+tmpMarker)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(encode(k, v)); err != nil {
+		return err
+	}
+	if s.opts.Sync {
+		if err = f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = f.Chmod(s.opts.Mode); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// encode lays out a record file as magic | key length (uvarint) | key | value.
+func encode(k, v []byte) []byte {
+	buf := make([]byte, 0, len(magic)+binary.MaxVarintLen64+len(k)+len(v))
+	buf = append(buf, magic...)
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(k)))]...)
+	buf = append(buf, k...)
+	return append(buf, v...)
+}
+
+func parse(path string, data []byte) (k, v []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, nil, errors.Wrap(ErrCorrupted, path)
+	}
+	data = data[len(magic):]
+	klen, n := binary.Uvarint(data)
+	if n <= 0 || klen > uint64(len(data)-n) {
+		return nil, nil, errors.Wrap(ErrCorrupted, path)
+	}
+	data = data[n:]
+	return data[:klen], data[klen:], nil
+}
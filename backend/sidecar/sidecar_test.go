@@ -0,0 +1,311 @@
+package sidecar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/pkg/log"
+	"github.com/pkg/errors"
+)
+
+var errMock = errors.New("mock error")
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func dump(t *testing.T, s *Store, root string) string {
+	var pairs []string
+	err := s.Iter(func(k, v []byte) error {
+		pairs = append(pairs, fmt.Sprintf("%s=%s;", strings.TrimPrefix(string(k), root), v))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "")
+}
+
+func TestStore(t *testing.T) {
+	for _, layout := range []Layout{Sidecar, Hashed} {
+		dir, cleanup := tempDir(t)
+		s := New(Options{Root: dir, Layout: layout, Sync: true})
+		key := func(name string) []byte { return []byte(filepath.Join(dir, name)) }
+
+		if dump(t, s, dir) != "" {
+			t.Errorf("[Layout%d]: expect an empty store", layout)
+		}
+
+		testcases := []struct {
+			description string
+			scenario    func() error
+			expect      string
+		}{
+			{"put", func() error { return s.Put(key("a"), []byte("1")) }, "/a=1;"},
+			{"put another", func() error { return s.Put(key("b"), []byte("2")) }, "/a=1;/b=2;"},
+			{"overwrite", func() error { return s.Put(key("a"), []byte("3")) }, "/a=3;/b=2;"},
+			{"remove", func() error { return s.Remove(key("b")) }, "/a=3;"},
+			{"remove missing key", func() error { return s.Remove(key("b")) }, "/a=3;"},
+			{"update", func() error {
+				return s.Update(key("a"), func(old []byte) ([]byte, error) {
+					return append(old, '4'), nil
+				})
+			}, "/a=34;"},
+			{"update without writing", func() error {
+				return s.Update(key("c"), func(old []byte) ([]byte, error) {
+					if old != nil {
+						t.Errorf("expect nil old value, but get %s", old)
+					}
+					return nil, nil
+				})
+			}, "/a=34;"},
+			{"abort update", func() error {
+				err := s.Update(key("a"), func(old []byte) ([]byte, error) {
+					return []byte("5"), errMock
+				})
+				if err != errMock {
+					return errors.Errorf("expect %v, but get %v", errMock, err)
+				}
+				return nil
+			}, "/a=34;"},
+		}
+
+		for idx, tc := range testcases {
+			if err := tc.scenario(); err != nil {
+				t.Errorf("[Layout%d][#Case%d] %s: expect no error, but get %v", layout, idx, tc.description, err)
+			}
+			if got := dump(t, s, dir); got != tc.expect {
+				t.Errorf("[Layout%d][#Case%d] %s: expect %v, but get %v", layout, idx, tc.description, tc.expect, got)
+			}
+		}
+
+		if v, err := s.Get(key("a")); err != nil || string(v) != "34" {
+			t.Errorf("[Layout%d]: expect %v, but get %s, %v", layout, "34", v, err)
+		}
+		if _, err := s.Get(key("b")); err != cache.ErrNoSuchKey {
+			t.Errorf("[Layout%d]: expect %v, but get %v", layout, cache.ErrNoSuchKey, err)
+		}
+		if err := s.Iter(func(k, v []byte) error { return errMock }); err != errMock {
+			t.Errorf("[Layout%d]: expect %v, but get %v", layout, errMock, err)
+		}
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+		cleanup()
+	}
+}
+
+func TestSidecarLayout(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	s := New(Options{Root: dir})
+	path := filepath.Join(dir, "sub", "file")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := s.Put([]byte(path), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + DefaultSuffix); err != nil {
+		t.Errorf("expect the record to be next to the file, but get %v", err)
+	}
+
+	// Unrelated files and temporary files are skipped.
+	ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "."+filepath.Base(path)+DefaultSuffix+tmpMarker+"123"), []byte("partial"), 0644)
+	if got := dump(t, s, dir); got != "/sub/file=1;" {
+		t.Errorf("expect %v, but get %v", "/sub/file=1;", got)
+	}
+
+	// Keys follow the location after the directory is moved.
+	moved := dir + "-moved"
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(moved)
+	s2 := New(Options{Root: moved})
+	if got := dump(t, s2, moved); got != "/sub/file=1;" {
+		t.Errorf("expect %v, but get %v", "/sub/file=1;", got)
+	}
+	if v, err := s2.Get([]byte(filepath.Join(moved, "sub", "file"))); err != nil || string(v) != "1" {
+		t.Errorf("expect %v, but get %s, %v", "1", v, err)
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	testcases := []struct {
+		description string
+		data        []byte
+	}{
+		{"bad magic", []byte("bad")},
+		{"bad key length", append([]byte(magic), 0xff)},
+		{"short key", append([]byte(magic), 10, 'a')},
+	}
+
+	for idx, tc := range testcases {
+		dir, cleanup := tempDir(t)
+		var logger recordLogger
+		s := New(Options{Root: dir, Logger: &logger})
+		path := filepath.Join(dir, "a")
+		ioutil.WriteFile(path+DefaultSuffix, tc.data, 0644)
+		if err := s.Put([]byte(filepath.Join(dir, "b")), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Get([]byte(path)); errors.Cause(err) != ErrCorrupted {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, ErrCorrupted, err)
+		}
+
+		// The corrupted record file is skipped and logged, while others are
+		// still iterated.
+		if got := dump(t, s, dir); got != "/b=1;" {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, "/b=1;", got)
+		}
+		if len(logger.msgs) != 1 {
+			t.Errorf("[#Case%d] %s: expect 1 log, but get %v", idx, tc.description, logger.msgs)
+		}
+		err := s.Update([]byte(path), func(old []byte) ([]byte, error) { return nil, nil })
+		if errors.Cause(err) != ErrCorrupted {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, ErrCorrupted, err)
+		}
+		cleanup()
+	}
+}
+
+func TestIterForeignFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// A stray file under the hidden directory does not fail the iteration.
+	var logger recordLogger
+	s := New(Options{Root: dir, Layout: Hashed, Logger: &logger})
+	if err := s.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, HiddenDir, "README"), []byte("hello"), 0644)
+
+	var keys []string
+	err := s.Iter(func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil || fmt.Sprint(keys) != "[a]" {
+		t.Errorf("expect %v, but get %v, %v", "[a]", keys, err)
+	}
+	if len(logger.msgs) != 1 {
+		t.Errorf("expect 1 log, but get %v", logger.msgs)
+	}
+}
+
+// recordLogger records messages of logs.
+type recordLogger struct {
+	msgs []string
+}
+
+func (l *recordLogger) Log(level log.Level, msg string, fields ...log.Field) {
+	l.msgs = append(l.msgs, msg)
+}
+
+func TestMissingDir(t *testing.T) {
+	testcases := []struct {
+		description string
+		layout      Layout
+	}{
+		{"sidecar", Sidecar},
+		{"hashed", Hashed},
+	}
+
+	for idx, tc := range testcases {
+		dir, cleanup := tempDir(t)
+
+		// A key is registered before the directory of its file is created.
+		ada := backend.Adapter(New(Options{Root: dir, Layout: tc.layout}), codec.Gob{})
+		key := filepath.Join(dir, "sub", "dir", "file")
+		if err := ada.IncrRef(key); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+		if item, err := ada.Get(key); err != nil || item.Reference() != 1 {
+			t.Errorf("[#Case%d] %s: expect a registered item, but get %v, %v", idx, tc.description, item, err)
+		}
+		cleanup()
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// The directory of the cached file is unable to be created.
+	s := New(Options{Root: dir})
+	ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err := s.Put([]byte(filepath.Join(dir, "file", "a")), []byte("1")); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+	if got := dump(t, s, dir); got != "" {
+		t.Errorf("expect an empty store, but get %v", got)
+	}
+
+	s = New(Options{Root: "/dev/null", Layout: Hashed})
+	if err := s.Put([]byte("a"), []byte("1")); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "a")
+	pool := backend.Adapter(New(Options{Root: dir, Layout: Hashed}), codec.Gob{})
+	if err := pool.Put(path, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.IncrRef(path); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = backend.Adapter(New(Options{Root: dir, Layout: Hashed}), codec.Gob{})
+	item, err := pool.Get(path)
+	if err != nil || !item.IsReal() || item.Reference() != 1 {
+		t.Errorf("expect a referenced real item, but get %v, %v", item, err)
+	}
+	count := 0
+	pool.Iter(func(k string, v cache.Item) error {
+		count++
+		if k != path {
+			t.Errorf("expect %v, but get %v", path, k)
+		}
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("expect %v, but get %v", 1, count)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for _, layout := range []Layout{Sidecar, Hashed} {
+		layout := layout
+		t.Run(fmt.Sprintf("Layout%d", layout), func(t *testing.T) {
+			backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+				dir, cleanup := tempDir(t)
+				t.Cleanup(cleanup)
+				return New(Options{Root: dir, Layout: layout})
+			}, backendtest.WithKeys(func(store backend.Store, name string) []byte {
+				return []byte(filepath.Join(store.(*Store).opts.Root, name))
+			}))
+		})
+	}
+}
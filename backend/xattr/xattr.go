@@ -0,0 +1,365 @@
+// Package xattr implements backend.Store by storing values in an extended
+// attribute of the cached file itself, whose path is the key. Metadata goes
+// away with the file when it is deleted and follows the file when it is
+// moved, so they never disagree. A lightweight index of keys is kept in a
+// separate file so that Iter needs not to walk the filesystem.
+//
+// Extended attributes are only supported on Linux. Filesystems without
+// support of user extended attributes are reported by ErrNotSupported.
+package xattr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+// DefaultName is the name of the extended attribute if it is not specified.
+const DefaultName = "user.fcache"
+
+// MaxValueSize is the largest value of an extended attribute which Linux
+// accepts. Filesystems usually limit it further, such as ext4 which keeps all
+// the attributes of a file within a block, 4 KiB in general.
+const MaxValueSize = 64 << 10
+
+// Entries of the index file are laid out as op | path | NUL, since a path
+// never contains NUL.
+const (
+	opAdd    byte = '+'
+	opRemove byte = '-'
+)
+
+var (
+	// ErrNotSupported raises when the platform or the filesystem does not
+	// support user extended attributes.
+	ErrNotSupported = errors.New("extended attributes are not supported")
+
+	// errNoAttr raises when a file has no attribute with given name.
+	errNoAttr = errors.New("no such attribute")
+)
+
+// ValueTooLargeError raises when a value does not fit in the extended
+// attribute of the file at Key, either since it exceeds MaxValueSize or the
+// limit of the filesystem. Err is the error of the system call, if any, where
+// ENOSPC is taken as well, though a full filesystem raises it too.
+type ValueTooLargeError struct {
+	Key  string
+	Size int
+	Err  error
+}
+
+// Error implements error interface.
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("value of %d bytes is too large for the extended attribute of %q: %v", e.Size, e.Key, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *ValueTooLargeError) Cause() error { return e.Err }
+
+// Unwrap returns the underlying error.
+func (e *ValueTooLargeError) Unwrap() error { return e.Err }
+
+// IsValueTooLarge returns true if the error is a ValueTooLargeError.
+func IsValueTooLarge(err error) bool {
+	_, ok := err.(*ValueTooLargeError)
+	return ok
+}
+
+// Options configures a xattr store.
+type Options struct {
+	// Index is the path to the index file which lists keys for Iter.
+	Index string
+
+	// Name is the name of the extended attribute, which must be in the
+	// user namespace. DefaultName will be used if it is empty.
+	Name string
+
+	// Mode is the permission of the index file, 0644 by default.
+	Mode os.FileMode
+
+	// Sync makes changes of the index flushed to the disk before returning.
+	Sync bool
+}
+
+// Open opens a xattr store with given options. It probes the directory of
+// the index and returns ErrNotSupported if its filesystem does not support
+// extended attributes.
+func Open(opts Options) (*Store, error) {
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if opts.Mode == 0 {
+		opts.Mode = 0644
+	}
+	if err := probe(filepath.Dir(opts.Index), opts.Name); err != nil {
+		return nil, err
+	}
+
+	keys, err := loadIndex(opts.Index)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{opts: opts, keys: keys, pending: make(map[string][]byte)}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Store implements backend.Store interface.
+type Store struct {
+	opts  Options
+	index *os.File
+	keys  map[string]struct{}
+
+	// pending holds values of keys whose files do not exist yet, such as
+	// references taken before a file is cached. They are kept in memory
+	// only, and written into the attribute once the file is created.
+	pending map[string][]byte
+
+	mu sync.Mutex
+}
+
+// Put sets the attribute of the file at k to v. It returns a
+// ValueTooLargeError if v does not fit in the attribute.
+func (s *Store) Put(k, v []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(string(k), v)
+}
+
+// Get gets the attribute of the file at k. If the file does not exist or
+// it has no attribute, it returns cache.ErrNoSuchKey.
+func (s *Store) Get(k []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(string(k))
+}
+
+// Update reads, modifies and writes the attribute of a file with the lock
+// held. The old value passed to fn is nil if the key does not present. If
+// fn returns a nil value, nothing will be written.
+func (s *Store) Update(k []byte, fn func(old []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(k)
+	old, err := s.get(key)
+	if err != nil && err != cache.ErrNoSuchKey {
+		return err
+	}
+	v, err := fn(old)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.put(key, v)
+}
+
+// Remove removes the attribute of the file at k. It will return no error
+// even if the file or the attribute does not exist.
+func (s *Store) Remove(k []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(k)
+	delete(s.pending, key)
+	err := removexattr(key, s.opts.Name)
+	if err != nil && err != errNoAttr && !os.IsNotExist(err) {
+		return err
+	}
+	return s.unindex(key)
+}
+
+// Iter iterates all key-value pairs in the index in lexical order of keys.
+// Keys whose files are deleted or moved are dropped from the index.
+func (s *Store) Iter(iterCb func(k, v []byte) error) error {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.keys)+len(s.pending))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	for k := range s.pending {
+		if _, ok := s.keys[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s.mu.Lock()
+		v, err := s.get(k)
+		if err == cache.ErrNoSuchKey {
+			err = s.unindex(k)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		if err := iterCb([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the index file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		return nil
+	}
+	err := s.index.Close()
+	s.index = nil
+	return err
+}
+
+func (s *Store) get(key string) ([]byte, error) {
+	v, err := getxattr(key, s.opts.Name)
+	if err == errNoAttr || os.IsNotExist(err) {
+		if v, ok := s.pending[key]; ok {
+			return v, nil
+		}
+		return nil, cache.ErrNoSuchKey
+	}
+	return v, err
+}
+
+func (s *Store) put(key string, v []byte) error {
+	if len(v) > MaxValueSize {
+		return &ValueTooLargeError{Key: key, Size: len(v), Err: errors.Errorf("exceeds %d bytes", MaxValueSize)}
+	}
+	err := setxattr(key, s.opts.Name, v)
+	if os.IsNotExist(err) {
+		s.pending[key] = append([]byte{}, v...)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	delete(s.pending, key)
+	if _, ok := s.keys[key]; ok {
+		return nil
+	}
+	if err := s.log(opAdd, key); err != nil {
+		return err
+	}
+	s.keys[key] = struct{}{}
+	return nil
+}
+
+func (s *Store) unindex(key string) error {
+	if _, ok := s.keys[key]; !ok {
+		return nil
+	}
+	if err := s.log(opRemove, key); err != nil {
+		return err
+	}
+	delete(s.keys, key)
+	return nil
+}
+
+// log appends an entry to the index file.
+func (s *Store) log(op byte, key string) error {
+	if s.index == nil {
+		return os.ErrClosed
+	}
+	buf := make([]byte, 0, len(key)+2)
+	buf = append(buf, op)
+	buf = append(buf, key...)
+	buf = append(buf, 0)
+	if _, err := s.index.Write(buf); err != nil {
+		return err
+	}
+	if s.opts.Sync {
+		return s.index.Sync()
+	}
+	return nil
+}
+
+// compact rewrites the index file with current keys only, and opens it for
+// appending.
+func (s *Store) compact() (err error) {
+	tmp := s.opts.Index + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.opts.Mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	for k := range s.keys {
+		w.WriteByte(opAdd)
+		w.WriteString(k)
+		w.WriteByte(0)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.opts.Index); err != nil {
+		return err
+	}
+	s.index, err = os.OpenFile(s.opts.Index, os.O_WRONLY|os.O_APPEND, s.opts.Mode)
+	return err
+}
+
+// loadIndex replays the index file into a set of keys. An incomplete entry
+// at the tail, which is left by a crash during writing, is ignored.
+func loadIndex(path string) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return keys, nil
+		}
+		if end > 0 {
+			switch key := string(data[1:end]); data[0] {
+			case opAdd:
+				keys[key] = struct{}{}
+			case opRemove:
+				delete(keys, key)
+			}
+		}
+		data = data[end+1:]
+	}
+}
+
+// probe checks if the filesystem of dir supports extended attributes with
+// given name.
+func probe(dir, name string) error {
+	f, err := ioutil.TempFile(dir, ".fcache-xattr-probe")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	return setxattr(f.Name(), name, []byte{0})
+}
@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package xattr
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func getxattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, wrap("getxattr", path, err)
+		}
+		buf := make([]byte, size)
+		n, err := unix.Getxattr(path, name, buf)
+		// The attribute grows between two calls, just try again.
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, wrap("getxattr", path, err)
+		}
+		return buf[:n], nil
+	}
+}
+
+func setxattr(path, name string, v []byte) error {
+	err := unix.Setxattr(path, name, v, 0)
+	switch err {
+	case unix.E2BIG, unix.ERANGE, unix.ENOSPC:
+		return &ValueTooLargeError{Key: path, Size: len(v), Err: err}
+	}
+	return wrap("setxattr", path, err)
+}
+
+func removexattr(path, name string) error {
+	return wrap("removexattr", path, unix.Removexattr(path, name))
+}
+
+// wrap converts errors of system calls so that a missing file satisfies
+// os.IsNotExist, a missing attribute is errNoAttr and an unsupported
+// filesystem is ErrNotSupported itself, so it could be compared directly.
+func wrap(op, path string, err error) error {
+	switch err {
+	case nil:
+		return nil
+	case unix.ENODATA:
+		return errNoAttr
+	case unix.ENOTSUP:
+		return ErrNotSupported
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package xattr
+
+func getxattr(path, name string) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+func setxattr(path, name string, v []byte) error {
+	return ErrNotSupported
+}
+
+func removexattr(path, name string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,283 @@
+//go:build linux
+// +build linux
+
+package xattr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/backendtest"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var errMock = errors.New("mock error")
+
+func tempStore(t *testing.T) (*Store, string, func()) {
+	dir, err := ioutil.TempDir("", "xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(Options{Index: filepath.Join(dir, "index"), Sync: true})
+	if err == ErrNotSupported {
+		os.RemoveAll(dir)
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir, func() { s.Close(); os.RemoveAll(dir) }
+}
+
+func reopen(t *testing.T, s *Store) *Store {
+	s.Close()
+	s2, err := Open(s.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s2
+}
+
+func touch(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dump(t *testing.T, s *Store, dir string) string {
+	var buf bytes.Buffer
+	err := s.Iter(func(k, v []byte) error {
+		fmt.Fprintf(&buf, "%s=%s;", strings.TrimPrefix(string(k), dir), v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestStore(t *testing.T) {
+	s, dir, cleanup := tempStore(t)
+	defer cleanup()
+	key := func(name string) []byte { return []byte(filepath.Join(dir, name)) }
+	touch(t, dir, "a", "b", "c")
+
+	testcases := []struct {
+		description string
+		scenario    func() error
+		expect      string
+	}{
+		{"put", func() error { return s.Put(key("b"), []byte("1")) }, "/b=1;"},
+		{"put another", func() error { return s.Put(key("a"), []byte("2")) }, "/a=2;/b=1;"},
+		{"overwrite", func() error { return s.Put(key("b"), []byte("3")) }, "/a=2;/b=3;"},
+		{"remove", func() error { return s.Remove(key("a")) }, "/b=3;"},
+		{"remove missing attribute", func() error { return s.Remove(key("a")) }, "/b=3;"},
+		{"remove missing file", func() error { return s.Remove(key("z")) }, "/b=3;"},
+		{"update", func() error {
+			return s.Update(key("b"), func(old []byte) ([]byte, error) {
+				return append(old, '4'), nil
+			})
+		}, "/b=34;"},
+		{"update without writing", func() error {
+			return s.Update(key("c"), func(old []byte) ([]byte, error) {
+				if old != nil {
+					t.Errorf("expect nil old value, but get %s", old)
+				}
+				return nil, nil
+			})
+		}, "/b=34;"},
+		{"abort update", func() error {
+			err := s.Update(key("b"), func(old []byte) ([]byte, error) {
+				return []byte("5"), errMock
+			})
+			if err != errMock {
+				return errors.Errorf("expect %v, but get %v", errMock, err)
+			}
+			return nil
+		}, "/b=34;"},
+		{"put missing file", func() error { return s.Put(key("d"), []byte("6")) }, "/b=34;/d=6;"},
+		{"create the missing file", func() error {
+			touch(t, dir, "d")
+			return s.Put(key("d"), []byte("7"))
+		}, "/b=34;/d=7;"},
+		{"delete a file", func() error { return os.Remove(filepath.Join(dir, "d")) }, "/b=34;"},
+	}
+
+	for idx, tc := range testcases {
+		if err := tc.scenario(); err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+		}
+		if got := dump(t, s, dir); got != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, got)
+		}
+	}
+
+	if v, err := s.Get(key("b")); err != nil || string(v) != "34" {
+		t.Errorf("expect %v, but get %s, %v", "34", v, err)
+	}
+	if _, err := s.Get(key("a")); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if err := s.Iter(func(k, v []byte) error { return errMock }); err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	s, dir, cleanup := tempStore(t)
+	defer cleanup()
+	touch(t, dir, "a")
+
+	if err := s.Put([]byte(filepath.Join(dir, "a")), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get([]byte(filepath.Join(dir, "b"))); err != nil || string(v) != "1" {
+		t.Errorf("expect metadata to follow the file, but get %s, %v", v, err)
+	}
+	if got := dump(t, s, dir); got != "" {
+		t.Errorf("expect the old key to be dropped, but get %v", got)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	s, dir, cleanup := tempStore(t)
+	defer cleanup()
+	touch(t, dir, "a", "b", "c")
+
+	s.Put([]byte(filepath.Join(dir, "a")), []byte("1"))
+	s.Put([]byte(filepath.Join(dir, "b")), []byte("2"))
+	s.Put([]byte(filepath.Join(dir, "c")), []byte("3"))
+	s.Remove([]byte(filepath.Join(dir, "b")))
+	s.Put([]byte(filepath.Join(dir, "missing")), []byte("4"))
+
+	// An incomplete entry at the tail is ignored.
+	f, err := os.OpenFile(s.opts.Index, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("-" + filepath.Join(dir, "a"))
+	f.Close()
+
+	s = reopen(t, s)
+	defer s.Close()
+	if got := dump(t, s, dir); got != "/a=1;/c=3;" {
+		t.Errorf("expect %v, but get %v", "/a=1;/c=3;", got)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := s.Put([]byte(filepath.Join(dir, "b")), []byte("2")); err == nil {
+		t.Errorf("expect error occurs after closing, but get no error")
+	}
+}
+
+func TestValueTooLarge(t *testing.T) {
+	s, dir, cleanup := tempStore(t)
+	defer cleanup()
+	touch(t, dir, "a")
+	key := filepath.Join(dir, "a")
+
+	testcases := []struct {
+		description string
+		size        int
+		mustFail    bool
+	}{
+		{"over the limit of Linux", MaxValueSize + 1, true},
+		{"up to the limit of the filesystem", MaxValueSize, false},
+	}
+
+	for idx, tc := range testcases {
+		err := s.Put([]byte(key), make([]byte, tc.size))
+		if err == nil && !tc.mustFail {
+			continue
+		}
+		e, ok := err.(*ValueTooLargeError)
+		if !ok || e.Key != key || e.Size != tc.size || !strings.Contains(err.Error(), key) {
+			t.Errorf("[#Case%d] %s: expect a value too large error of %s, but get %v", idx, tc.description, key, err)
+		}
+	}
+
+	// The value is kept if a new one fails to be put.
+	if err := s.Put([]byte(key), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put([]byte(key), make([]byte, MaxValueSize+1)); !IsValueTooLarge(err) {
+		t.Errorf("expect a value too large error, but get %v", err)
+	}
+	if got := dump(t, s, dir); got != "/a=1;" {
+		t.Errorf("expect %v, but get %v", "/a=1;", got)
+	}
+	if err := setxattr(key, DefaultName, make([]byte, MaxValueSize+1)); !IsValueTooLarge(err) || errors.Cause(err) != unix.E2BIG {
+		t.Errorf("expect a value too large error wrapping %v, but get %v", unix.E2BIG, err)
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	if err := wrap("setxattr", "/a", unix.ENOTSUP); err != ErrNotSupported {
+		t.Errorf("expect %v, but get %v", ErrNotSupported, err)
+	}
+	if err := wrap("getxattr", "/a", unix.ENOENT); !os.IsNotExist(err) {
+		t.Errorf("expect a not exist error, but get %v", err)
+	}
+	if _, err := Open(Options{Index: "/dev/null/index"}); err == nil {
+		t.Errorf("expect error occurs, but get no error")
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	s, dir, cleanup := tempStore(t)
+	defer cleanup()
+	path := filepath.Join(dir, "a")
+	touch(t, dir, "a")
+
+	pool := backend.Adapter(s, codec.Gob{})
+	if err := pool.Put(path, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.IncrRef(path); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = backend.Adapter(reopen(t, s), codec.Gob{})
+	item, err := pool.Get(path)
+	if err != nil || !item.IsReal() || item.Reference() != 1 {
+		t.Errorf("expect a referenced real item, but get %v, %v", item, err)
+	}
+	if err := pool.Remove(path); err != nil {
+		t.Error(err)
+	}
+	if _, err := pool.Get(path); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunStoreTests(t, func(t *testing.T) backend.Store {
+		s, _, cleanup := tempStore(t)
+		t.Cleanup(cleanup)
+		return s
+	}, backendtest.WithKeys(func(store backend.Store, name string) []byte {
+		// Keys are paths to existing files next to the index.
+		dir := filepath.Dir(store.(*Store).opts.Index)
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			ioutil.WriteFile(path, nil, 0644)
+		}
+		return []byte(path)
+	}))
+}
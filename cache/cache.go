@@ -44,6 +44,7 @@ type Item struct {
 	Real      bool
 	CreatedAt time.Time
 	LastUsed  time.Time
+	Metadata  Metadata
 }
 
 // SetSize sets the field of cache size.
@@ -96,6 +97,12 @@ func (f *Item) UpdateLastUsed() {
 	f.LastUsed = time.Now()
 }
 
+// Meta returns the value of the metadata key, or an empty string if it is
+// not set.
+func (f *Item) Meta(key string) string {
+	return f.Metadata[key]
+}
+
 // Remove removes the cache item from disk.
 func (f *Item) Remove() error {
 	if f.Real {
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/xml"
+	"sort"
+)
+
+// Well-known keys of metadata.
+const (
+	MetaContentType  = "Content-Type"
+	MetaSourceURL    = "Source-URL"
+	MetaETag         = "ETag"
+	MetaLastModified = "Last-Modified"
+	MetaContentHash  = "Content-Hash"
+)
+
+// Metadata is user-defined metadata attached to a cache item, such as the
+// content type, where the file comes from and arbitrary labels.
+type Metadata map[string]string
+
+// Clone returns a copy of the metadata, or nil if it is empty.
+func (md Metadata) Clone() Metadata {
+	if len(md) == 0 {
+		return nil
+	}
+	clone := make(Metadata, len(md))
+	for k, v := range md {
+		clone[k] = v
+	}
+	return clone
+}
+
+// Merge returns the metadata with entries of other merged into it, where
+// empty values remove the entries. The metadata is not modified, and nil is
+// returned if nothing is left.
+func (md Metadata) Merge(other Metadata) Metadata {
+	merged := make(Metadata, len(md)+len(other))
+	for k, v := range md {
+		merged[k] = v
+	}
+	for k, v := range other {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged.Clone()
+}
+
+// metaEntry is an entry of metadata in XML.
+type metaEntry struct {
+	Key   string `xml:"Key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// MarshalXML implements xml.Marshaler, since encoding/xml does not support
+// maps. Entries are sorted by keys, and empty metadata is omitted.
+func (md Metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if len(md) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	entries := struct {
+		Entry []metaEntry
+	}{}
+	for _, k := range keys {
+		entries.Entry = append(entries.Entry, metaEntry{k, md[k]})
+	}
+	return e.EncodeElement(entries, start)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (md *Metadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var entries struct {
+		Entry []metaEntry
+	}
+	if err := d.DecodeElement(&entries, &start); err != nil {
+		return err
+	}
+	*md = make(Metadata, len(entries.Entry))
+	for _, e := range entries.Entry {
+		(*md)[e.Key] = e.Value
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataClone(t *testing.T) {
+	md := Metadata{MetaETag: `"abc"`}
+	clone := md.Clone()
+	clone[MetaETag] = `"def"`
+	if md[MetaETag] != `"abc"` {
+		t.Errorf("expect the clone to be independent, but get %v", md)
+	}
+	if (Metadata{}).Clone() != nil {
+		t.Errorf("expect nil for empty metadata")
+	}
+}
+
+func TestMetadataMerge(t *testing.T) {
+	testcases := []struct {
+		md     Metadata
+		other  Metadata
+		expect Metadata
+	}{
+		{nil, nil, nil},
+		{nil, Metadata{"a": "1"}, Metadata{"a": "1"}},
+		{Metadata{"a": "1"}, nil, Metadata{"a": "1"}},
+		{Metadata{"a": "1", "b": "2"}, Metadata{"b": "3", "c": "4"}, Metadata{"a": "1", "b": "3", "c": "4"}},
+		{Metadata{"a": "1", "b": "2"}, Metadata{"a": ""}, Metadata{"b": "2"}},
+		{Metadata{"a": "1"}, Metadata{"a": ""}, nil},
+	}
+
+	for idx, tc := range testcases {
+		got := tc.md.Merge(tc.other)
+		if !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, got)
+		}
+	}
+}
+
+func TestMetadataXML(t *testing.T) {
+	testcases := []Item{
+		{Key: "plain"},
+		{Key: "labeled", Metadata: Metadata{
+			MetaContentType: "text/html",
+			MetaSourceURL:   "https://example.com/?a=1&b=<2>",
+			"empty":         "",
+		}},
+	}
+
+	for idx, tc := range testcases {
+		data, err := xml.Marshal(tc)
+		if err != nil {
+			t.Fatalf("[#Case%d]: %v", idx, err)
+		}
+		var got Item
+		if err := xml.Unmarshal(data, &got); err != nil {
+			t.Fatalf("[#Case%d]: %v", idx, err)
+		}
+		if !reflect.DeepEqual(got.Metadata, tc.Metadata) {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.Metadata, got.Metadata)
+		}
+	}
+}
+
+func TestItemMeta(t *testing.T) {
+	item := Dummy(10, "123")
+	if v := item.Meta(MetaETag); v != "" {
+		t.Errorf("expect empty value, but get %v", v)
+	}
+	item.Metadata = Metadata{MetaETag: `"abc"`}
+	if v := item.Meta(MetaETag); v != `"abc"` {
+		t.Errorf("expect %v, but get %v", `"abc"`, v)
+	}
+}
@@ -17,7 +17,8 @@ type Pool interface {
 	// special error as ErrNoSuchKey.
 	Get(key string) (Item, error)
 
-	// Remove removes a key from the pool. If the key does not exist, then nothing should be done.
+	// Remove removes a file cache from the pool with given key. If the key does not exist, then
+	// nothing should be done.
 	Remove(key string) error
 
 	// IncrRef increment the reference count of the file caches with given keys by one. If the
@@ -33,3 +34,41 @@ type Pool interface {
 	// the allocated resources of the cache pool.
 	Close() error
 }
+
+// Scanner is a pool which is able to iterate cache items in order.
+type Scanner interface {
+
+	// Scan is similar to Iter, but iterates key-value pairs in ascending order of keys,
+	// starting from the first key which is equal to or greater than start.
+	Scan(start string, iterCb func(k string, v Item) error) error
+}
+
+// BatchRemover is a pool which is able to remove multiple keys at once.
+type BatchRemover interface {
+
+	// BatchRemove removes keys from the pool, preferably all-or-nothing. If a key does not
+	// exist, then nothing should be done for it.
+	BatchRemove(keys ...string) error
+}
+
+// Annotator is a pool which is able to modify metadata of cache items.
+type Annotator interface {
+
+	// Annotate merges metadata into the cache item with given key, where empty
+	// values remove the entries. If the key is missed, returns ErrNoSuchKey.
+	Annotate(key string, md Metadata) error
+
+	// PutWithMetadata is like Put, but writes the metadata in the same record,
+	// so the cache item is never seen without it.
+	PutWithMetadata(key string, size int64, md Metadata) error
+}
+
+// Replacer is a pool which is able to replace cache items in place, whose
+// files have been replaced by new content.
+type Replacer interface {
+
+	// Replace sets the size and creation time of the cache item with given key,
+	// and merges metadata into it like Annotate, while keeping its ID and
+	// reference count. If the key is missed, returns ErrNoSuchKey.
+	Replace(key string, size int64, md Metadata) error
+}
@@ -0,0 +1,71 @@
+package cache
+
+import "time"
+
+// Keys of metadata used by revalidation, along with MetaETag and
+// MetaLastModified.
+const (
+	MetaVersion     = "Version"
+	MetaValidatedAt = "Validated-At"
+)
+
+// httpTime is the time format of Last-Modified, which is the same as
+// http.TimeFormat.
+const httpTime = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Validators identify a version of the object which a cache item copies from
+// its origin, so the origin could tell whether the cache item is still
+// current or not, such as by If-None-Match or If-Modified-Since of HTTP.
+type Validators struct {
+	ETag         string
+	LastModified time.Time
+	Version      string
+}
+
+// IsZero returns true if no validator is set.
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified.IsZero() && v.Version == ""
+}
+
+// Metadata returns the validators as metadata. Validators which are not set
+// have empty values, so merging it removes the stale ones.
+func (v Validators) Metadata() Metadata {
+	md := Metadata{
+		MetaETag:         v.ETag,
+		MetaLastModified: "",
+		MetaVersion:      v.Version,
+	}
+	if !v.LastModified.IsZero() {
+		md[MetaLastModified] = v.LastModified.UTC().Format(httpTime)
+	}
+	return md
+}
+
+// Validators returns the validators stored in the metadata of the cache
+// item. A malformed Last-Modified is ignored.
+func (f *Item) Validators() Validators {
+	v := Validators{
+		ETag:    f.Meta(MetaETag),
+		Version: f.Meta(MetaVersion),
+	}
+	if s := f.Meta(MetaLastModified); s != "" {
+		v.LastModified, _ = time.Parse(httpTime, s)
+	}
+	return v
+}
+
+// ValidatedAt returns when the cache item has been validated against its
+// origin last time, or a zero time if it never has been.
+func (f *Item) ValidatedAt() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, f.Meta(MetaValidatedAt))
+	return t
+}
+
+// SetValidatedAt records when the cache item has been validated against its
+// origin in its metadata.
+func (f *Item) SetValidatedAt(t time.Time) {
+	if f.Metadata == nil {
+		f.Metadata = make(Metadata)
+	}
+	f.Metadata[MetaValidatedAt] = t.UTC().Format(time.RFC3339Nano)
+}
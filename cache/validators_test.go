@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidators(t *testing.T) {
+	modified := time.Date(2020, 8, 15, 3, 6, 45, 0, time.UTC)
+	testcases := []struct {
+		validators Validators
+		expect     Metadata
+	}{
+		{
+			Validators{},
+			Metadata{MetaETag: "", MetaLastModified: "", MetaVersion: ""},
+		},
+		{
+			Validators{ETag: `"abc"`, LastModified: modified.In(time.FixedZone("UTC+8", 8*3600)), Version: "3"},
+			Metadata{MetaETag: `"abc"`, MetaLastModified: "Sat, 15 Aug 2020 03:06:45 GMT", MetaVersion: "3"},
+		},
+	}
+
+	for idx, tc := range testcases {
+		md := tc.validators.Metadata()
+		if !reflect.DeepEqual(md, tc.expect) {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, md)
+		}
+
+		item := Item{Metadata: Metadata{"label": "x"}.Merge(md)}
+		v := item.Validators()
+		if v.ETag != tc.validators.ETag || v.Version != tc.validators.Version ||
+			!v.LastModified.Equal(tc.validators.LastModified) {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.validators, v)
+		}
+		if v.IsZero() != tc.validators.IsZero() {
+			t.Errorf("[#Case%d]: expect zero %v, but get %v", idx, tc.validators.IsZero(), v.IsZero())
+		}
+	}
+}
+
+func TestValidatedAt(t *testing.T) {
+	item := Dummy(10, "123")
+	if !item.ValidatedAt().IsZero() {
+		t.Errorf("expect zero time, but get %v", item.ValidatedAt())
+	}
+
+	now := time.Now()
+	item.SetValidatedAt(now)
+	if !item.ValidatedAt().Equal(now) {
+		t.Errorf("expect %v, but get %v", now, item.ValidatedAt())
+	}
+
+	item.Metadata[MetaValidatedAt] = "yesterday"
+	if !item.ValidatedAt().IsZero() {
+		t.Errorf("expect zero time for malformed value, but get %v", item.ValidatedAt())
+	}
+}
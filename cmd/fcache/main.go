@@ -0,0 +1,118 @@
+// Command fcache provides maintenance tools for the metadata stores of
+// file cache managers.
+//
+// Usage:
+//
+//	fcache <command> [flags]
+//
+// The commands are:
+//
+//	repair    clean up corrupted records of a boltdb store
+//	migrate   copy records from a store to another
+//	export    write records of a store as JSON lines
+//	import    read records from JSON lines into a store
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/boltdb"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/codec"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"repair":  {"clean up corrupted records of a boltdb store", runRepair},
+	"migrate": {"copy records from a store to another", runMigrate},
+	"export":  {"write records of a store as JSON lines", runExport},
+	"import":  {"read records from JSON lines into a store", runImport},
+}
+
+var codecs = map[string]codec.Codec{
+	"gob":     codec.Gob{},
+	"json":    codec.JSON{},
+	"xml":     codec.XML{},
+	"msgpack": codec.MessagePack{},
+	"cbor":    codec.CBOR{},
+	"binary":  codec.Binary{},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fcache <command> [flags]\n\ncommands:\n%s", usage())
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n\ncommands:\n%s", args[0], usage())
+	}
+	return cmd.run(args[1:], stdout)
+}
+
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "  %-10s%s\n", name, commands[name].usage)
+	}
+	return sb.String()
+}
+
+func lookupCodec(name string) (codec.Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+func openBoltDB(path, bucket string) (*boltdb.BoltDB, error) {
+	return boltdb.Open(boltdb.Options{
+		Path:   path,
+		Mode:   0644,
+		Bucket: bucket,
+	})
+}
+
+// openStore opens a store of the given type, which is either "boltdb" or
+// "gomap". A gomap store is loaded from its snapshot, and the bucket is
+// ignored. A source store must exist, and a gomap one is read without
+// touching its files, so nothing is created by mistake.
+func openStore(typ, path, bucket string, source bool) (backend.Store, error) {
+	switch typ {
+	case "boltdb":
+		if source {
+			if _, err := os.Stat(path); err != nil {
+				return nil, err
+			}
+		}
+		return openBoltDB(path, bucket)
+	case "gomap":
+		if source {
+			return gomap.Read(path)
+		}
+		return gomap.Load(gomap.PersistOptions{Path: path, WAL: true})
+	}
+	return nil, fmt.Errorf("unknown store type %q", typ)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+func TestRun(t *testing.T) {
+	testcases := []struct {
+		description string
+		args        []string
+		expectErr   string
+	}{
+		{"no command", nil, "usage: fcache <command>"},
+		{"unknown command", []string{"foo"}, `unknown command "foo"`},
+		{"repair without db", []string{"repair"}, "flag -db is required"},
+		{"repair with unknown codec", []string{"repair", "-db", "x.db", "-codec", "foo"}, `unknown codec "foo"`},
+		{"repair into the same file", []string{"repair", "-db", "x.db", "-quarantine", "./x.db"}, "flag -quarantine must differ from -db"},
+		{"migrate without source", []string{"migrate", "-to-db", "x.db"}, "flag -from-db is required"},
+		{"migrate with unknown store type", []string{"migrate", "-from-db", "x.db", "-from-type", "foo"}, `unknown store type "foo"`},
+		{"export without db", []string{"export"}, "flag -db is required"},
+		{"import without input", []string{"import", "-db", "x.db"}, "flag -i is required"},
+	}
+
+	for idx, tc := range testcases {
+		err := run(tc.args, ioutil.Discard)
+		if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+			t.Errorf("[#Case%d] %s: expect %q, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcache-repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	qpath := filepath.Join(dir, "quarantine.db")
+	testcases := []struct {
+		description string
+		args        []string
+		qPath       string
+		qBuckets    []string
+	}{
+		{"quarantine into a bucket of the same file", nil, "", []string{"cache", "quarantine"}},
+		{"quarantine into another file", []string{"-quarantine", qpath}, qpath, []string{"quarantine"}},
+		{"drop corrupted records", []string{"-quarantine-bucket", ""}, "", nil},
+	}
+
+	for idx, tc := range testcases {
+		path := filepath.Join(dir, fmt.Sprintf("cache-%d.db", idx))
+		store, err := openBoltDB(path, "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := codec.Gob{}.Marshal(cache.New(1, "good", 10))
+		store.Put([]byte("good"), data)
+		store.Put([]byte("bad"), []byte("corrupted"))
+		store.Close()
+
+		var out bytes.Buffer
+		err = run(append([]string{"repair", "-db", path}, tc.args...), &out)
+		if err != nil {
+			t.Fatalf("[#Case%d] %s: %v", idx, tc.description, err)
+		}
+		expect := "corrupted: bad\nscanned 2 records, 1 corrupted\n"
+		if out.String() != expect {
+			t.Errorf("[#Case%d] %s: expect %q, but get %q", idx, tc.description, expect, out.String())
+		}
+		if tc.qBuckets == nil {
+			continue
+		}
+
+		if tc.qPath == "" {
+			tc.qPath = path
+		}
+		db, err := openBoltDB(tc.qPath, tc.qBuckets[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := db.Namespace(tc.qBuckets[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := q.Get([]byte("bad")); err != nil || string(v) != "corrupted" {
+			t.Errorf("[#Case%d] %s: expect the record to be quarantined, but get %s, %v", idx, tc.description, v, err)
+		}
+		db.Close()
+	}
+}
+
+func TestMigrateExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcache-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "cache.db")
+	store, err := openBoltDB(src, "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := codec.Gob{}.Marshal(cache.New(1, "a", 10))
+	store.Put([]byte("a"), data)
+	store.Close()
+
+	// Migrate from boltdb with gob to gomap with json.
+	snapshot := filepath.Join(dir, "cache.snapshot")
+	var out bytes.Buffer
+	err = run([]string{"migrate", "-from-db", src, "-to-db", snapshot, "-to-type", "gomap", "-to-codec", "json"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "scanned 1 records, 1 written, 0 corrupted, 0 conflicts\n"; out.String() != expect {
+		t.Errorf("expect %q, but get %q", expect, out.String())
+	}
+
+	// Export from gomap, and import it back into the boltdb.
+	jsonl := filepath.Join(dir, "cache.jsonl")
+	out.Reset()
+	if err := run([]string{"export", "-db", snapshot, "-type", "gomap", "-codec", "json", "-o", jsonl}, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := run([]string{"import", "-db", src, "-i", jsonl}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "conflict: a\nscanned 1 records, 0 written, 0 corrupted, 1 conflicts\n"; out.String() != expect {
+		t.Errorf("expect %q, but get %q", expect, out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"export", "-db", src}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), `{"key":"a","id":1,"path":"a","size":10,`) {
+		t.Errorf("expect a record exported, but get %q", out.String())
+	}
+
+	// Missing sources fail without creating any file.
+	for idx, typ := range []string{"gomap", "boltdb"} {
+		missing := filepath.Join(dir, "missing."+typ)
+		err := run([]string{"migrate", "-from-db", missing, "-from-type", typ, "-to-db", src}, ioutil.Discard)
+		if !os.IsNotExist(errors.Cause(err)) {
+			t.Errorf("[#Case%d] %s: expect a missing source error, but get %v", idx, typ, err)
+		}
+		if matches, _ := filepath.Glob(missing + "*"); len(matches) > 0 {
+			t.Errorf("[#Case%d] %s: expect no file created, but get %v", idx, typ, matches)
+		}
+	}
+}
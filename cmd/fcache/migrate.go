@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/meowdada/go-fcache/migrate"
+)
+
+// endpointFlags are flags describing a store and its codec.
+type endpointFlags struct {
+	path, typ, bucket, codec *string
+}
+
+func addEndpointFlags(fs *flag.FlagSet, prefix, desc string) endpointFlags {
+	return endpointFlags{
+		path:   fs.String(prefix+"db", "", "path to the "+desc+" store"),
+		typ:    fs.String(prefix+"type", "boltdb", "type of the "+desc+" store: boltdb or gomap"),
+		bucket: fs.String(prefix+"bucket", "cache", "bucket of cache records of the "+desc+" store"),
+		codec:  fs.String(prefix+"codec", "gob", "codec of cache records of the "+desc+" store: gob, json, xml, msgpack, cbor or binary"),
+	}
+}
+
+// open opens the endpoint, which must exist if it is a source. The returned
+// close function must be called even if an error is returned.
+func (f endpointFlags) open(prefix string, source bool) (ep migrate.Endpoint, close func() error, err error) {
+	close = func() error { return nil }
+	if *f.path == "" {
+		return ep, close, fmt.Errorf("flag -%sdb is required", prefix)
+	}
+	if ep.Codec, err = lookupCodec(*f.codec); err != nil {
+		return ep, close, err
+	}
+	if ep.Store, err = openStore(*f.typ, *f.path, *f.bucket, source); err != nil {
+		return ep, close, err
+	}
+	return ep, ep.Store.Close, nil
+}
+
+func printReport(stdout io.Writer, report migrate.Report) {
+	for _, key := range report.Corrupted {
+		fmt.Fprintf(stdout, "corrupted: %s\n", key)
+	}
+	for _, key := range report.Conflicts {
+		fmt.Fprintf(stdout, "conflict: %s\n", key)
+	}
+	fmt.Fprintf(stdout, "scanned %d records, %d written, %d corrupted, %d conflicts\n",
+		report.Scanned, report.Written, len(report.Corrupted), len(report.Conflicts))
+}
+
+func runMigrate(args []string, stdout io.Writer) (err error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	var (
+		from        = addEndpointFlags(fs, "from-", "source")
+		to          = addEndpointFlags(fs, "to-", "destination")
+		overwrite   = fs.Bool("overwrite", false, "replace records which already present in the destination")
+		skipCorrupt = fs.Bool("skip-corrupt", false, "skip records unable to be decoded")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	src, closeSrc, err := from.open("from-", true)
+	defer closeSrc()
+	if err != nil {
+		return err
+	}
+	dst, closeDst, err := to.open("to-", false)
+	defer func() {
+		if cerr := closeDst(); err == nil {
+			err = cerr
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	report, err := migrate.Migrate(dst, src, migrate.Options{
+		Overwrite:   *overwrite,
+		SkipCorrupt: *skipCorrupt,
+	})
+	printReport(stdout, report)
+	return err
+}
+
+func runExport(args []string, stdout io.Writer) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var (
+		src         = addEndpointFlags(fs, "", "source")
+		out         = fs.String("o", "", "path to the output file, print to stdout if empty")
+		skipCorrupt = fs.Bool("skip-corrupt", false, "skip records unable to be decoded")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ep, closeSrc, err := src.open("", true)
+	defer closeSrc()
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+
+	report, err := migrate.Export(w, ep, migrate.Options{SkipCorrupt: *skipCorrupt})
+	if *out != "" {
+		printReport(stdout, report)
+	}
+	return err
+}
+
+func runImport(args []string, stdout io.Writer) (err error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	var (
+		dst         = addEndpointFlags(fs, "", "destination")
+		in          = fs.String("i", "", "path to the input file")
+		overwrite   = fs.Bool("overwrite", false, "replace records which already present in the destination")
+		skipCorrupt = fs.Bool("skip-corrupt", false, "skip lines unable to be parsed")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("flag -i is required")
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ep, closeDst, err := dst.open("", false)
+	defer func() {
+		if cerr := closeDst(); err == nil {
+			err = cerr
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	report, err := migrate.Import(ep, f, migrate.Options{
+		Overwrite:   *overwrite,
+		SkipCorrupt: *skipCorrupt,
+	})
+	printReport(stdout, report)
+	return err
+}
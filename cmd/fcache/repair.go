@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/meowdada/go-fcache/backend"
+)
+
+func runRepair(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	var (
+		path      = fs.String("db", "", "path to the boltdb file")
+		bucket    = fs.String("bucket", "cache", "bucket of cache records")
+		codecName = fs.String("codec", "gob", "codec of cache records: gob, json, xml, msgpack, cbor or binary")
+		qPath     = fs.String("quarantine", "", "path to another boltdb file where corrupted records are moved into, the same one as -db if empty")
+		qBucket   = fs.String("quarantine-bucket", "quarantine", "bucket of quarantined records, nested in -bucket unless -quarantine is set, drop them if empty")
+		dryRun    = fs.Bool("dry-run", false, "only report corrupted records")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("flag -db is required")
+	}
+	// A boltdb file could not be opened twice, use a bucket of it instead.
+	if *qPath != "" && samePath(*qPath, *path) {
+		return fmt.Errorf("flag -quarantine must differ from -db, omit it to quarantine into -quarantine-bucket of -db")
+	}
+	c, err := lookupCodec(*codecName)
+	if err != nil {
+		return err
+	}
+
+	store, err := openBoltDB(*path, *bucket)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	opts := backend.RepairOptions{DryRun: *dryRun}
+	switch {
+	case *qBucket == "":
+	case *qPath == "":
+		q, err := store.Namespace(*qBucket)
+		if err != nil {
+			return err
+		}
+		opts.Quarantine = q
+	default:
+		q, err := openBoltDB(*qPath, *qBucket)
+		if err != nil {
+			return err
+		}
+		defer q.Close()
+		opts.Quarantine = q
+	}
+
+	report, err := backend.Repair(store, c, opts)
+	if err != nil {
+		return err
+	}
+	for _, key := range report.Corrupted {
+		fmt.Fprintf(stdout, "corrupted: %s\n", key)
+	}
+	fmt.Fprintf(stdout, "scanned %d records, %d corrupted\n", report.Scanned, len(report.Corrupted))
+	return nil
+}
+
+// samePath returns true if both paths refer to the same file.
+func samePath(a, b string) bool {
+	if fa, err := os.Stat(a); err == nil {
+		if fb, err := os.Stat(b); err == nil {
+			return os.SameFile(fa, fb)
+		}
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
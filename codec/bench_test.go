@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/meowdada/go-fcache/cache"
+)
+
+var benchCodecs = []struct {
+	name  string
+	codec Codec
+}{
+	{"Gob", Gob{}},
+	{"JSON", JSON{}},
+	{"XML", XML{}},
+	{"MessagePack", MessagePack{}},
+	{"CBOR", CBOR{}},
+	{"Binary", Binary{}},
+}
+
+// BenchmarkMarshal reports the size of an encoded cache item as encoded-bytes
+// along with the time.
+func BenchmarkMarshal(b *testing.B) {
+	item := testItems()[0]
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			var data []byte
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var err error
+				if data, err = bc.codec.Marshal(item); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "encoded-bytes")
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	item := testItems()[0]
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			data, err := bc.codec.Marshal(item)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var got cache.Item
+				if err := bc.codec.Unmarshal(data, &got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package codec
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+const (
+	// binaryMagic prefixes every cache item encoded by Binary.
+	binaryMagic = 0xfc
+
+	// binaryVersion is the latest version of the layout, which appends
+	// metadata to the first one. Items without metadata are still written in
+	// the first version, so they are readable by older releases.
+	binaryVersion = 2
+
+	// binaryHeader is the length of the fixed part of the layout: magic,
+	// version, flags, four integers, two times and the length of the key.
+	binaryHeader = 3 + 4*8 + 2*12 + 4
+)
+
+// Flags of the layout.
+const (
+	flagReal = 1 << iota
+	flagPathIsKey
+	flagZeroCreatedAt
+	flagZeroLastUsed
+	flagMetadata
+)
+
+var (
+	// ErrBadBinary raises when a binary cache item is truncated or malformed.
+	ErrBadBinary = errors.New("malformed binary cache item")
+
+	// ErrBinaryVersion raises when a binary cache item is written by a newer
+	// version of the layout.
+	ErrBinaryVersion = errors.New("unsupported version of binary cache item")
+)
+
+// Binary implements codec interface. It encodes cache.Item without
+// reflection in a versioned, fixed layout:
+//
+//	magic | version | flags | ID | Size | Ref | Used |
+//	CreatedAt (seconds, nanoseconds) | LastUsed (seconds, nanoseconds) |
+//	len(Key) | Key | [len(Path) | Path] |
+//	[len(Metadata) | (len(key) | key | len(value) | value)...]
+//
+// Integers are big-endian, Path is omitted if it equals to Key, and Metadata
+// is omitted if it is empty, or sorted by keys otherwise. Times
+// keep nanoseconds, and are decoded in the local time zone. Decoding
+// allocates only the strings of Key and Path and the metadata, while Key and
+// Path of the cache item decoded into are kept if they are unchanged. Other
+// types, and data not written by Binary, are handled by Fallback, or Gob if
+// it is nil.
+type Binary struct {
+	Fallback Codec
+}
+
+// Marshal marshals a cache item into the binary layout, or the input
+// interface by the fallback codec.
+func (c Binary) Marshal(v interface{}) (b []byte, e error) {
+	switch item := v.(type) {
+	case cache.Item:
+		return appendItem(nil, &item), nil
+	case *cache.Item:
+		if item != nil {
+			return appendItem(nil, item), nil
+		}
+	}
+	return c.fallback().Marshal(v)
+}
+
+// Unmarshal unmarshals binaries in the binary layout into a cache item, or
+// into the given interface by the fallback codec.
+func (c Binary) Unmarshal(b []byte, v interface{}) error {
+	if item, ok := v.(*cache.Item); ok && len(b) > 0 && b[0] == binaryMagic {
+		return decodeItem(b, item)
+	}
+	return c.fallback().Unmarshal(b, v)
+}
+
+func (c Binary) fallback() Codec {
+	if c.Fallback == nil {
+		return Gob{}
+	}
+	return c.Fallback
+}
+
+func appendItem(b []byte, item *cache.Item) []byte {
+	var flags byte
+	if item.Real {
+		flags |= flagReal
+	}
+	if item.Path == item.Key {
+		flags |= flagPathIsKey
+	}
+	if item.CreatedAt.IsZero() {
+		flags |= flagZeroCreatedAt
+	}
+	if item.LastUsed.IsZero() {
+		flags |= flagZeroLastUsed
+	}
+	version := byte(1)
+	if len(item.Metadata) > 0 {
+		flags |= flagMetadata
+		version = binaryVersion
+	}
+
+	n := binaryHeader + len(item.Key)
+	if flags&flagPathIsKey == 0 {
+		n += 4 + len(item.Path)
+	}
+	if flags&flagMetadata != 0 {
+		n += 4
+		for k, v := range item.Metadata {
+			n += 8 + len(k) + len(v)
+		}
+	}
+	if cap(b)-len(b) < n {
+		nb := make([]byte, len(b), len(b)+n)
+		copy(nb, b)
+		b = nb
+	}
+
+	b = append(b, binaryMagic, version, flags)
+	b = appendUint64(b, uint64(item.ID))
+	b = appendUint64(b, uint64(item.Size))
+	b = appendUint64(b, uint64(item.Ref))
+	b = appendUint64(b, uint64(item.Used))
+	b = appendTime(b, item.CreatedAt)
+	b = appendTime(b, item.LastUsed)
+	b = appendString(b, item.Key)
+	if flags&flagPathIsKey == 0 {
+		b = appendString(b, item.Path)
+	}
+	if flags&flagMetadata != 0 {
+		b = appendMetadata(b, item.Metadata)
+	}
+	return b
+}
+
+// appendMetadata appends metadata sorted by keys, so equal metadata is
+// always encoded into the same bytes.
+func appendMetadata(b []byte, md cache.Metadata) []byte {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = appendUint32(b, uint32(len(keys)))
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, md[k])
+	}
+	return b
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendTime appends a time as seconds and nanoseconds since the epoch.
+// Zero times are flagged instead, since they are out of the range.
+func appendTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return append(b, make([]byte, 12)...)
+	}
+	b = appendUint64(b, uint64(t.Unix()))
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func decodeItem(b []byte, item *cache.Item) error {
+	if len(b) < binaryHeader {
+		return ErrBadBinary
+	}
+	if b[1] == 0 || b[1] > binaryVersion {
+		return errors.Wrapf(ErrBinaryVersion, "version %d", b[1])
+	}
+	flags := b[2]
+	if flags&flagMetadata != 0 && b[1] < 2 {
+		return ErrBadBinary
+	}
+	be := binary.BigEndian
+	item.ID = int64(be.Uint64(b[3:]))
+	item.Size = int64(be.Uint64(b[11:]))
+	item.Ref = int(int64(be.Uint64(b[19:])))
+	item.Used = int(int64(be.Uint64(b[27:])))
+	item.CreatedAt = decodeTime(b[35:], flags&flagZeroCreatedAt != 0)
+	item.LastUsed = decodeTime(b[47:], flags&flagZeroLastUsed != 0)
+	item.Real = flags&flagReal != 0
+
+	rest, ok := decodeString(b[59:], &item.Key)
+	if !ok {
+		return ErrBadBinary
+	}
+	if flags&flagPathIsKey != 0 {
+		item.Path = item.Key
+	} else if rest, ok = decodeString(rest, &item.Path); !ok {
+		return ErrBadBinary
+	}
+	item.Metadata = nil
+	if flags&flagMetadata != 0 {
+		if rest, ok = decodeMetadata(rest, &item.Metadata); !ok {
+			return ErrBadBinary
+		}
+	}
+	if len(rest) != 0 {
+		return ErrBadBinary
+	}
+	return nil
+}
+
+func decodeTime(b []byte, zero bool) time.Time {
+	if zero {
+		return time.Time{}
+	}
+	be := binary.BigEndian
+	return time.Unix(int64(be.Uint64(b)), int64(be.Uint32(b[8:])))
+}
+
+// decodeString decodes a length-prefixed string into s, which is kept
+// without allocating if it is unchanged. It returns the remaining bytes.
+func decodeString(b []byte, s *string) ([]byte, bool) {
+	if len(b) < 4 {
+		return nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint64(n) > uint64(len(b)) {
+		return nil, false
+	}
+	if *s != string(b[:n]) {
+		*s = string(b[:n])
+	}
+	return b[n:], true
+}
+
+// decodeMetadata decodes metadata into md. It returns the remaining bytes.
+func decodeMetadata(b []byte, md *cache.Metadata) ([]byte, bool) {
+	if len(b) < 4 {
+		return nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	// Every entry takes at least 8 bytes, which bounds the allocation.
+	if uint64(n)*8 > uint64(len(b)) {
+		return nil, false
+	}
+	*md = make(cache.Metadata, n)
+	for i := uint32(0); i < n; i++ {
+		var k, v string
+		var ok bool
+		if b, ok = decodeString(b, &k); !ok {
+			return nil, false
+		}
+		if b, ok = decodeString(b, &v); !ok {
+			return nil, false
+		}
+		(*md)[k] = v
+	}
+	return b, true
+}
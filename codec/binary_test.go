@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+func TestBinary(t *testing.T) {
+	bin := Binary{}
+	t1 := T{100, "123", []byte("456")}
+	data, err := bin.Marshal(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var t2 T
+	err = bin.Unmarshal(data, &t2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(t1, t2) {
+		t.Errorf("expect %v, but get %v", t1, t2)
+	}
+}
+
+func TestBinaryItem(t *testing.T) {
+	testItemCodec(t, Binary{})
+
+	// Path is kept if it differs from Key, and pointers are encoded alike.
+	item := testItems()[0]
+	item.Path = "/var/cache/real"
+	data, err := Binary{}.Marshal(&item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got cache.Item
+	if err := (Binary{}).Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(item, got) {
+		t.Errorf("expect %+v, but get %+v", item, got)
+	}
+
+	// Records written by the fallback codec are still decodable.
+	data, _ = JSON{}.Marshal(item)
+	got = cache.Item{}
+	if err := (Binary{Fallback: JSON{}}).Unmarshal(data, &got); err != nil || !cmp.Equal(item, got) {
+		t.Errorf("expect %+v, but get %+v, %v", item, got, err)
+	}
+}
+
+func TestBinaryMalformed(t *testing.T) {
+	data, _ := Binary{}.Marshal(cache.New(1, "key", 1))
+	newer := append([]byte{}, data...)
+	newer[1] = binaryVersion + 1
+	flagged := append([]byte{}, data...)
+	flagged[2] |= flagMetadata
+
+	labeled := cache.New(1, "key", 1)
+	labeled.Metadata = cache.Metadata{"k": "v"}
+	meta, _ := Binary{}.Marshal(labeled)
+	huge := append([]byte{}, meta...)
+	huge[len(huge)-14] = 0xff
+
+	testcases := []struct {
+		description string
+		data        []byte
+		expect      error
+	}{
+		{"truncated header", data[:binaryHeader-1], ErrBadBinary},
+		{"truncated key", data[:len(data)-1], ErrBadBinary},
+		{"trailing bytes", append(append([]byte{}, data...), 0), ErrBadBinary},
+		{"newer version", newer, ErrBinaryVersion},
+		{"metadata in version 1", flagged, ErrBadBinary},
+		{"truncated metadata", meta[:len(meta)-1], ErrBadBinary},
+		{"too many metadata entries", huge, ErrBadBinary},
+	}
+
+	for idx, tc := range testcases {
+		var item cache.Item
+		err := Binary{}.Unmarshal(tc.data, &item)
+		if errors.Cause(err) != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expect, err)
+		}
+	}
+}
+
+func TestBinaryVersion(t *testing.T) {
+	items := testItems()
+	testcases := []struct {
+		item    cache.Item
+		version byte
+	}{
+		{items[0], 1},
+		{items[3], 2},
+	}
+
+	for idx, tc := range testcases {
+		data, err := Binary{}.Marshal(tc.item)
+		if err != nil {
+			t.Fatalf("[#Case%d]: %v", idx, err)
+		}
+		if data[1] != tc.version {
+			t.Errorf("[#Case%d]: expect version %d, but get %d", idx, tc.version, data[1])
+		}
+	}
+}
+
+func TestBinaryUnchangedStrings(t *testing.T) {
+	data, _ := Binary{}.Marshal(testItems()[0])
+	var item cache.Item
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := (Binary{}).Unmarshal(data, &item); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expect unchanged strings kept without allocation, but get %v allocations", allocs)
+	}
+}
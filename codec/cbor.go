@@ -0,0 +1,34 @@
+package codec
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborEnc encodes time.Time as a tagged RFC 3339 string, which keeps both
+// nanoseconds and the offset of the time zone.
+var cborEnc = func() cbor.EncMode {
+	em, err := cbor.EncOptions{
+		Time:    cbor.TimeRFC3339Nano,
+		TimeTag: cbor.EncTagRequired,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
+// CBOR implements codec interface. Structs are encoded as maps keyed by
+// field names, so records stay decodable after fields are added. time.Time
+// is encoded at nanosecond precision along with its offset.
+type CBOR struct{}
+
+// Marshal marshals the input interface into a byte array in
+// CBOR format.
+func (c CBOR) Marshal(v interface{}) (b []byte, e error) {
+	return cborEnc.Marshal(v)
+}
+
+// Unmarshal unmarshals CBOR binaries into the given interface.
+func (c CBOR) Unmarshal(b []byte, v interface{}) error {
+	return cbor.Unmarshal(b, v)
+}
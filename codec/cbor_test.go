@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/meowdada/go-fcache/cache"
+)
+
+func TestCBOR(t *testing.T) {
+	cbor := CBOR{}
+	t1 := T{100, "123", []byte("456")}
+	data, err := cbor.Marshal(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var t2 T
+	err = cbor.Unmarshal(data, &t2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(t1, t2) {
+		t.Errorf("expect %v, but get %v", t1, t2)
+	}
+}
+
+func TestCBORItem(t *testing.T) {
+	testItemCodec(t, CBOR{})
+
+	// The offset of the time zone is kept as well.
+	item := testItems()[0]
+	data, _ := CBOR{}.Marshal(item)
+	var got cache.Item
+	CBOR{}.Unmarshal(data, &got)
+	if _, offset := got.CreatedAt.Zone(); offset != 8*3600 {
+		t.Errorf("expect offset %d, but get %d", 8*3600, offset)
+	}
+}
@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/pkg/errors"
+)
+
+// castagnoli is the CRC-32C table, which is hardware accelerated on most
+// platforms.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError raises when a record does not match its checksum, which
+// means it is corrupted.
+type ChecksumError struct {
+	// Expect is the checksum stored in the record, and Actual is the one
+	// computed from its content.
+	Expect uint32
+	Actual uint32
+
+	// Short is true if the record is too short to hold a checksum.
+	Short bool
+}
+
+// Error implements error interface.
+func (e *ChecksumError) Error() string {
+	if e.Short {
+		return "record is too short to hold a checksum"
+	}
+	return fmt.Sprintf("checksum mismatch: expect %08x, but get %08x", e.Expect, e.Actual)
+}
+
+// IsChecksumError returns true if the cause of the error is a ChecksumError.
+func IsChecksumError(err error) bool {
+	_, ok := errors.Cause(err).(*ChecksumError)
+	return ok
+}
+
+// Checksummed returns a codec which appends a CRC-32C checksum to records
+// encoded by the inner codec, and verifies it before decoding, so corrupted
+// records are reported as ChecksumError instead of decoded into garbage.
+func Checksummed(inner Codec) Codec {
+	return checksummed{inner}
+}
+
+type checksummed struct {
+	inner Codec
+}
+
+func (c checksummed) Marshal(v interface{}) (b []byte, e error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, castagnoli))
+	return append(data, sum[:]...), nil
+}
+
+func (c checksummed) Unmarshal(b []byte, v interface{}) error {
+	if len(b) < 4 {
+		return &ChecksumError{Short: true}
+	}
+	data := b[:len(b)-4]
+	expect := binary.BigEndian.Uint32(b[len(b)-4:])
+	if actual := crc32.Checksum(data, castagnoli); actual != expect {
+		return &ChecksumError{Expect: expect, Actual: actual}
+	}
+	return c.inner.Unmarshal(data, v)
+}
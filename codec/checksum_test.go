@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+func TestChecksummed(t *testing.T) {
+	testItemCodec(t, Checksummed(Binary{}))
+	testItemCodec(t, Checksummed(Compressed(Gob{}, CompressSnappy)))
+
+	c := Checksummed(Gob{})
+	data, err := c.Marshal(cache.New(1, "key", 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 0x01
+
+	testcases := []struct {
+		description string
+		data        []byte
+		expectShort bool
+	}{
+		{"flipped bit", flipped, false},
+		{"truncated record", data[:len(data)-1], false},
+		{"too short", data[:3], true},
+	}
+
+	for idx, tc := range testcases {
+		var item cache.Item
+		err := c.Unmarshal(tc.data, &item)
+		if !IsChecksumError(err) {
+			t.Errorf("[#Case%d] %s: expect a checksum error, but get %v", idx, tc.description, err)
+			continue
+		}
+		if !IsChecksumError(errors.Wrap(err, "key")) {
+			t.Errorf("[#Case%d] %s: expect a wrapped checksum error, but get %v", idx, tc.description, err)
+		}
+		if short := err.(*ChecksumError).Short; short != tc.expectShort {
+			t.Errorf("[#Case%d] %s: expect short %v, but get %v", idx, tc.description, tc.expectShort, short)
+		}
+	}
+}
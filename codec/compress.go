@@ -0,0 +1,170 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+)
+
+// Algorithm is a compression algorithm of Compressed.
+type Algorithm byte
+
+// Compression algorithms.
+const (
+	// CompressNone stores records as is.
+	CompressNone Algorithm = iota
+	CompressGzip
+	CompressFlate
+	CompressZlib
+	CompressSnappy
+)
+
+// MaxDecompressedSize is the maximum size of a decompressed record, so a
+// corrupted or malicious record could not exhaust the memory.
+const MaxDecompressedSize = 16 << 20
+
+var (
+	// ErrUnknownAlgorithm raises when a record is compressed by an unknown
+	// algorithm.
+	ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+
+	// ErrDecompressedTooLarge raises when a record is larger than
+	// MaxDecompressedSize after decompressed.
+	ErrDecompressedTooLarge = errors.New("decompressed record is too large")
+)
+
+// Compressed returns a codec which compresses records encoded by the inner
+// codec with the algorithm. A record is prefixed with its algorithm, so the
+// algorithm could be changed without rewriting existing records. Records
+// which do not shrink by compression, which is common for small ones, are
+// stored as is.
+func Compressed(inner Codec, algo Algorithm) Codec {
+	return compressed{inner: inner, algo: algo}
+}
+
+type compressed struct {
+	inner Codec
+	algo  Algorithm
+}
+
+func (c compressed) Marshal(v interface{}) (b []byte, e error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.algo != CompressNone {
+		z, err := compress(c.algo, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(z) < len(data) {
+			return append([]byte{byte(c.algo)}, z...), nil
+		}
+	}
+	return append([]byte{byte(CompressNone)}, data...), nil
+}
+
+func (c compressed) Unmarshal(b []byte, v interface{}) error {
+	if len(b) == 0 {
+		return errors.Wrap(ErrUnknownAlgorithm, "empty record")
+	}
+	data, err := decompress(Algorithm(b[0]), b[1:])
+	if err != nil {
+		return err
+	}
+	return c.inner.Unmarshal(data, v)
+}
+
+// Writers of flate-based algorithms are pooled, since they are expensive to
+// create.
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(nil) }}
+)
+
+// resetWriter is a compressing writer which could be reused.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func compress(algo Algorithm, data []byte) ([]byte, error) {
+	var pool *sync.Pool
+	switch algo {
+	case CompressSnappy:
+		return snappy.Encode(nil, data), nil
+	case CompressGzip:
+		pool = &gzipWriters
+	case CompressFlate:
+		pool = &flateWriters
+	case CompressZlib:
+		pool = &zlibWriters
+	default:
+		return nil, errors.Wrapf(ErrUnknownAlgorithm, "algorithm %d", algo)
+	}
+
+	var buf bytes.Buffer
+	w := pool.Get().(resetWriter)
+	defer pool.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(algo Algorithm, data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch algo {
+	case CompressNone:
+		return data, nil
+	case CompressSnappy:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > MaxDecompressedSize {
+			return nil, errors.Wrapf(ErrDecompressedTooLarge, "%d bytes", n)
+		}
+		return snappy.Decode(nil, data)
+	case CompressGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case CompressFlate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case CompressZlib:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return nil, errors.Wrapf(ErrUnknownAlgorithm, "algorithm %d", algo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one more byte to tell whether the limit is exceeded.
+	out, err := ioutil.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxDecompressedSize {
+		return nil, errors.Wrapf(ErrDecompressedTooLarge, "more than %d bytes", MaxDecompressedSize)
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+func TestCompressed(t *testing.T) {
+	testcases := []struct {
+		description string
+		algo        Algorithm
+	}{
+		{"none", CompressNone},
+		{"gzip", CompressGzip},
+		{"flate", CompressFlate},
+		{"zlib", CompressZlib},
+		{"snappy", CompressSnappy},
+	}
+
+	// A long path which is compressible.
+	item := cache.New(1, strings.Repeat("/var/cache/", 20), 10)
+	raw, _ := JSON{}.Marshal(item)
+	for idx, tc := range testcases {
+		c := Compressed(JSON{}, tc.algo)
+		data, err := c.Marshal(item)
+		if err != nil {
+			t.Fatalf("[#Case%d] %s: %v", idx, tc.description, err)
+		}
+		if Algorithm(data[0]) != tc.algo {
+			t.Errorf("[#Case%d] %s: expect algorithm %d, but get %d", idx, tc.description, tc.algo, data[0])
+		}
+		if tc.algo != CompressNone && len(data) >= len(raw) {
+			t.Errorf("[#Case%d] %s: expect less than %d bytes, but get %d", idx, tc.description, len(raw), len(data))
+		}
+
+		// Records of any algorithm are decodable regardless of the one
+		// of the codec.
+		var got cache.Item
+		if err := Compressed(JSON{}, CompressSnappy).Unmarshal(data, &got); err != nil {
+			t.Fatalf("[#Case%d] %s: %v", idx, tc.description, err)
+		}
+		if !cmp.Equal(item, got) {
+			t.Errorf("[#Case%d] %s: expect %+v, but get %+v", idx, tc.description, item, got)
+		}
+	}
+
+	testItemCodec(t, Compressed(Binary{}, CompressGzip))
+}
+
+func TestCompressedIncompressible(t *testing.T) {
+	data, err := Compressed(Binary{}, CompressGzip).Marshal(cache.Dummy(1, "k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Algorithm(data[0]) != CompressNone {
+		t.Errorf("expect a small record stored as is, but get algorithm %d", data[0])
+	}
+}
+
+func TestCompressedMalformed(t *testing.T) {
+	testcases := []struct {
+		description string
+		data        []byte
+		expectErr   error
+	}{
+		{"empty record", nil, ErrUnknownAlgorithm},
+		{"unknown algorithm", []byte{100, 1, 2}, ErrUnknownAlgorithm},
+	}
+
+	for idx, tc := range testcases {
+		var item cache.Item
+		err := Compressed(Gob{}, CompressGzip).Unmarshal(tc.data, &item)
+		if errors.Cause(err) != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+	}
+
+	for _, algo := range []Algorithm{CompressGzip, CompressFlate, CompressZlib, CompressSnappy} {
+		var item cache.Item
+		if err := Compressed(Gob{}, algo).Unmarshal([]byte{byte(algo), 1, 2, 3}, &item); err == nil {
+			t.Errorf("expect error occurs decoding garbage of algorithm %d, but get no error", algo)
+		}
+	}
+	if _, err := Compressed(Gob{}, 100).Marshal(T{}); errors.Cause(err) != ErrUnknownAlgorithm {
+		t.Errorf("expect %v, but get %v", ErrUnknownAlgorithm, err)
+	}
+}
+
+func TestCompressedTooLarge(t *testing.T) {
+	raw := make([]byte, MaxDecompressedSize+1)
+	for _, algo := range []Algorithm{CompressGzip, CompressFlate, CompressZlib, CompressSnappy} {
+		z, err := compress(algo, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var item cache.Item
+		err = Compressed(Gob{}, algo).Unmarshal(append([]byte{byte(algo)}, z...), &item)
+		if errors.Cause(err) != ErrDecompressedTooLarge {
+			t.Errorf("expect %v for algorithm %d, but get %v", ErrDecompressedTooLarge, algo, err)
+		}
+	}
+}
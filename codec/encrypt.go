@@ -0,0 +1,205 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// encryptedVersion is the version of the layout written by Encrypted.
+const encryptedVersion = 1
+
+// encryptedHeader is the length of version and key ID.
+const encryptedHeader = 1 + 4
+
+var (
+	// ErrNoKey raises when a key provider has no current key.
+	ErrNoKey = errors.New("no encryption key")
+
+	// ErrUnknownKey raises when a record is encrypted with a key which the
+	// key provider does not know.
+	ErrUnknownKey = errors.New("unknown encryption key")
+
+	// ErrDecrypt raises when a record is unable to be decrypted, which means
+	// it is corrupted, tampered or encrypted with another key of the same ID.
+	ErrDecrypt = errors.New("failed to decrypt record")
+)
+
+// KeyProvider provides AES keys of 16, 24 or 32 bytes to Encrypted, each of
+// which is identified by an ID.
+type KeyProvider interface {
+	// Current returns the key which new records are encrypted with.
+	Current() (id uint32, key []byte, err error)
+
+	// Key returns the key of the ID, which records were encrypted with.
+	Key(id uint32) ([]byte, error)
+}
+
+// Keyring implements KeyProvider with keys in memory. It is safe for
+// concurrent use, so keys could be rotated while it is being used.
+type Keyring struct {
+	keys    map[uint32][]byte
+	current uint32
+	set     bool
+	mu      sync.RWMutex
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32][]byte)}
+}
+
+// Add adds a key for decrypting records encrypted with it.
+func (r *Keyring) Add(id uint32, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.keys[id] = append([]byte{}, key...)
+	r.mu.Unlock()
+	return nil
+}
+
+// Rotate adds a key and makes it current, so new records are encrypted
+// with it while old ones are still decrypted with their own keys.
+func (r *Keyring) Rotate(id uint32, key []byte) error {
+	if err := r.Add(id, key); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.current, r.set = id, true
+	r.mu.Unlock()
+	return nil
+}
+
+// Current returns the key added by the last Rotate.
+func (r *Keyring) Current() (uint32, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.set {
+		return 0, nil, ErrNoKey
+	}
+	return r.current, r.keys[r.current], nil
+}
+
+// Key returns the key of the ID.
+func (r *Keyring) Key(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %d", id)
+	}
+	return key, nil
+}
+
+// Encrypted returns a codec which encrypts records encoded by the inner codec
+// with AES-GCM, in the layout:
+//
+//	version | key ID (big-endian uint32) | nonce | ciphertext and tag
+//
+// Version and key ID are authenticated as well. Records are encrypted with
+// the current key of the provider, and decrypted with the key of their ID,
+// so after the key is rotated, records are re-encrypted with the new key
+// when they are written again. Nonces are random, so a key should be
+// rotated before it encrypts billions of records.
+//
+// Only values are encrypted. Stores still index records by their cache keys,
+// which are usually paths to cached files, so keys remain visible in plain
+// text to anyone able to read the store.
+func Encrypted(inner Codec, keys KeyProvider) Codec {
+	return &encrypted{inner: inner, keys: keys, aeads: make(map[uint32]aeadEntry)}
+}
+
+type encrypted struct {
+	inner Codec
+	keys  KeyProvider
+
+	// aeads caches ciphers by key IDs, along with their keys so a changed
+	// key is noticed.
+	aeads map[uint32]aeadEntry
+	mu    sync.Mutex
+}
+
+type aeadEntry struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func (c *encrypted) Marshal(v interface{}) (b []byte, e error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	id, key, err := c.keys.Current()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	n := encryptedHeader + aead.NonceSize()
+	b = make([]byte, n, n+len(data)+aead.Overhead())
+	b[0] = encryptedVersion
+	binary.BigEndian.PutUint32(b[1:], id)
+	nonce := b[encryptedHeader:n]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(b, nonce, data, b[:encryptedHeader]), nil
+}
+
+func (c *encrypted) Unmarshal(b []byte, v interface{}) error {
+	if len(b) < encryptedHeader {
+		return errors.Wrap(ErrDecrypt, "truncated header")
+	}
+	if b[0] != encryptedVersion {
+		return errors.Wrapf(ErrDecrypt, "unsupported version %d", b[0])
+	}
+	id := binary.BigEndian.Uint32(b[1:])
+	key, err := c.keys.Key(id)
+	if err != nil {
+		return err
+	}
+	aead, err := c.aead(id, key)
+	if err != nil {
+		return err
+	}
+
+	n := encryptedHeader + aead.NonceSize()
+	if len(b) < n+aead.Overhead() {
+		return errors.Wrap(ErrDecrypt, "truncated record")
+	}
+	data, err := aead.Open(nil, b[encryptedHeader:n], b[n:], b[:encryptedHeader])
+	if err != nil {
+		return errors.Wrapf(ErrDecrypt, "key %d", id)
+	}
+	return c.inner.Unmarshal(data, v)
+}
+
+// aead returns the cipher of a key, which is cached by its ID.
+func (c *encrypted) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.aeads[id]; ok && bytes.Equal(e.key, key) {
+		return e.aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[id] = aeadEntry{key: append([]byte{}, key...), aead: aead}
+	return aead, nil
+}
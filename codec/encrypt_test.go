@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+func testKeyring(t *testing.T, id uint32) *Keyring {
+	keys := NewKeyring()
+	if err := keys.Rotate(id, bytes.Repeat([]byte{byte(id)}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestEncrypted(t *testing.T) {
+	c := Encrypted(Gob{}, testKeyring(t, 1))
+	testItemCodec(t, c)
+
+	// Keys and paths inside records are not revealed, and nonces differ
+	// every time.
+	item := cache.New(1, "customer-42", 10)
+	d1, _ := c.Marshal(item)
+	d2, _ := c.Marshal(item)
+	if bytes.Contains(d1, []byte("customer-42")) {
+		t.Errorf("expect the key encrypted, but get %q", d1)
+	}
+	if bytes.Equal(d1, d2) {
+		t.Errorf("expect different ciphertexts, but get the same")
+	}
+}
+
+func TestEncryptedRotate(t *testing.T) {
+	keys := testKeyring(t, 1)
+	c := Encrypted(JSON{}, keys)
+	item := cache.New(1, "key", 10)
+	old, err := c.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.Rotate(2, bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	rewritten, err := c.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		description string
+		data        []byte
+		expectID    byte
+	}{
+		{"encrypted with the old key", old, 1},
+		{"re-encrypted with the new key", rewritten, 2},
+	}
+
+	for idx, tc := range testcases {
+		if tc.data[4] != tc.expectID {
+			t.Errorf("[#Case%d] %s: expect key %d, but get %d", idx, tc.description, tc.expectID, tc.data[4])
+		}
+		var got cache.Item
+		if err := c.Unmarshal(tc.data, &got); err != nil || !cmp.Equal(item, got) {
+			t.Errorf("[#Case%d] %s: expect %+v, but get %+v, %v", idx, tc.description, item, got, err)
+		}
+	}
+}
+
+func TestEncryptedMalformed(t *testing.T) {
+	c := Encrypted(Gob{}, testKeyring(t, 1))
+	data, _ := c.Marshal(cache.New(1, "key", 10))
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 0x01
+	otherID := append([]byte{}, data...)
+	otherID[4] = 3
+	newer := append([]byte{}, data...)
+	newer[0] = encryptedVersion + 1
+
+	testcases := []struct {
+		description string
+		codec       Codec
+		data        []byte
+		expectErr   error
+	}{
+		{"tampered record", c, tampered, ErrDecrypt},
+		{"truncated header", c, data[:3], ErrDecrypt},
+		{"truncated record", c, data[:20], ErrDecrypt},
+		{"newer version", c, newer, ErrDecrypt},
+		{"unknown key", c, otherID, ErrUnknownKey},
+		{"same key of another codec", Encrypted(Gob{}, testKeyring(t, 1)), data, nil},
+	}
+
+	for idx, tc := range testcases {
+		var item cache.Item
+		err := tc.codec.Unmarshal(tc.data, &item)
+		if errors.Cause(err) != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+	}
+
+	// Another key of the same ID fails to authenticate.
+	keys := NewKeyring()
+	keys.Rotate(1, bytes.Repeat([]byte{9}, 32))
+	var item cache.Item
+	if err := Encrypted(Gob{}, keys).Unmarshal(data, &item); errors.Cause(err) != ErrDecrypt {
+		t.Errorf("expect %v, but get %v", ErrDecrypt, err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	keys := NewKeyring()
+	if _, _, err := keys.Current(); err != ErrNoKey {
+		t.Errorf("expect %v, but get %v", ErrNoKey, err)
+	}
+	if _, err := Encrypted(Gob{}, keys).Marshal(T{}); err != ErrNoKey {
+		t.Errorf("expect %v, but get %v", ErrNoKey, err)
+	}
+	if err := keys.Add(1, []byte("short")); err == nil {
+		t.Errorf("expect error occurs for a bad key size, but get no error")
+	}
+	if err := keys.Rotate(1, []byte("short")); err == nil {
+		t.Errorf("expect error occurs for a bad key size, but get no error")
+	}
+	if _, err := keys.Key(1); errors.Cause(err) != ErrUnknownKey {
+		t.Errorf("expect %v, but get %v", ErrUnknownKey, err)
+	}
+}
@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Format identifies the codec of a record in an envelope. Formats below 128
+// are reserved for built-in codecs, so custom codecs should use the others.
+type Format byte
+
+// Formats of built-in codecs.
+const (
+	FormatGob Format = 1 + iota
+	FormatJSON
+	FormatXML
+	FormatMessagePack
+	FormatCBOR
+	FormatBinary
+)
+
+// envelopeMagic prefixes every record in an envelope. A leading zero byte
+// is never written by the built-in codecs, so records in envelopes are told
+// apart from the ones written without.
+const envelopeMagic = "\x00fc"
+
+// envelopeHeader is the length of magic, format and schema version.
+const envelopeHeader = len(envelopeMagic) + 1 + 2
+
+var (
+	// ErrUnknownFormat raises when a record is encoded by a codec which is
+	// not registered to the envelope.
+	ErrUnknownFormat = errors.New("unknown codec format")
+
+	// ErrNewerSchema raises when a record is written with a schema version
+	// newer than the one of the envelope, which is written by a newer release.
+	ErrNewerSchema = errors.New("newer schema version")
+
+	// ErrNoEnvelope raises when a record is not in an envelope and no legacy
+	// codec is set.
+	ErrNoEnvelope = errors.New("record is not in an envelope")
+)
+
+// UpgradeFunc upgrades a decoded value from a schema version to the next one,
+// such as filling in a field added by the next version.
+type UpgradeFunc func(v interface{}) error
+
+// Envelope implements codec interface. It prefixes records with a format
+// identifying the codec and a schema version:
+//
+//	"\x00fc" | format | schema version (big-endian uint16) | payload
+//
+// On decoding, it dispatches a record to the codec of its format, so records
+// written by different codecs could coexist, and upgrades it by upgrade
+// functions from its schema version to the one of the envelope. All the
+// built-in codecs are registered by default. An envelope must be configured
+// before it is used.
+type Envelope struct {
+	format   Format
+	version  uint16
+	codecs   map[Format]Codec
+	upgrades map[uint16]UpgradeFunc
+	legacy   Codec
+}
+
+// NewEnvelope creates an envelope which encodes records with the codec as
+// the format, and labels them with the schema version.
+func NewEnvelope(format Format, c Codec, version uint16) *Envelope {
+	e := &Envelope{
+		format:  format,
+		version: version,
+		codecs: map[Format]Codec{
+			FormatGob:         Gob{},
+			FormatJSON:        JSON{},
+			FormatXML:         XML{},
+			FormatMessagePack: MessagePack{},
+			FormatCBOR:        CBOR{},
+			FormatBinary:      Binary{},
+		},
+		upgrades: make(map[uint16]UpgradeFunc),
+	}
+	e.codecs[format] = c
+	return e
+}
+
+// Register registers a codec decoding records of the format.
+func (e *Envelope) Register(format Format, c Codec) *Envelope {
+	e.codecs[format] = c
+	return e
+}
+
+// Upgrade registers a function upgrading records of the schema version from
+// to the next one. Records are upgraded step by step until they reach the
+// schema version of the envelope, and steps without functions are skipped.
+func (e *Envelope) Upgrade(from uint16, fn UpgradeFunc) *Envelope {
+	e.upgrades[from] = fn
+	return e
+}
+
+// Legacy makes records written without an envelope decoded by the codec, as
+// the schema version 0, so a store could be switched to envelopes in place.
+func (e *Envelope) Legacy(c Codec) *Envelope {
+	e.legacy = c
+	return e
+}
+
+// Marshal marshals the input interface by the codec of the envelope, and
+// prefixes it with the header.
+func (e *Envelope) Marshal(v interface{}) (b []byte, err error) {
+	payload, err := e.codecs[e.format].Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b = make([]byte, envelopeHeader, envelopeHeader+len(payload))
+	copy(b, envelopeMagic)
+	b[len(envelopeMagic)] = byte(e.format)
+	binary.BigEndian.PutUint16(b[len(envelopeMagic)+1:], e.version)
+	return append(b, payload...), nil
+}
+
+// Unmarshal unmarshals a record by the codec of its format into the given
+// interface, and upgrades it to the schema version of the envelope.
+func (e *Envelope) Unmarshal(b []byte, v interface{}) error {
+	var (
+		c       = e.legacy
+		version uint16
+	)
+	if bytes.HasPrefix(b, []byte(envelopeMagic)) {
+		if len(b) < envelopeHeader {
+			return errors.Wrap(ErrNoEnvelope, "truncated header")
+		}
+		format := Format(b[len(envelopeMagic)])
+		if c = e.codecs[format]; c == nil {
+			return errors.Wrapf(ErrUnknownFormat, "format %d", format)
+		}
+		version = binary.BigEndian.Uint16(b[len(envelopeMagic)+1:])
+		b = b[envelopeHeader:]
+	} else if c == nil {
+		return ErrNoEnvelope
+	}
+	if version > e.version {
+		return errors.Wrapf(ErrNewerSchema, "version %d, expect at most %d", version, e.version)
+	}
+
+	if err := c.Unmarshal(b, v); err != nil {
+		return err
+	}
+	for ; version < e.version; version++ {
+		if fn := e.upgrades[version]; fn != nil {
+			if err := fn(v); err != nil {
+				return errors.Wrapf(err, "upgrade from version %d", version)
+			}
+		}
+	}
+	return nil
+}
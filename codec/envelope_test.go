@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+func TestEnvelope(t *testing.T) {
+	env := NewEnvelope(FormatMessagePack, MessagePack{}, 1)
+	t1 := T{100, "123", []byte("456")}
+	data, err := env.Marshal(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var t2 T
+	err = env.Unmarshal(data, &t2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(t1, t2) {
+		t.Errorf("expect %v, but get %v", t1, t2)
+	}
+}
+
+func TestEnvelopeItem(t *testing.T) {
+	testItemCodec(t, NewEnvelope(FormatBinary, Binary{}, 1))
+}
+
+func TestEnvelopeDispatch(t *testing.T) {
+	item := cache.New(1, "key", 10)
+	v1 := NewEnvelope(FormatGob, Gob{}, 1)
+	v2 := NewEnvelope(FormatJSON, JSON{}, 3).
+		Legacy(XML{}).
+		Upgrade(0, func(v interface{}) error {
+			v.(*cache.Item).Used += 1
+			return nil
+		}).
+		Upgrade(2, func(v interface{}) error {
+			v.(*cache.Item).Used += 10
+			return nil
+		})
+
+	encode := func(c Codec) []byte {
+		data, err := c.Marshal(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	custom := NewEnvelope(200, CBOR{}, 1)
+
+	testcases := []struct {
+		description string
+		codec       Codec
+		data        []byte
+		expectUsed  int
+		expectErr   error
+	}{
+		{"current version", v2, encode(v2), 0, nil},
+		{"older version of another codec", v2, encode(v1), 10, nil},
+		{"legacy record", v2, encode(XML{}), 11, nil},
+		{"no legacy codec", v1, encode(XML{}), 0, ErrNoEnvelope},
+		{"newer version", v1, encode(v2), 0, ErrNewerSchema},
+		{"unknown format", v2, encode(custom), 0, ErrUnknownFormat},
+		{"registered format", NewEnvelope(FormatJSON, JSON{}, 1).Register(200, CBOR{}), encode(custom), 0, nil},
+		{"truncated header", v2, []byte(envelopeMagic), 0, ErrNoEnvelope},
+	}
+
+	for idx, tc := range testcases {
+		var got cache.Item
+		err := tc.codec.Unmarshal(tc.data, &got)
+		if errors.Cause(err) != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+			continue
+		}
+		if err == nil && (got.Key != item.Key || got.Used != tc.expectUsed) {
+			t.Errorf("[#Case%d] %s: expect key %s used %d, but get %+v", idx, tc.description, item.Key, tc.expectUsed, got)
+		}
+	}
+}
+
+func TestEnvelopeUpgradeError(t *testing.T) {
+	env := NewEnvelope(FormatCBOR, CBOR{}, 3).Upgrade(1, func(v interface{}) error {
+		return errors.New("failed")
+	})
+	data, _ := NewEnvelope(FormatGob, Gob{}, 1).Marshal(cache.New(1, "key", 10))
+
+	var got cache.Item
+	err := env.Unmarshal(data, &got)
+	if err == nil || err.Error() != "upgrade from version 1: failed" {
+		t.Errorf("expect an upgrade error, but get %v", err)
+	}
+}
@@ -30,3 +30,7 @@ func TestGob(t *testing.T) {
 		t.Errorf("expect %v, but get %v", t1, t2)
 	}
 }
+
+func TestGobItem(t *testing.T) {
+	testItemCodec(t, Gob{})
+}
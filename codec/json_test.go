@@ -24,3 +24,7 @@ func TestJSON(t *testing.T) {
 		t.Errorf("expect %v, but get %v", t1, t2)
 	}
 }
+
+func TestJSONItem(t *testing.T) {
+	testItemCodec(t, JSON{})
+}
@@ -0,0 +1,33 @@
+package codec
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack implements codec interface. Structs are encoded as maps keyed
+// by field names, so records stay decodable after fields are added, and
+// integers are encoded in the fewest bytes. time.Time is encoded with the
+// timestamp extension type at nanosecond precision, and decoded in the local
+// time zone.
+type MessagePack struct{}
+
+// Marshal marshals the input interface into a byte array in
+// MessagePack format.
+func (m MessagePack) Marshal(v interface{}) (b []byte, e error) {
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+	enc.UseCompactInts(true)
+	if e = enc.Encode(v); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal unmarshals MessagePack binaries into the given interface.
+func (m MessagePack) Unmarshal(b []byte, v interface{}) error {
+	return msgpack.Unmarshal(b, v)
+}
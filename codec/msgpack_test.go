@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/meowdada/go-fcache/cache"
+)
+
+func TestMessagePack(t *testing.T) {
+	msgpack := MessagePack{}
+	t1 := T{100, "123", []byte("456")}
+	data, err := msgpack.Marshal(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var t2 T
+	err = msgpack.Unmarshal(data, &t2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(t1, t2) {
+		t.Errorf("expect %v, but get %v", t1, t2)
+	}
+}
+
+// testItems are cache items whose fields are all set, including times
+// with nanoseconds and metadata.
+func testItems() []cache.Item {
+	real := cache.New(1<<40, "/tmp/real", 1<<33)
+	real.Ref = 3
+	real.Used = -1
+	real.CreatedAt = time.Date(2020, 8, 15, 11, 6, 45, 123456789, time.FixedZone("UTC+8", 8*3600))
+	real.LastUsed = time.Now()
+
+	labeled := cache.New(3, "/tmp/labeled", 10)
+	labeled.Metadata = cache.Metadata{
+		cache.MetaContentType:  "text/plain; charset=utf-8",
+		cache.MetaSourceURL:    "https://example.com/a?b=c&d=<e>",
+		cache.MetaETag:         `"abc"`,
+		cache.MetaLastModified: "Sat, 15 Aug 2020 03:06:45 GMT",
+		"label":                "",
+	}
+	return []cache.Item{
+		real,
+		cache.Dummy(2, "dummy"),
+		{},
+		labeled,
+	}
+}
+
+// testItemCodec checks that every cache item round-trips through the codec.
+func testItemCodec(t *testing.T, c Codec) {
+	for idx, item := range testItems() {
+		data, err := c.Marshal(item)
+		if err != nil {
+			t.Fatalf("[#Case%d]: %v", idx, err)
+		}
+		var got cache.Item
+		if err := c.Unmarshal(data, &got); err != nil {
+			t.Fatalf("[#Case%d]: %v", idx, err)
+		}
+		// Times are compared with Equal, which ignores locations and
+		// monotonic clock readings.
+		if !cmp.Equal(item, got) {
+			t.Errorf("[#Case%d]: expect %+v, but get %+v", idx, item, got)
+		}
+		if got.CreatedAt.IsZero() != item.CreatedAt.IsZero() {
+			t.Errorf("[#Case%d]: expect zero time %v, but get %v", idx, item.CreatedAt.IsZero(), got.CreatedAt.IsZero())
+		}
+	}
+}
+
+func TestMessagePackItem(t *testing.T) {
+	testItemCodec(t, MessagePack{})
+}
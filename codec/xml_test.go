@@ -24,3 +24,7 @@ func TestXML(t *testing.T) {
 		t.Errorf("expect %v, but get %v", t1, t2)
 	}
 }
+
+func TestXMLItem(t *testing.T) {
+	testItemCodec(t, XML{})
+}
@@ -13,6 +13,20 @@ var ErrCacheTooLarge = errors.New("cache item is too large")
 // ErrCacheMiss raises when try getting an unexist record.
 var ErrCacheMiss = errors.New("cache miss")
 
+// ErrNoMetadata raises when try attaching metadata to cache items of a cache
+// pool which does not support metadata.
+var ErrNoMetadata = errors.New("cache pool does not support metadata")
+
+// ErrNoReplace raises when try replacing cache items of a cache pool which
+// does not support replacing.
+var ErrNoReplace = errors.New("cache pool does not support replacing cache items")
+
+// ErrNotCached raises when a revalidate handler reports not modified for a
+// cache item which is not cached.
+var ErrNotCached = errors.New("cache item is not cached")
+
 var errRetry = errors.New("keep retrying")
 
+var errStopScan = errors.New("stop scanning")
+
 var errMockErr = errors.New("mock error")
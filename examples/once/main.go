@@ -26,7 +26,7 @@ func main() {
 	// by calling Once.
 	onceHandler := func(
 		preconditionChecker func(item cache.Item) error,
-		putCacheFn func(path string, size int64) error,
+		putCacheFn func(path string, size int64, md cache.Metadata) error,
 		rollback func(path string) error,
 	) (item cache.Item, err error) {
 		// Create a psudo cache item first.
@@ -40,7 +40,7 @@ func main() {
 
 		// Put the psudo cache item into the cache manager first to ensure
 		// there is enough space to insert this cache.
-		err = putCacheFn("file1.tmp", 200)
+		err = putCacheFn("file1.tmp", 200, nil)
 		if err != nil {
 			return item, err
 		}
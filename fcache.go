@@ -3,39 +3,74 @@
 package fcache
 
 import (
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	retry "github.com/avast/retry-go"
 	"github.com/meowdada/go-fcache/backend"
 	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/log"
 	"github.com/meowdada/go-fcache/policy"
 )
 
 // OnceHandler is a handler for once method.
 type OnceHandler func(
 	preconditionCheck func(item cache.Item) error,
-	putCacheFn func(path string, size int64) error,
+	putCacheFn func(path string, size int64, md cache.Metadata) error,
 	rollback func(path string) error,
 ) (cache.Item, error)
 
 // Manager manages transactions of file caches.
 type Manager struct {
+	counters  counters
 	cap       int64
 	usage     int64
 	pool      cache.Pool
 	policy    policy.Policy
 	retryOpts []retry.Option
+	hooks     Hooks
+	logger    log.Logger
+	tally     tally
+	flights   flights
 	mu        sync.RWMutex
 }
 
 // New creates an instance of file cache manager.
 func New(opts Options) *Manager {
-	return &Manager{
+	logger := log.OrNop(opts.Logger)
+	adaOpts := []backend.Option{
+		backend.WithLogger(logger),
+		backend.WithCorruptStrategy(opts.CorruptStrategy),
+	}
+	if opts.Quarantine != nil {
+		adaOpts = append(adaOpts, backend.WithQuarantine(opts.Quarantine))
+	}
+	mgr := &Manager{
 		cap:       opts.Capacity,
-		pool:      backend.Adapter(opts.Backend, opts.Codec),
-		policy:    opts.CachePolicy,
+		policy:    policy.Logged(opts.CachePolicy, opts.Logger),
 		retryOpts: opts.RetryOptions,
+		hooks:     opts.Hooks,
+		logger:    logger,
+	}
+	if opts.PseudoTTL > 0 {
+		adaOpts = append(adaOpts, backend.WithPseudoTTL(opts.PseudoTTL, mgr.expired))
 	}
+	adaOpts = append(adaOpts, backend.WithOnModify(mgr.tally.replace))
+	mgr.pool = backend.Adapter(opts.Backend, opts.Codec, adaOpts...)
+	return mgr
+}
+
+// expired is invoked by the backend after a pseudo cache item expires. It
+// runs on a goroutine of the backend, so the lock must be taken to update
+// the tally, and the hook is invoked after unlocking.
+func (mgr *Manager) expired(item cache.Item) {
+	mgr.lockFn(func() {
+		mgr.tally.sub(item)
+	})
+	mgr.logger.Log(log.Info, "expire cache item", log.F("key", item.Key))
+	mgr.hooks.expire(item)
 }
 
 // Cap returns the capacity of the cache volume.
@@ -43,12 +78,39 @@ func (mgr *Manager) Cap() int64 {
 	return mgr.cap
 }
 
+// Usage returns the used space of the cache volume.
+func (mgr *Manager) Usage() (usage int64) {
+	mgr.rlockFn(func() {
+		usage = mgr.usage
+	})
+	return usage
+}
+
+// Iter iterates all cache items in the cache volume with a read lock held,
+// so do not call any method of the manager which modifies the cache volume
+// within iterCb. The order of iteration depends on the backend.
+func (mgr *Manager) Iter(iterCb func(k string, v cache.Item) error) (err error) {
+	mgr.rlockFn(func() {
+		err = mgr.pool.Iter(iterCb)
+	})
+	return err
+}
+
 // Set sets a file as a cache record into the manager. If the cache volume is full,
 // it will try emit some cache items to cleanup some cache space then insert this one.
 // It is possible that no cache items could be emitted at the moment which leads to this
 // operation be unavailable. To prevent waiting deadlock, by default we use timeout setting
 // and retry mechanism internally to prevent this condition.
-func (mgr *Manager) Set(key string, size int64) error {
+func (mgr *Manager) Set(key string, size int64) (err error) {
+	return mgr.SetWithMetadata(key, size, nil)
+}
+
+// SetWithMetadata is like Set, but attaches metadata to the cache item, so
+// it is visible to OnInsert hook and everything reading the cache item later.
+func (mgr *Manager) SetWithMetadata(key string, size int64, md cache.Metadata) (err error) {
+	start := time.Now()
+	defer func() { mgr.hooks.setDone(key, time.Since(start), err) }()
+
 	// First, we must make sure that the cache volume is able to
 	// fit the item. Or it is impossible to handle this cache item.
 	if size > mgr.cap {
@@ -61,7 +123,17 @@ func (mgr *Manager) Set(key string, size int64) error {
 	// will make its backend to handle it. If the cache volume does not has
 	// enough space, it will try cleaning up some space for it. After that,
 	// re-check if it is possible to insert the cache item.
-	return mgr.retryPutCache(key, size)
+	return mgr.retryPut(key, size, md)
+}
+
+// Annotate merges metadata into the cache item with given key, where empty
+// values remove the entries. If the key is missed, it returns
+// cache.ErrNoSuchKey.
+func (mgr *Manager) Annotate(key string, md cache.Metadata) (err error) {
+	mgr.lockFn(func() {
+		err = mgr.annotate(key, md)
+	})
+	return err
 }
 
 // Get gets the cache item record from the cache volume. If it failed to
@@ -70,21 +142,41 @@ func (mgr *Manager) Get(key string) (item cache.Item, err error) {
 	mgr.rlockFn(func() {
 		item, err = mgr.pool.Get(key)
 	})
+	mgr.lookup(key, item, err)
 	return item, err
 }
 
 // Once try get a cache item from the cache volume first. If the cache item has
 // been found, it will return it immediately. If not, it will invoke the given
 // lambda createFn to create the file cache, then insert it to the cache volume.
-// And finally, return the inserted cache item as result.
+// And finally, return the inserted cache item as result. Metadata passed to
+// putCacheFn, if any, is written along with the inserted cache item.
 func (mgr *Manager) Once(path string, createFn OnceHandler) (item cache.Item, err error) {
+	start := time.Now()
+	defer func() { mgr.hooks.onceDone(path, time.Since(start), err) }()
+
 	mgr.rlockFn(func() {
 		item, err = mgr.pool.Get(path)
 	})
+	mgr.lookup(path, item, err)
 	if err == nil {
 		return item, err
 	}
-	return createFn(mgr.preconditionCheck, mgr.retryPutCache, mgr.rollback)
+	return createFn(mgr.preconditionCheck, mgr.retryPut, mgr.rollback)
+}
+
+// Remove removes cache items from the cache volume, including their files
+// on disk if they are real ones. Keys which do not present are ignored.
+// Files are removed first, then records of all the keys are removed from
+// the backend at once, which is all-or-nothing if the backend supports
+// batches. If any file fails to be removed, no record will be removed.
+func (mgr *Manager) Remove(keys ...string) (err error) {
+	var evs events
+	mgr.lockFn(func() {
+		err = mgr.remove(keys, &evs)
+	})
+	evs.fire()
+	return err
 }
 
 // Register register file caches with their key and increment their
@@ -109,11 +201,107 @@ func (mgr *Manager) Unregister(keys ...string) {
 }
 
 func (mgr *Manager) register(keys ...string) {
-	mgr.pool.IncrRef(keys...)
+	if err := mgr.pool.IncrRef(keys...); err != nil {
+		mgr.logger.Log(log.Error, "failed to register cache items",
+			log.F("keys", keys), log.Err(err))
+		mgr.tally.reset()
+	}
 }
 
 func (mgr *Manager) unregister(keys ...string) {
-	mgr.pool.DecrRef(keys...)
+	if err := mgr.pool.DecrRef(keys...); err != nil {
+		mgr.logger.Log(log.Error, "failed to unregister cache items",
+			log.F("keys", keys), log.Err(err))
+		mgr.tally.reset()
+	}
+}
+
+func (mgr *Manager) remove(keys []string, evs *events) error {
+	var (
+		items   []cache.Item
+		removed []string
+		seen    = make(map[string]bool, len(keys))
+	)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		item, err := mgr.pool.Get(key)
+		if backend.IsNoKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// The file might have been deleted by others, which is fine
+		// since what we want is to get rid of it.
+		err = item.Remove()
+		if err != nil && !os.IsNotExist(err) {
+			mgr.logger.Log(log.Error, "failed to remove cache file",
+				log.F("key", key), log.F("path", item.Path), log.Err(err))
+			return err
+		}
+		items = append(items, item)
+		removed = append(removed, key)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	n, err := mgr.removeAll(removed)
+	if err != nil {
+		mgr.logger.Log(log.Error, "failed to remove cache items from backend",
+			log.F("keys", removed[n:]), log.Err(err))
+	}
+	for _, item := range items[:n] {
+		item := item
+		mgr.logger.Log(log.Info, "remove cache item",
+			log.F("key", item.Key), log.F("size", item.Size))
+		mgr.release(item)
+		mgr.tally.sub(item)
+		evs.add(func() { mgr.hooks.remove(item) })
+	}
+	return err
+}
+
+// removeAll removes keys from the pool, and returns how many leading keys
+// have been removed. If the pool is not a cache.BatchRemover, keys are
+// removed one by one, so they are not removed all-or-nothing.
+func (mgr *Manager) removeAll(keys []string) (int, error) {
+	if remover, ok := mgr.pool.(cache.BatchRemover); ok {
+		if err := remover.BatchRemove(keys...); err != nil {
+			return 0, err
+		}
+		return len(keys), nil
+	}
+	for i, key := range keys {
+		if err := mgr.pool.Remove(key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// release gives back the space occupied by the cache item.
+func (mgr *Manager) release(item cache.Item) {
+	if item.IsReal() {
+		mgr.usage -= item.Size
+	}
+}
+
+func (mgr *Manager) lookup(key string, item cache.Item, err error) {
+	if err == nil {
+		atomic.AddUint64(&mgr.counters.hits, 1)
+		mgr.hooks.hit(item)
+		return
+	}
+	if backend.IsNoKeyError(err) {
+		atomic.AddUint64(&mgr.counters.misses, 1)
+		mgr.hooks.miss(key)
+	}
 }
 
 func (mgr *Manager) lockFn(fn func()) {
@@ -135,69 +323,186 @@ func (mgr *Manager) preconditionCheck(item cache.Item) error {
 	return nil
 }
 
-func (mgr *Manager) retryPutCache(key string, size int64) error {
-	return retry.Do(func() error {
-		return mgr.set(key, size)
-	}, mgr.retryOpts...)
+func (mgr *Manager) retryPut(key string, size int64, md cache.Metadata) error {
+	return mgr.retryLocked(key, size, func(evs *events) error {
+		return mgr.set(key, size, md, evs)
+	})
 }
 
-func (mgr *Manager) set(key string, size int64) error {
-	var (
-		pool   = mgr.pool
-		policy = mgr.policy
-	)
+// retryLocked calls fn with the manager locked until it succeeds, or the
+// retry options give up.
+func (mgr *Manager) retryLocked(key string, size int64, fn func(evs *events) error) error {
+	var attempt uint
+	return retry.Do(func() (err error) {
+		var evs events
+		mgr.lockFn(func() {
+			err = fn(&evs)
+		})
+		evs.fire()
 
-	// Cache volume is able to fit the cache item.
-	if mgr.usage+size <= mgr.cap {
-		err := pool.Put(key, size)
+		attempt++
 		if err != nil {
-			return err
+			mgr.logger.Log(log.Warn, "failed to put cache item",
+				log.F("key", key), log.F("size", size), log.F("attempt", attempt), log.Err(err))
+			mgr.hooks.retry(key, attempt, err)
 		}
+		return err
+	}, mgr.retryOpts...)
+}
 
-		// Only increment the usage if and only if the PUT action
-		// finished successfully.
-		mgr.usage += size
-		return nil
+func (mgr *Manager) set(key string, size int64, md cache.Metadata, evs *events) error {
+	// Cache volume is able to fit the cache item.
+	if mgr.usage+size <= mgr.cap {
+		return mgr.put(key, size, md, evs)
 	}
 
 	// When cache volume is unable to fit the cache item, emit
 	// a victim from the cache to cleanup some space for it.
-	var item cache.Item
-	item, err := policy.Evict(pool)
+	if err := mgr.evict(evs, ""); err != nil {
+		return err
+	}
+
+	// If the cache volume still cannot fit the cache item. Return
+	// a specific error and keep trying.
+	if mgr.usage+size > mgr.cap {
+		return errRetry
+	}
+
+	// If the cache volume is able to fit the cache item after emitting
+	// a victim, then put it into the cache space.
+	return mgr.put(key, size, md, evs)
+}
+
+// evict evicts a victim chosen by the policy to cleanup some space. The
+// cache item with the excluded key, if any, is never chosen.
+func (mgr *Manager) evict(evs *events, excluded string) error {
+	var (
+		pool   = mgr.pool
+		policy = mgr.policy
+	)
+
+	candidates := pool
+	if excluded != "" {
+		candidates = exceptPool{Pool: pool, key: excluded}
+	}
+	item, err := policy.Evict(candidates)
 	if err != nil {
 		return err
 	}
 
 	err = item.Remove()
 	if err != nil {
+		mgr.logger.Log(log.Error, "failed to remove evicted cache file",
+			log.F("key", item.Key), log.F("path", item.Path), log.Err(err))
 		return err
 	}
 
 	err = pool.Remove(item.Key)
 	if err != nil {
+		mgr.logger.Log(log.Error, "failed to remove evicted cache item from backend",
+			log.F("key", item.Key), log.Err(err))
 		return err
 	}
+	mgr.logger.Log(log.Info, "evict cache item", log.F("key", item.Key),
+		log.F("size", item.Size), log.F("reason", EvictCapacity))
 	mgr.usage -= item.Size
+	mgr.tally.sub(item)
+	atomic.AddUint64(&mgr.counters.evictions, 1)
+	evs.add(func() {
+		mgr.hooks.evict(item, EvictCapacity)
+		mgr.hooks.remove(item)
+	})
+	return nil
+}
 
-	// If the cache volume still cannot fit the cache item. Return
-	// a specific error and keep trying.
-	if mgr.usage+size > mgr.cap {
-		return errRetry
-	}
+// exceptPool hides a key from iterations of the pool, so cache policies
+// never see it, while its usage statistics are left untouched.
+type exceptPool struct {
+	cache.Pool
+	key string
+}
 
-	// If the cache volume is able to fit the cache item after emitting
-	// a victim, then put it into the cache space.
-	err = pool.Put(key, size)
+func (p exceptPool) Iter(iterCb func(k string, v cache.Item) error) error {
+	return p.Pool.Iter(func(k string, v cache.Item) error {
+		if k == p.key {
+			return nil
+		}
+		return iterCb(k, v)
+	})
+}
+
+func (mgr *Manager) put(key string, size int64, md cache.Metadata, evs *events) error {
+	// Metadata is written in the same record, so no one could see the
+	// cache item without it.
+	var err error
+	if len(md) > 0 {
+		annotator, ok := mgr.pool.(cache.Annotator)
+		if !ok {
+			return retry.Unrecoverable(ErrNoMetadata)
+		}
+		err = annotator.PutWithMetadata(key, size, md)
+	} else {
+		err = mgr.pool.Put(key, size)
+	}
 	if err != nil {
 		return err
 	}
+
+	// Only increment the usage if and only if the PUT action
+	// finished successfully.
 	mgr.usage += size
+
+	// Reading the record back costs an extra access to the backend,
+	// so do it only if someone is interested in it.
+	if mgr.hooks.OnInsert != nil {
+		if item, err := mgr.pool.Get(key); err == nil {
+			evs.add(func() { mgr.hooks.insert(item) })
+		}
+	}
 	return nil
 }
 
+func (mgr *Manager) annotate(key string, md cache.Metadata) error {
+	annotator, ok := mgr.pool.(cache.Annotator)
+	if !ok {
+		return ErrNoMetadata
+	}
+	err := annotator.Annotate(key, md)
+	if err != nil && !backend.IsNoKeyError(err) {
+		mgr.logger.Log(log.Error, "failed to annotate cache item",
+			log.F("key", key), log.Err(err))
+	}
+	return err
+}
+
 func (mgr *Manager) rollback(key string) (err error) {
+	var item cache.Item
 	mgr.lockFn(func() {
+		item, err = mgr.pool.Get(key)
+		if backend.IsNoKeyError(err) {
+			item, err = cache.Item{}, nil
+			return
+		}
+		if err != nil {
+			return
+		}
 		err = mgr.pool.Remove(key)
+		if err == nil {
+			mgr.release(item)
+			mgr.tally.sub(item)
+		}
 	})
-	return err
+	if err != nil {
+		mgr.logger.Log(log.Error, "failed to rollback cache item",
+			log.F("key", key), log.Err(err))
+		return err
+	}
+	mgr.logger.Log(log.Info, "rollback cache item",
+		log.F("key", key), log.F("size", item.Size))
+
+	mgr.hooks.rollback(key)
+	if !item.IsZero() {
+		mgr.hooks.remove(item)
+	}
+	return nil
 }
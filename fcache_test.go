@@ -3,6 +3,7 @@ package fcache
 import (
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -99,7 +100,7 @@ func TestManagerSet(t *testing.T) {
 					Codec:    codec.Gob{},
 					Backend: backend.Mock{
 						PutHandler: func(k, v []byte) error { return errMock },
-						GetHandler: func(k []byte) ([]byte, error) { return nil, nil },
+						GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
 						RmHandler:  func(k []byte) error { return nil },
 					},
 					CachePolicy: policy.Mock{
@@ -221,7 +222,7 @@ func TestManagerOnce(t *testing.T) {
 				})
 				handler := func(
 					preconditionCheck func(cache.Item) error,
-					putCacheFn func(path string, size int64) error,
+					putCacheFn func(path string, size int64, md cache.Metadata) error,
 					rollback func(path string) error,
 				) (cache.Item, error) {
 					item := cache.New(123, "123", 456)
@@ -245,14 +246,14 @@ func TestManagerOnce(t *testing.T) {
 				})
 				handler := func(
 					preconditionCheck func(cache.Item) error,
-					putCacheFn func(path string, size int64) error,
+					putCacheFn func(path string, size int64, md cache.Metadata) error,
 					rollback func(path string) error,
 				) (cache.Item, error) {
 					item := cache.New(123, "123", 456)
 					if err := preconditionCheck(item); err != nil {
 						return item, err
 					}
-					if err := putCacheFn("123", 456); err != nil {
+					if err := putCacheFn("123", 456, nil); err != nil {
 						return item, err
 					}
 					err := rollback("123")
@@ -352,7 +353,7 @@ func TestManagerDoSet(t *testing.T) {
 				Codec:    codec.Gob{},
 				Backend: backend.Mock{
 					PutHandler: func(k, v []byte) error { return errMock },
-					GetHandler: func(k []byte) ([]byte, error) { return nil, nil },
+					GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
 				},
 				CachePolicy:  policy.LRU(),
 				RetryOptions: nil,
@@ -367,7 +368,7 @@ func TestManagerDoSet(t *testing.T) {
 				Codec:    codec.Gob{},
 				Backend: backend.Mock{
 					PutHandler: func(k, v []byte) error { return errMock },
-					GetHandler: func(k []byte) ([]byte, error) { return nil, nil },
+					GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
 				},
 				CachePolicy: policy.Mock{
 					EvictFn: func(cache.Pool) (cache.Item, error) {
@@ -386,7 +387,7 @@ func TestManagerDoSet(t *testing.T) {
 				Codec:    codec.Gob{},
 				Backend: backend.Mock{
 					PutHandler: func(k, v []byte) error { return errMock },
-					GetHandler: func(k []byte) ([]byte, error) { return nil, nil },
+					GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
 					RmHandler:  func(k []byte) error { return nil },
 				},
 				CachePolicy: policy.Mock{
@@ -408,7 +409,7 @@ func TestManagerDoSet(t *testing.T) {
 				Codec:    codec.Gob{},
 				Backend: backend.Mock{
 					PutHandler: func(k, v []byte) error { return errMock },
-					GetHandler: func(k []byte) ([]byte, error) { return nil, nil },
+					GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
 					RmHandler:  func(k []byte) error { return errMock },
 				},
 				CachePolicy: policy.Mock{
@@ -430,7 +431,7 @@ func TestManagerDoSet(t *testing.T) {
 				Codec:    codec.Gob{},
 				Backend: backend.Mock{
 					PutHandler: func(k, v []byte) error { return errMock },
-					GetHandler: func(k []byte) ([]byte, error) { return nil, nil },
+					GetHandler: func(k []byte) ([]byte, error) { return nil, cache.ErrNoSuchKey },
 					RmHandler:  func(k []byte) error { return nil },
 				},
 				CachePolicy: policy.Mock{
@@ -448,9 +449,276 @@ func TestManagerDoSet(t *testing.T) {
 	}
 
 	for idx, tc := range testcases {
-		err := tc.mgr.set(tc.item.Key, tc.item.Size)
+		err := tc.mgr.set(tc.item.Key, tc.item.Size, nil, &events{})
 		if !tc.determinErr(err) {
 			t.Errorf("[#Case%d]: %s, with unexpect error %v", idx, tc.description, err)
 		}
 	}
 }
+
+func TestManagerRemove(t *testing.T) {
+	testcases := []struct {
+		description string
+		scenario    func() error
+		expectErr   error
+	}{
+		{
+			"remove missing key",
+			func() error {
+				m := New(Options{
+					Capacity:     1000,
+					Codec:        codec.Gob{},
+					Backend:      gomap.New(),
+					CachePolicy:  policy.LRU(),
+					RetryOptions: nil,
+				})
+				return m.Remove("123")
+			},
+			nil,
+		},
+		{
+			"valid remove",
+			func() error {
+				m := New(Options{
+					Capacity:     1000,
+					Codec:        codec.Gob{},
+					Backend:      gomap.New(),
+					CachePolicy:  policy.LRU(),
+					RetryOptions: nil,
+				})
+				err := m.Set("123", 500)
+				if err != nil {
+					return err
+				}
+				err = m.Remove("123")
+				if err != nil {
+					return err
+				}
+				if m.usage != 0 {
+					return errors.Errorf("expect usage 0, but get %d", m.usage)
+				}
+				_, err = m.Get("123")
+				return err
+			},
+			cache.ErrNoSuchKey,
+		},
+		{
+			"pool get with error",
+			func() error {
+				m := New(Options{
+					Capacity: 1000,
+					Codec:    codec.Gob{},
+					Backend: backend.Mock{
+						GetHandler: func(k []byte) ([]byte, error) { return nil, errMock },
+					},
+					CachePolicy:  policy.LRU(),
+					RetryOptions: nil,
+				})
+				return m.Remove("123")
+			},
+			errMock,
+		},
+		{
+			"remove multiple keys",
+			func() error {
+				m := New(Options{
+					Capacity:     1000,
+					Codec:        codec.Gob{},
+					Backend:      gomap.New(),
+					CachePolicy:  policy.LRU(),
+					RetryOptions: nil,
+				})
+				for _, key := range []string{"123", "456"} {
+					if err := m.Set(key, 200); err != nil {
+						return err
+					}
+				}
+				m.Register("789")
+				err := m.Remove("123", "456", "789", "123", "missing")
+				if err != nil {
+					return err
+				}
+				if m.usage != 0 {
+					return errors.Errorf("expect usage 0, but get %d", m.usage)
+				}
+				return m.Iter(func(k string, v cache.Item) error {
+					return errors.Errorf("expect no cache items, but get %s", k)
+				})
+			},
+			nil,
+		},
+		{
+			"remove multiple keys one by one",
+			func() error {
+				m := New(Options{
+					Capacity:     1000,
+					Codec:        codec.Gob{},
+					Backend:      gomap.New(),
+					CachePolicy:  policy.LRU(),
+					RetryOptions: nil,
+				})
+				for _, key := range []string{"123", "456", "789"} {
+					if err := m.Set(key, 200); err != nil {
+						return err
+					}
+				}
+				m.pool = singleRemovePool{Pool: m.pool, fail: "456"}
+				err := m.Remove("123", "456", "789")
+				if err != errMock {
+					return errors.Errorf("expect %v, but get %v", errMock, err)
+				}
+				if m.usage != 400 {
+					return errors.Errorf("expect usage 400, but get %d", m.usage)
+				}
+				_, err = m.Get("123")
+				if err != cache.ErrNoSuchKey {
+					return errors.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+				}
+				return nil
+			},
+			nil,
+		},
+	}
+
+	for idx, tc := range testcases {
+		err := tc.scenario()
+		if err != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+	}
+}
+
+func TestManagerUsageAndIter(t *testing.T) {
+	m := New(Options{
+		Capacity:     1000,
+		Codec:        codec.Gob{},
+		Backend:      gomap.New(),
+		CachePolicy:  policy.LRU(),
+		RetryOptions: nil,
+	})
+	if err := m.Set("123", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("456", 200); err != nil {
+		t.Fatal(err)
+	}
+	m.Register("789")
+
+	if m.Usage() != 300 {
+		t.Errorf("expect %v, but get %v", 300, m.Usage())
+	}
+
+	count := 0
+	err := m.Iter(func(k string, v cache.Item) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expect %v, but get %v", 3, count)
+	}
+
+	err = m.Iter(func(k string, v cache.Item) error { return errMock })
+	if err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+}
+
+func TestManagerMetadata(t *testing.T) {
+	var inserted []cache.Item
+	m := New(Options{
+		Capacity:    1000,
+		Codec:       codec.Binary{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		Hooks: Hooks{
+			OnInsert: func(item cache.Item) { inserted = append(inserted, item) },
+		},
+	})
+	md := cache.Metadata{cache.MetaETag: `"abc"`, "label": "x"}
+	if err := m.SetWithMetadata("123", 100, md); err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 1 || !reflect.DeepEqual(inserted[0].Metadata, md) {
+		t.Errorf("expect OnInsert to see %v, but get %v", md, inserted)
+	}
+
+	if err := m.Annotate("123", cache.Metadata{"label": "", cache.MetaContentHash: "sha256:0"}); err != nil {
+		t.Fatal(err)
+	}
+	item, err := m.Get("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := cache.Metadata{cache.MetaETag: `"abc"`, cache.MetaContentHash: "sha256:0"}
+	if !reflect.DeepEqual(item.Metadata, expect) {
+		t.Errorf("expect %v, but get %v", expect, item.Metadata)
+	}
+
+	if err := m.Annotate("missing", md); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+
+	// Metadata passed to putCacheFn by a once handler is written along with
+	// the item.
+	item, err = m.Once("456", func(
+		preconditionCheck func(item cache.Item) error,
+		putCacheFn func(path string, size int64, md cache.Metadata) error,
+		rollback func(path string) error,
+	) (cache.Item, error) {
+		item := cache.New(1, "456", 10)
+		item.Metadata = cache.Metadata{cache.MetaSourceURL: "https://example.com/456"}
+		return item, putCacheFn("456", 10, item.Metadata)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item, err = m.Get("456"); err != nil || item.Meta(cache.MetaSourceURL) != "https://example.com/456" {
+		t.Errorf("expect metadata attached by once, but get %v, %v", item.Metadata, err)
+	}
+}
+
+func TestManagerMetadataFailure(t *testing.T) {
+	// The codec refuses metadata, so the item fails to be put along with
+	// it, and nothing is left.
+	m := New(Options{
+		Capacity:    1000,
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		Codec: codec.Mock{
+			MarshalFn: func(v interface{}) ([]byte, error) {
+				if len(v.(cache.Item).Metadata) > 0 {
+					return nil, errMock
+				}
+				return codec.Gob{}.Marshal(v)
+			},
+			UnmarshalFn: codec.Gob{}.Unmarshal,
+		},
+		RetryOptions: []retry.Option{retry.Attempts(1), retry.LastErrorOnly(true)},
+	})
+	err := m.SetWithMetadata("123", 100, cache.Metadata{"a": "1"})
+	if errors.Cause(err) != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+	if _, err := m.Get("123"); err != cache.ErrNoSuchKey {
+		t.Errorf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if m.Usage() != 0 {
+		t.Errorf("expect usage 0, but get %v", m.Usage())
+	}
+}
+
+// singleRemovePool hides BatchRemove of the pool, and fails to remove a key.
+type singleRemovePool struct {
+	cache.Pool
+	fail string
+}
+
+func (p singleRemovePool) Remove(key string) error {
+	if key == p.fail {
+		return errMock
+	}
+	return p.Pool.Remove(key)
+}
@@ -6,8 +6,13 @@ require (
 	github.com/avast/retry-go v2.6.0+incompatible
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/dustin/go-humanize v1.0.0
+	github.com/fxamacker/cbor/v2 v2.2.0
+	github.com/golang/snappy v0.0.1
 	github.com/google/go-cmp v0.5.1
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.6.1 // indirect
+	github.com/syndtr/goleveldb v1.0.1-0.20200815110645-5c35d600f0ca
+	github.com/vmihailenco/msgpack/v5 v5.0.0
 	go.etcd.io/bbolt v1.3.5
+	golang.org/x/sys v0.0.0-20200814200057-3d37ad5750ed
 )
@@ -0,0 +1,237 @@
+package fcache
+
+import (
+	"time"
+
+	"github.com/meowdada/go-fcache/cache"
+)
+
+// EvictReason describes why a cache item has been evicted.
+type EvictReason int
+
+const (
+	// EvictCapacity means the cache item is evicted to make room for
+	// another cache item.
+	EvictCapacity EvictReason = iota
+)
+
+// String returns a human readable form of the evict reason.
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+// Hooks are callbacks which are invoked on lifecycle events of cache items.
+// Each of them is optional. Hooks are always invoked outside the manager lock,
+// so it is safe to call methods of the manager within them.
+type Hooks struct {
+	// OnInsert is invoked after a cache item has been put into the cache volume.
+	OnInsert func(item cache.Item)
+
+	// OnHit is invoked when a lookup finds the cache item.
+	OnHit func(item cache.Item)
+
+	// OnMiss is invoked when a lookup fails to find the cache item.
+	OnMiss func(key string)
+
+	// OnEvict is invoked after a victim has been evicted from the cache volume.
+	OnEvict func(item cache.Item, reason EvictReason)
+
+	// OnRemove is invoked after a cache item has been removed from the cache volume,
+	// no matter it is caused by eviction, rollback or an explicit removal.
+	OnRemove func(item cache.Item)
+
+	// OnRollback is invoked after a cache item has been rolled back by a once handler.
+	OnRollback func(key string)
+
+	// OnExpire is invoked after a cache item has been removed due to expiration.
+	OnExpire func(item cache.Item)
+
+	// OnRevalidate is invoked after a cache item has been revalidated against
+	// its origin, modified reports whether its content has been replaced.
+	OnRevalidate func(item cache.Item, modified bool)
+
+	// OnRetry is invoked every time an attempt to put a cache item fails, the
+	// attempt counts from 1. Like retry.OnRetry, it is also invoked when the
+	// last attempt fails.
+	OnRetry func(key string, attempt uint, err error)
+
+	// OnSetDone is invoked when Set returns, with the time elapsed and the
+	// returned error.
+	OnSetDone func(key string, elapsed time.Duration, err error)
+
+	// OnOnceDone is invoked when Once returns, with the time elapsed and the
+	// returned error.
+	OnOnceDone func(key string, elapsed time.Duration, err error)
+}
+
+// ChainHooks combines hooks into one, which invokes each of them in order,
+// such as user hooks along with metrics.Collector.Hooks. A hook is left nil
+// if none of them sets it, so it costs nothing.
+func ChainHooks(hooks ...Hooks) Hooks {
+	hooks = append([]Hooks(nil), hooks...)
+	var chained Hooks
+	for _, h := range hooks {
+		if h.OnInsert != nil {
+			chained.OnInsert = func(item cache.Item) {
+				for _, h := range hooks {
+					h.insert(item)
+				}
+			}
+		}
+		if h.OnHit != nil {
+			chained.OnHit = func(item cache.Item) {
+				for _, h := range hooks {
+					h.hit(item)
+				}
+			}
+		}
+		if h.OnMiss != nil {
+			chained.OnMiss = func(key string) {
+				for _, h := range hooks {
+					h.miss(key)
+				}
+			}
+		}
+		if h.OnEvict != nil {
+			chained.OnEvict = func(item cache.Item, reason EvictReason) {
+				for _, h := range hooks {
+					h.evict(item, reason)
+				}
+			}
+		}
+		if h.OnRemove != nil {
+			chained.OnRemove = func(item cache.Item) {
+				for _, h := range hooks {
+					h.remove(item)
+				}
+			}
+		}
+		if h.OnRollback != nil {
+			chained.OnRollback = func(key string) {
+				for _, h := range hooks {
+					h.rollback(key)
+				}
+			}
+		}
+		if h.OnExpire != nil {
+			chained.OnExpire = func(item cache.Item) {
+				for _, h := range hooks {
+					h.expire(item)
+				}
+			}
+		}
+		if h.OnRevalidate != nil {
+			chained.OnRevalidate = func(item cache.Item, modified bool) {
+				for _, h := range hooks {
+					h.revalidate(item, modified)
+				}
+			}
+		}
+		if h.OnRetry != nil {
+			chained.OnRetry = func(key string, attempt uint, err error) {
+				for _, h := range hooks {
+					h.retry(key, attempt, err)
+				}
+			}
+		}
+		if h.OnSetDone != nil {
+			chained.OnSetDone = func(key string, elapsed time.Duration, err error) {
+				for _, h := range hooks {
+					h.setDone(key, elapsed, err)
+				}
+			}
+		}
+		if h.OnOnceDone != nil {
+			chained.OnOnceDone = func(key string, elapsed time.Duration, err error) {
+				for _, h := range hooks {
+					h.onceDone(key, elapsed, err)
+				}
+			}
+		}
+	}
+	return chained
+}
+
+func (h Hooks) insert(item cache.Item) {
+	if h.OnInsert != nil {
+		h.OnInsert(item)
+	}
+}
+
+func (h Hooks) hit(item cache.Item) {
+	if h.OnHit != nil {
+		h.OnHit(item)
+	}
+}
+
+func (h Hooks) miss(key string) {
+	if h.OnMiss != nil {
+		h.OnMiss(key)
+	}
+}
+
+func (h Hooks) evict(item cache.Item, reason EvictReason) {
+	if h.OnEvict != nil {
+		h.OnEvict(item, reason)
+	}
+}
+
+func (h Hooks) remove(item cache.Item) {
+	if h.OnRemove != nil {
+		h.OnRemove(item)
+	}
+}
+
+func (h Hooks) expire(item cache.Item) {
+	if h.OnExpire != nil {
+		h.OnExpire(item)
+	}
+}
+
+func (h Hooks) rollback(key string) {
+	if h.OnRollback != nil {
+		h.OnRollback(key)
+	}
+}
+
+func (h Hooks) revalidate(item cache.Item, modified bool) {
+	if h.OnRevalidate != nil {
+		h.OnRevalidate(item, modified)
+	}
+}
+
+func (h Hooks) retry(key string, attempt uint, err error) {
+	if h.OnRetry != nil {
+		h.OnRetry(key, attempt, err)
+	}
+}
+
+func (h Hooks) setDone(key string, elapsed time.Duration, err error) {
+	if h.OnSetDone != nil {
+		h.OnSetDone(key, elapsed, err)
+	}
+}
+
+func (h Hooks) onceDone(key string, elapsed time.Duration, err error) {
+	if h.OnOnceDone != nil {
+		h.OnOnceDone(key, elapsed, err)
+	}
+}
+
+// events collects hook invocations raised while holding the manager lock,
+// so they could be fired after the lock has been released.
+type events []func()
+
+func (evs *events) add(fn func()) {
+	*evs = append(*evs, fn)
+}
+
+func (evs events) fire() {
+	for _, fn := range evs {
+		fn()
+	}
+}
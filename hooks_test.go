@@ -0,0 +1,264 @@
+package fcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/policy"
+)
+
+func TestEvictReasonString(t *testing.T) {
+	testcases := []struct {
+		reason EvictReason
+		expect string
+	}{
+		{EvictCapacity, "capacity"},
+		{EvictReason(-1), "unknown"},
+	}
+
+	for idx, tc := range testcases {
+		if tc.reason.String() != tc.expect {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, tc.reason.String())
+		}
+	}
+}
+
+func TestChainHooks(t *testing.T) {
+	var calls []string
+	record := func(name string) { calls = append(calls, name) }
+	first := Hooks{
+		OnInsert:   func(cache.Item) { record("first insert") },
+		OnMiss:     func(string) { record("first miss") },
+		OnSetDone:  func(string, time.Duration, error) { record("first set done") },
+		OnRollback: func(string) { record("first rollback") },
+	}
+	second := Hooks{
+		OnInsert:     func(cache.Item) { record("second insert") },
+		OnHit:        func(cache.Item) { record("second hit") },
+		OnEvict:      func(cache.Item, EvictReason) { record("second evict") },
+		OnRemove:     func(cache.Item) { record("second remove") },
+		OnExpire:     func(cache.Item) { record("second expire") },
+		OnRevalidate: func(cache.Item, bool) { record("second revalidate") },
+		OnRetry:      func(string, uint, error) { record("second retry") },
+		OnOnceDone:   func(string, time.Duration, error) { record("second once done") },
+	}
+
+	h := ChainHooks(first, Hooks{}, second)
+	item := cache.Dummy(1, "k")
+	h.insert(item)
+	h.hit(item)
+	h.miss("k")
+	h.evict(item, EvictCapacity)
+	h.remove(item)
+	h.rollback("k")
+	h.expire(item)
+	h.revalidate(item, true)
+	h.retry("k", 1, nil)
+	h.setDone("k", 0, nil)
+	h.onceDone("k", 0, nil)
+
+	expect := "[first insert second insert second hit first miss second evict second remove " +
+		"first rollback second expire second revalidate second retry first set done second once done]"
+	if got := fmt.Sprint(calls); got != expect {
+		t.Errorf("expect %v, but get %v", expect, got)
+	}
+	if ChainHooks(first).OnHit != nil || ChainHooks().OnInsert != nil {
+		t.Errorf("expect hooks which are not set left nil")
+	}
+}
+
+func TestManagerHooks(t *testing.T) {
+	var (
+		inserted []string
+		hits     []string
+		misses   []string
+		evicted  []string
+		removed  []string
+		rollback []string
+	)
+
+	var mgr *Manager
+	mgr = New(Options{
+		Capacity:    1000,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		RetryOptions: []retry.Option{
+			retry.MaxDelay(time.Millisecond),
+			retry.Attempts(10),
+			retry.LastErrorOnly(true),
+		},
+		Hooks: Hooks{
+			OnInsert: func(item cache.Item) {
+				// Hooks are invoked outside the lock, so it must not deadlock.
+				if _, err := mgr.Get(item.Key); err != nil {
+					t.Error(err)
+				}
+				inserted = append(inserted, item.Key)
+			},
+			OnHit:  func(item cache.Item) { hits = append(hits, item.Key) },
+			OnMiss: func(key string) { misses = append(misses, key) },
+			OnEvict: func(item cache.Item, reason EvictReason) {
+				if reason != EvictCapacity {
+					t.Errorf("expect %v, but get %v", EvictCapacity, reason)
+				}
+				evicted = append(evicted, item.Key)
+			},
+			OnRemove:   func(item cache.Item) { removed = append(removed, item.Key) },
+			OnRollback: func(key string) { rollback = append(rollback, key) },
+		},
+	})
+
+	err := ioutil.WriteFile("hook-1", nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("hook-1")
+
+	if err := mgr.Set("hook-1", 500); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.Get("hook-2"); err != cache.ErrNoSuchKey {
+		t.Fatalf("expect %v, but get %v", cache.ErrNoSuchKey, err)
+	}
+	if err := mgr.Set("hook-2", 800); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.Once("hook-3", func(
+		preconditionCheck func(cache.Item) error,
+		putCacheFn func(path string, size int64, md cache.Metadata) error,
+		rollback func(path string) error,
+	) (cache.Item, error) {
+		if err := putCacheFn("hook-3", 100, nil); err != nil {
+			return cache.Item{}, err
+		}
+		return cache.Item{}, rollback("hook-3")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mgr.Remove("hook-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []struct {
+		description string
+		expect      []string
+		actual      []string
+	}{
+		{"insert", []string{"hook-1", "hook-2", "hook-3"}, inserted},
+		{"hit", []string{"hook-1", "hook-2", "hook-3"}, hits},
+		{"miss", []string{"hook-2", "hook-3"}, misses},
+		{"evict", []string{"hook-1"}, evicted},
+		{"remove", []string{"hook-1", "hook-3", "hook-2"}, removed},
+		{"rollback", []string{"hook-3"}, rollback},
+	}
+
+	for idx, e := range expects {
+		if len(e.expect) != len(e.actual) {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, e.description, e.expect, e.actual)
+			continue
+		}
+		for i := range e.expect {
+			if e.expect[i] != e.actual[i] {
+				t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, e.description, e.expect, e.actual)
+				break
+			}
+		}
+	}
+
+	if mgr.usage != 0 {
+		t.Errorf("expect usage %v, but get %v", 0, mgr.usage)
+	}
+}
+
+func TestManagerHooksRetryAndDone(t *testing.T) {
+	var (
+		attempts []uint
+		setDone  []error
+		onceDone []error
+	)
+
+	mgr := New(Options{
+		Capacity:    1000,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		RetryOptions: []retry.Option{
+			retry.MaxDelay(time.Millisecond),
+			retry.Attempts(3),
+			retry.LastErrorOnly(true),
+		},
+		Hooks: Hooks{
+			OnRetry: func(key string, attempt uint, err error) {
+				if err != policy.ErrNoEmitableCaches {
+					t.Errorf("expect %v, but get %v", policy.ErrNoEmitableCaches, err)
+				}
+				attempts = append(attempts, attempt)
+			},
+			OnSetDone:  func(key string, elapsed time.Duration, err error) { setDone = append(setDone, err) },
+			OnOnceDone: func(key string, elapsed time.Duration, err error) { onceDone = append(onceDone, err) },
+		},
+	})
+
+	if err := mgr.Set("123", 600); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Register("123")
+	if err := mgr.Set("456", 600); err != policy.ErrNoEmitableCaches {
+		t.Fatalf("expect %v, but get %v", policy.ErrNoEmitableCaches, err)
+	}
+	if _, err := mgr.Once("123", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[2] != 3 {
+		t.Errorf("expect attempts %v, but get %v", []uint{1, 2, 3}, attempts)
+	}
+	if len(setDone) != 2 || setDone[0] != nil || setDone[1] != policy.ErrNoEmitableCaches {
+		t.Errorf("expect %v, but get %v", []error{nil, policy.ErrNoEmitableCaches}, setDone)
+	}
+	if len(onceDone) != 1 || onceDone[0] != nil {
+		t.Errorf("expect %v, but get %v", []error{nil}, onceDone)
+	}
+}
+
+func TestManagerHooksExpire(t *testing.T) {
+	expired := make(chan string, 1)
+	mgr := New(Options{
+		Capacity:    1000,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		PseudoTTL:   time.Millisecond,
+		Hooks: Hooks{
+			OnExpire: func(item cache.Item) { expired <- item.Key },
+		},
+	})
+
+	mgr.Register("123", "456")
+	if err := mgr.Set("456", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case key := <-expired:
+		if key != "123" {
+			t.Errorf("expect %v, but get %v", "123", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect a pseudo cache item expired")
+	}
+	if _, err := mgr.Get("456"); err != nil {
+		t.Errorf("expect a real cache item kept, but get %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package fcache
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/meowdada/go-fcache/cache"
+)
+
+// DefaultListLimit is the maximum number of cache items of a page when
+// ListOptions.Limit is not specified.
+const DefaultListLimit = 1000
+
+// ListOptions configures Manager.List.
+type ListOptions struct {
+	// Prefix limits the listing to keys beginning with it.
+	Prefix string
+
+	// StartAfter makes the listing start after this key, usually it is
+	// Page.Next of the previous page.
+	StartAfter string
+
+	// Limit is the maximum number of cache items of a page. If it is not
+	// positive, DefaultListLimit will be used.
+	Limit int
+
+	// Filter decides whether a cache item should be listed or not. All
+	// cache items are listed if it is nil.
+	Filter func(item cache.Item) bool
+}
+
+// Page is a page of cache items listed by Manager.List.
+type Page struct {
+	// Items are cache items in ascending order of keys.
+	Items []cache.Item
+
+	// Next is the last key examined in this page, use it as StartAfter to
+	// get the next page.
+	Next string
+
+	// More reports whether there are more cache items to be listed.
+	More bool
+}
+
+// List lists a page of cache items in ascending order of keys. If the pool
+// is able to scan in order, only the keys of this page will be examined.
+// Otherwise, the whole cache pool must be scanned and sorted. The read lock of
+// the manager is held during listing, so do not call any method of the manager
+// which modifies the cache volume within the filter.
+func (mgr *Manager) List(ctx context.Context, opts ListOptions) (page Page, err error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	start := opts.Prefix
+	if opts.StartAfter > start {
+		start = opts.StartAfter
+	}
+
+	mgr.rlockFn(func() {
+		err = scan(mgr.pool, start, func(k string, v cache.Item) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !strings.HasPrefix(k, opts.Prefix) {
+				return errStopScan
+			}
+			if k == opts.StartAfter {
+				return nil
+			}
+
+			// The page is full and there is still an item left.
+			if len(page.Items) == limit {
+				page.More = true
+				return errStopScan
+			}
+
+			// Keys are only valid during scanning, so copy it.
+			page.Next = string([]byte(k))
+			if opts.Filter != nil && !opts.Filter(v) {
+				return nil
+			}
+			page.Items = append(page.Items, v)
+			return nil
+		})
+	})
+	if err == errStopScan {
+		err = nil
+	}
+	if err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
+
+// scan iterates cache items of the pool in ascending order of keys, starting
+// from start. If the pool is not a cache.Scanner, all cache items are
+// collected by Iter and sorted.
+func scan(pool cache.Pool, start string, iterCb func(k string, v cache.Item) error) error {
+	if scanner, ok := pool.(cache.Scanner); ok {
+		return scanner.Scan(start, iterCb)
+	}
+
+	var items []cache.Item
+	err := pool.Iter(func(k string, v cache.Item) error {
+		if k >= start {
+			v.Key = k
+			items = append(items, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	for _, item := range items {
+		if err := iterCb(item.Key, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
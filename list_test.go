@@ -0,0 +1,177 @@
+package fcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/policy"
+)
+
+func TestManagerList(t *testing.T) {
+	m := New(Options{
+		Capacity:     1000,
+		Codec:        codec.Gob{},
+		Backend:      gomap.New(),
+		CachePolicy:  policy.LRU(),
+		RetryOptions: nil,
+	})
+	for _, key := range []string{"a/3", "b/1", "a/1", "a/2", "a/4", "c"} {
+		if err := m.Set(key, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Register("a/2")
+	if err := m.Annotate("b/1", cache.Metadata{cache.MetaContentType: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		description string
+		opts        ListOptions
+		expectKeys  string
+		expectNext  string
+		expectMore  bool
+	}{
+		{
+			"list all",
+			ListOptions{},
+			"[a/1 a/2 a/3 a/4 b/1 c]",
+			"c",
+			false,
+		},
+		{
+			"list with prefix",
+			ListOptions{Prefix: "a/"},
+			"[a/1 a/2 a/3 a/4]",
+			"a/4",
+			false,
+		},
+		{
+			"list with prefix and limit",
+			ListOptions{Prefix: "a/", Limit: 2},
+			"[a/1 a/2]",
+			"a/2",
+			true,
+		},
+		{
+			"list the next page",
+			ListOptions{Prefix: "a/", StartAfter: "a/2", Limit: 2},
+			"[a/3 a/4]",
+			"a/4",
+			false,
+		},
+		{
+			"list with start after out of prefix",
+			ListOptions{Prefix: "a/", StartAfter: "b"},
+			"[]",
+			"",
+			false,
+		},
+		{
+			"list with filter",
+			ListOptions{Filter: func(item cache.Item) bool { return item.Reference() > 0 }},
+			"[a/2]",
+			"c",
+			false,
+		},
+		{
+			"list with filter on metadata",
+			ListOptions{Filter: func(item cache.Item) bool { return item.Meta(cache.MetaContentType) == "image/png" }},
+			"[b/1]",
+			"c",
+			false,
+		},
+	}
+
+	for idx, tc := range testcases {
+		page, err := m.List(context.Background(), tc.opts)
+		if err != nil {
+			t.Errorf("[#Case%d] %s: expect no error, but get %v", idx, tc.description, err)
+			continue
+		}
+
+		keys := make([]string, 0, len(page.Items))
+		for _, item := range page.Items {
+			keys = append(keys, item.Key)
+		}
+		if fmt.Sprint(keys) != tc.expectKeys {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectKeys, keys)
+		}
+		if page.Next != tc.expectNext {
+			t.Errorf("[#Case%d] %s: expect next %v, but get %v", idx, tc.description, tc.expectNext, page.Next)
+		}
+		if page.More != tc.expectMore {
+			t.Errorf("[#Case%d] %s: expect more %v, but get %v", idx, tc.description, tc.expectMore, page.More)
+		}
+	}
+}
+
+func TestManagerListCanceled(t *testing.T) {
+	m := New(Options{
+		Capacity:     1000,
+		Codec:        codec.Gob{},
+		Backend:      gomap.New(),
+		CachePolicy:  policy.LRU(),
+		RetryOptions: nil,
+	})
+	if err := m.Set("123", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := m.List(ctx, ListOptions{})
+	if err != context.Canceled {
+		t.Errorf("expect %v, but get %v", context.Canceled, err)
+	}
+}
+
+// unorderedPool hides Scan of the pool.
+type unorderedPool struct {
+	cache.Pool
+}
+
+func TestManagerListUnordered(t *testing.T) {
+	m := New(Options{
+		Capacity:     1000,
+		Codec:        codec.Gob{},
+		Backend:      gomap.New(),
+		CachePolicy:  policy.LRU(),
+		RetryOptions: nil,
+	})
+	for _, key := range []string{"a/3", "b/1", "a/1", "a/2", "a/4", "c"} {
+		if err := m.Set(key, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []ListOptions{
+		{},
+		{Prefix: "a/"},
+		{Prefix: "a/", Limit: 2},
+		{Prefix: "a/", StartAfter: "a/2", Limit: 2},
+		{StartAfter: "b"},
+	}
+
+	pool := m.pool
+	for idx, opts := range testcases {
+		m.pool = pool
+		expect, err := m.List(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.pool = unorderedPool{pool}
+		page, err := m.List(context.Background(), opts)
+		if err != nil {
+			t.Errorf("[#Case%d]: expect no error, but get %v", idx, err)
+			continue
+		}
+		if fmt.Sprint(page) != fmt.Sprint(expect) {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, expect, page)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package fcache
+
+import (
+	"bytes"
+	stdlog "log"
+	"strings"
+	"testing"
+
+	"github.com/avast/retry-go"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/pkg/log"
+	"github.com/meowdada/go-fcache/policy"
+)
+
+func TestManagerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	m := New(Options{
+		Capacity:     1000,
+		Codec:        codec.Gob{},
+		Backend:      gomap.New(),
+		CachePolicy:  policy.LRU(),
+		RetryOptions: []retry.Option{retry.Attempts(1)},
+		Logger:       log.Std(stdlog.New(&buf, "", 0), log.Debug),
+	})
+
+	// The file does not exist, so the eviction fails.
+	if err := m.Set("123", 600); err != nil {
+		t.Fatal(err)
+	}
+	m.Set("456", 600)
+
+	if _, err := m.Once("789", func(
+		preconditionCheck func(cache.Item) error,
+		putCacheFn func(path string, size int64, md cache.Metadata) error,
+		rollback func(path string) error,
+	) (cache.Item, error) {
+		return cache.Item{}, rollback("789")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []string{
+		"level=debug msg=\"pick a victim\" policy=lru key=123 size=600",
+		"level=error msg=\"failed to remove evicted cache file\" key=123 path=123",
+		"level=warn msg=\"failed to put cache item\" key=456 size=600 attempt=1",
+		"level=info msg=\"rollback cache item\" key=789 size=0",
+	}
+	for idx, expect := range expects {
+		if !strings.Contains(buf.String(), expect) {
+			t.Errorf("[#Case%d]: expect %q in logs:\n%s", idx, expect, buf.String())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the default upper bounds (in seconds) of latency histograms.
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+// counter is a monotonically increasing value.
+type counter struct {
+	val uint64
+}
+
+func (c *counter) Add(n uint64) {
+	atomic.AddUint64(&c.val, n)
+}
+
+func (c *counter) Inc() {
+	c.Add(1)
+}
+
+func (c *counter) Value() uint64 {
+	return atomic.LoadUint64(&c.val)
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	mu      sync.Mutex
+}
+
+func newHistogram(buckets []float64) *histogram {
+	bs := append([]float64(nil), buckets...)
+	sort.Float64s(bs)
+	return &histogram{
+		buckets: bs,
+		counts:  make([]uint64, len(bs)),
+	}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// snapshot returns a consistent copy of the histogram.
+func (h *histogram) snapshot() (counts []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	counts = append([]uint64(nil), h.counts...)
+	count, sum = h.count, h.sum
+	h.mu.Unlock()
+	return counts, count, sum
+}
+
+// writer writes metrics in Prometheus text exposition format. It records
+// the first error occurs and ignores all following writes.
+type writer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *writer) header(name, help, typ string) {
+	w.printf("# HELP %s %s\n", name, help)
+	w.printf("# TYPE %s %s\n", name, typ)
+}
+
+func (w *writer) counter(name, help string, v uint64) {
+	w.header(name, help, "counter")
+	w.printf("%s %d\n", name, v)
+}
+
+func (w *writer) gauge(name, help string, v float64) {
+	w.header(name, help, "gauge")
+	w.printf("%s %s\n", name, formatFloat(v))
+}
+
+func (w *writer) histogram(name, help string, h *histogram) {
+	counts, count, sum := h.snapshot()
+	w.header(name, help, "histogram")
+	for i, upper := range h.buckets {
+		w.printf("%s_bucket{le=\"%s\"} %d\n", name, formatFloat(upper), counts[i])
+	}
+	w.printf("%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	w.printf("%s_sum %s\n", name, formatFloat(sum))
+	w.printf("%s_count %d\n", name, count)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
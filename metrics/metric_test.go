@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 0.1})
+	for _, v := range []float64{0.05, 0.5, 5} {
+		h.Observe(v)
+	}
+
+	var buf bytes.Buffer
+	w := &writer{w: &buf}
+	w.histogram("h", "help", h)
+	if w.err != nil {
+		t.Fatal(w.err)
+	}
+
+	expect := "# HELP h help\n" +
+		"# TYPE h histogram\n" +
+		"h_bucket{le=\"0.1\"} 1\n" +
+		"h_bucket{le=\"1\"} 2\n" +
+		"h_bucket{le=\"+Inf\"} 3\n" +
+		"h_sum 5.55\n" +
+		"h_count 3\n"
+	if buf.String() != expect {
+		t.Errorf("expect %q, but get %q", expect, buf.String())
+	}
+	if w.n != int64(buf.Len()) {
+		t.Errorf("expect %v, but get %v", buf.Len(), w.n)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	testcases := []struct {
+		v      float64
+		expect string
+	}{
+		{1, "1"},
+		{0.25, "0.25"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for idx, tc := range testcases {
+		if s := formatFloat(tc.v); s != tc.expect {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, s)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package metrics collects metrics of a file cache manager and exposes them
+// in Prometheus text exposition format, without depending on the Prometheus
+// client library.
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/meowdada/go-fcache"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/policy"
+)
+
+// ContentType is the content type of Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Options configures a metrics collector.
+type Options struct {
+	// Namespace prefixes names of all metrics, "fcache" by default.
+	Namespace string
+
+	// Buckets are upper bounds (in seconds) of latency histograms,
+	// DefaultBuckets by default.
+	Buckets []float64
+}
+
+// New creates a metrics collector. Hooks of the collector must be set into
+// fcache.Options to collect events, and the manager must be watched by the
+// collector to report gauges.
+//
+//	c := metrics.New(metrics.Options{})
+//	mgr := fcache.New(fcache.Options{..., Hooks: c.Hooks()})
+//	c.Watch(mgr)
+//	http.Handle("/metrics", c)
+func New(opts Options) *Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "fcache"
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = DefaultBuckets
+	}
+	return &Collector{
+		ns:          opts.Namespace,
+		setLatency:  newHistogram(opts.Buckets),
+		onceLatency: newHistogram(opts.Buckets),
+	}
+}
+
+// Collector collects metrics of a file cache manager. It implements
+// http.Handler so it could be scraped by Prometheus directly.
+type Collector struct {
+	ns           string
+	hits         counter
+	misses       counter
+	inserts      counter
+	evictions    counter
+	evictedBytes counter
+	retries      counter
+	noEmitable   counter
+	setLatency   *histogram
+	onceLatency  *histogram
+	mgr          *fcache.Manager
+	mu           sync.RWMutex
+}
+
+// Hooks returns hooks which feed events of a manager into the collector.
+// Combine them with other hooks by fcache.ChainHooks.
+func (c *Collector) Hooks() fcache.Hooks {
+	return fcache.Hooks{
+		OnInsert: func(item cache.Item) { c.inserts.Inc() },
+		OnHit:    func(item cache.Item) { c.hits.Inc() },
+		OnMiss:   func(key string) { c.misses.Inc() },
+		OnEvict: func(item cache.Item, reason fcache.EvictReason) {
+			c.evictions.Inc()
+			c.evictedBytes.Add(uint64(item.Size))
+		},
+		OnRetry: func(key string, attempt uint, err error) {
+			c.retries.Inc()
+			if err == policy.ErrNoEmitableCaches {
+				c.noEmitable.Inc()
+			}
+		},
+		OnSetDone: func(key string, elapsed time.Duration, err error) {
+			c.setLatency.Observe(elapsed.Seconds())
+		},
+		OnOnceDone: func(key string, elapsed time.Duration, err error) {
+			c.onceLatency.Observe(elapsed.Seconds())
+		},
+	}
+}
+
+// Watch makes the collector report gauges of the given manager, such as
+// capacity and usage of its cache volume.
+func (c *Collector) Watch(mgr *fcache.Manager) {
+	c.mu.Lock()
+	c.mgr = mgr
+	c.mu.Unlock()
+}
+
+// WriteTo writes all metrics into w in Prometheus text exposition format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	ww := &writer{w: w}
+	ww.counter(c.name("hits_total"), "Number of cache hits.", c.hits.Value())
+	ww.counter(c.name("misses_total"), "Number of cache misses.", c.misses.Value())
+	ww.counter(c.name("inserts_total"), "Number of cache items put into the cache volume.", c.inserts.Value())
+	ww.counter(c.name("evictions_total"), "Number of evicted cache items.", c.evictions.Value())
+	ww.counter(c.name("evicted_bytes_total"), "Total size of evicted cache items.", c.evictedBytes.Value())
+	ww.counter(c.name("put_retries_total"), "Number of failed attempts to put cache items.", c.retries.Value())
+	ww.counter(c.name("no_emitable_caches_total"), "Number of attempts failed due to no emitable caches.", c.noEmitable.Value())
+	ww.histogram(c.name("set_duration_seconds"), "Latency of Set.", c.setLatency)
+	ww.histogram(c.name("once_duration_seconds"), "Latency of Once.", c.onceLatency)
+
+	c.mu.RLock()
+	mgr := c.mgr
+	c.mu.RUnlock()
+	if mgr == nil {
+		return ww.n, ww.err
+	}
+
+	stats, err := mgr.Stats()
+	if err != nil {
+		return ww.n, err
+	}
+
+	ww.gauge(c.name("capacity_bytes"), "Capacity of the cache volume.", float64(stats.Capacity))
+	ww.gauge(c.name("usage_bytes"), "Used space of the cache volume.", float64(stats.Used))
+	ww.gauge(c.name("items"), "Number of cache items in the cache volume.", float64(stats.RealItems+stats.PseudoItems))
+	ww.gauge(c.name("pinned_bytes"), "Total size of referenced cache items.", float64(stats.ReferencedBytes))
+	return ww.n, ww.err
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(buf.Bytes())
+}
+
+func (c *Collector) name(s string) string {
+	return c.ns + "_" + s
+}
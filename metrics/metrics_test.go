@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/meowdada/go-fcache"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/policy"
+)
+
+func TestCollectorServeHTTP(t *testing.T) {
+	c := New(Options{})
+	var userMisses []string
+	mgr := fcache.New(fcache.Options{
+		Capacity:    1000,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		RetryOptions: []retry.Option{
+			retry.Attempts(2),
+			retry.MaxDelay(time.Millisecond),
+			retry.LastErrorOnly(true),
+		},
+		Hooks: fcache.ChainHooks(fcache.Hooks{
+			OnMiss: func(key string) { userMisses = append(userMisses, key) },
+		}, c.Hooks()),
+	})
+	c.Watch(mgr)
+
+	if err := mgr.Set("a", 600); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Register("a")
+	if _, err := mgr.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.Get("b"); err == nil {
+		t.Fatal("expect cache miss, but get no error")
+	}
+	if err := mgr.Set("b", 600); err != policy.ErrNoEmitableCaches {
+		t.Fatalf("expect %v, but get %v", policy.ErrNoEmitableCaches, err)
+	}
+	if len(userMisses) != 1 || userMisses[0] != "b" {
+		t.Errorf("expect user hooks invoked along with the collector, but get %v", userMisses)
+	}
+
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != ContentType {
+		t.Errorf("expect %v, but get %v", ContentType, resp.Header.Get("Content-Type"))
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	expects := []string{
+		"# TYPE fcache_hits_total counter\nfcache_hits_total 1\n",
+		"fcache_misses_total 1\n",
+		"fcache_inserts_total 1\n",
+		"fcache_evictions_total 0\n",
+		"fcache_put_retries_total 2\n",
+		"fcache_no_emitable_caches_total 2\n",
+		"# TYPE fcache_set_duration_seconds histogram\n",
+		"fcache_set_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"fcache_set_duration_seconds_count 2\n",
+		"fcache_once_duration_seconds_count 0\n",
+		"# TYPE fcache_capacity_bytes gauge\nfcache_capacity_bytes 1000\n",
+		"fcache_usage_bytes 600\n",
+		"fcache_items 1\n",
+		"fcache_pinned_bytes 600\n",
+	}
+	for idx, expect := range expects {
+		if !strings.Contains(body, expect) {
+			t.Errorf("[#Case%d]: expect %q in output:\n%s", idx, expect, body)
+		}
+	}
+}
+
+func TestCollectorWithoutManager(t *testing.T) {
+	c := New(Options{Namespace: "test"})
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "test_hits_total 0\n") {
+		t.Errorf("expect namespaced metrics, but get:\n%s", body)
+	}
+	if strings.Contains(body, "test_usage_bytes") {
+		t.Errorf("expect no gauges without a manager, but get:\n%s", body)
+	}
+}
@@ -0,0 +1,127 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/pkg/errors"
+)
+
+// maxLine is the maximum length of a line of JSON lines.
+const maxLine = 16 << 20
+
+// Record is a line of JSON lines, which describes a cache item stored by
+// its key.
+type Record struct {
+	Key       string         `json:"key"`
+	ID        int64          `json:"id"`
+	Path      string         `json:"path"`
+	Size      int64          `json:"size"`
+	Ref       int            `json:"ref"`
+	Used      int            `json:"used"`
+	Real      bool           `json:"real"`
+	CreatedAt time.Time      `json:"created_at"`
+	LastUsed  time.Time      `json:"last_used"`
+	Metadata  cache.Metadata `json:"metadata,omitempty"`
+}
+
+// NewRecord describes a cache item stored by the key.
+func NewRecord(key string, item cache.Item) Record {
+	return Record{
+		Key:       key,
+		ID:        item.ID,
+		Path:      item.Path,
+		Size:      item.Size,
+		Ref:       item.Ref,
+		Used:      item.Used,
+		Real:      item.Real,
+		CreatedAt: item.CreatedAt,
+		LastUsed:  item.LastUsed,
+		Metadata:  item.Metadata,
+	}
+}
+
+// Item returns the cache item described by the record.
+func (r Record) Item() cache.Item {
+	return cache.Item{
+		ID:        r.ID,
+		Key:       r.Key,
+		Path:      r.Path,
+		Size:      r.Size,
+		Ref:       r.Ref,
+		Used:      r.Used,
+		Real:      r.Real,
+		CreatedAt: r.CreatedAt,
+		LastUsed:  r.LastUsed,
+		Metadata:  r.Metadata,
+	}
+}
+
+// Export writes every record of src to w as JSON lines, one Record per line,
+// in ascending order of keys if the store implements backend.Scanner.
+func Export(w io.Writer, src Endpoint, opts Options) (report Report, err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err = iter(src.Store, func(k, v []byte) error {
+		report.Scanned++
+		var item cache.Item
+		if err := src.Codec.Unmarshal(v, &item); err != nil {
+			if !opts.SkipCorrupt {
+				return &backend.DecodeError{Key: string(k), Err: err}
+			}
+			report.Corrupted = append(report.Corrupted, string(k))
+			return nil
+		}
+		if err := enc.Encode(NewRecord(string(k), item)); err != nil {
+			return err
+		}
+		report.Written++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, bw.Flush()
+}
+
+// Import reads JSON lines written by Export from r, and writes the records
+// into dst, then verifies the number of records in dst like Migrate. Blank
+// lines are ignored. Lines unable to be parsed are reported as corrupted by
+// their line numbers if opts.SkipCorrupt is set.
+func Import(dst Endpoint, r io.Reader, opts Options) (report Report, err error) {
+	w, err := newWriter(dst, opts)
+	if err != nil {
+		return report, err
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxLine)
+	for line := 1; sc.Scan(); line++ {
+		data := bytes.TrimSpace(sc.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		report.Scanned++
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			if !opts.SkipCorrupt {
+				return report, errors.Wrapf(err, "line %d", line)
+			}
+			report.Corrupted = append(report.Corrupted, fmt.Sprintf("line %d", line))
+			continue
+		}
+		if err := w.put(&report, []byte(rec.Key), rec.Item()); err != nil {
+			return report, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return report, err
+	}
+	return report, w.verify()
+}
@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+)
+
+func TestExportImport(t *testing.T) {
+	src := Endpoint{gomap.New(), codec.Gob{}}
+	labeled := item(2, "b")
+	labeled.Metadata = cache.Metadata{cache.MetaContentType: "text/plain", "label": "x"}
+	fill(t, src, labeled, item(1, "a"))
+	src.Store.Put([]byte("c"), []byte("corrupted"))
+
+	var buf bytes.Buffer
+	if _, err := Export(&buf, src, Options{}); err == nil {
+		t.Fatal("expect error occurs, but get no error")
+	}
+	buf.Reset()
+	report, err := Export(&buf, src, Options{SkipCorrupt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Report{Scanned: 3, Written: 2, Corrupted: []string{"c"}}
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("expect %+v, but get %+v", expect, report)
+	}
+
+	// Records are sorted by keys since gomap implements backend.Scanner.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"key":"a","id":1,`) {
+		t.Fatalf("expect 2 sorted lines, but get %q", lines)
+	}
+
+	dst := Endpoint{gomap.New(), codec.XML{}}
+	report, err = Import(dst, &buf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = Report{Scanned: 2, Written: 2}
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("expect %+v, but get %+v", expect, report)
+	}
+	for _, want := range []cache.Item{item(1, "a"), labeled} {
+		if got := load(t, dst, want.Key); !reflect.DeepEqual(got, want) {
+			t.Errorf("expect %+v, but get %+v", want, got)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	input := `{"key":"a","id":1,"real":true}
+
+not json
+{"key":"b","id":2}
+`
+	testcases := []struct {
+		description string
+		opts        Options
+		expectErr   string
+		expect      Report
+	}{
+		{
+			"bad lines fail",
+			Options{},
+			"line 3",
+			Report{Scanned: 2, Written: 1},
+		},
+		{
+			"bad lines are skipped",
+			Options{SkipCorrupt: true},
+			"",
+			Report{Scanned: 3, Written: 2, Corrupted: []string{"line 3"}},
+		},
+	}
+
+	for idx, tc := range testcases {
+		dst := Endpoint{gomap.New(), codec.JSON{}}
+		report, err := Import(dst, strings.NewReader(input), tc.opts)
+		if tc.expectErr == "" && err != nil || tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)) {
+			t.Errorf("[#Case%d] %s: expect error %q, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+		if !reflect.DeepEqual(report, tc.expect) {
+			t.Errorf("[#Case%d] %s: expect %+v, but get %+v", idx, tc.description, tc.expect, report)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package migrate copies cache records between metadata stores, which might
+// be encoded by different codecs, so a cache survives switching its backend
+// or codec. Records could also be exported to and imported from JSON lines,
+// a portable format independent of backends and codecs.
+package migrate
+
+import (
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+// ErrCountMismatch raises when the number of records in the destination
+// store does not match the number of records written after a migration.
+var ErrCountMismatch = errors.New("record count mismatch")
+
+// Endpoint is a store along with the codec of its records.
+type Endpoint struct {
+	Store backend.Store
+	Codec codec.Codec
+}
+
+// Options configures Migrate and Import.
+type Options struct {
+	// Overwrite replaces records which already present in the destination.
+	// Otherwise, they are kept and reported as conflicts.
+	Overwrite bool
+
+	// SkipCorrupt skips source records which are unable to be decoded, and
+	// reports them as corrupted. Otherwise, the migration fails on them.
+	SkipCorrupt bool
+}
+
+// Report reports the result of a migration.
+type Report struct {
+	// Scanned is the number of source records scanned.
+	Scanned int
+
+	// Written is the number of records written into the destination.
+	Written int
+
+	// Corrupted are the keys of source records skipped since they are
+	// unable to be decoded, or the line numbers of such JSON lines.
+	Corrupted []string
+
+	// Conflicts are the keys of records skipped since they already present
+	// in the destination.
+	Conflicts []string
+}
+
+// Migrate streams every record from src to dst, re-encoding them with the
+// codec of dst, and verifies the number of records in dst afterwards. Since
+// records are written while src is being iterated, dst must not share the
+// same database with src, such as two namespaces of a boltDB. Neither of
+// them should be used by a cache manager during the migration.
+func Migrate(dst, src Endpoint, opts Options) (report Report, err error) {
+	w, err := newWriter(dst, opts)
+	if err != nil {
+		return report, err
+	}
+	err = iter(src.Store, func(k, v []byte) error {
+		report.Scanned++
+		var item cache.Item
+		if err := src.Codec.Unmarshal(v, &item); err != nil {
+			if !opts.SkipCorrupt {
+				return &backend.DecodeError{Key: string(k), Err: err}
+			}
+			report.Corrupted = append(report.Corrupted, string(k))
+			return nil
+		}
+		return w.put(&report, k, item)
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, w.verify()
+}
+
+// iter iterates records of a store in ascending order of keys if the store
+// implements backend.Scanner, or in the order of the store otherwise.
+func iter(store backend.Store, fn func(k, v []byte) error) error {
+	if scanner, ok := store.(backend.Scanner); ok {
+		return scanner.Scan(nil, fn)
+	}
+	return store.Iter(fn)
+}
+
+// count returns the number of records in a store.
+func count(store backend.Store) (n int, err error) {
+	err = store.Iter(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// writer writes records into the destination, and tracks the number of
+// records it is expected to hold.
+type writer struct {
+	dst    Endpoint
+	opts   Options
+	expect int
+}
+
+func newWriter(dst Endpoint, opts Options) (*writer, error) {
+	n, err := count(dst.Store)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{dst: dst, opts: opts, expect: n}, nil
+}
+
+func (w *writer) put(report *Report, k []byte, item cache.Item) error {
+	_, err := w.dst.Store.Get(k)
+	exist := err == nil
+	if err != nil && !backend.IsNoKeyError(err) {
+		return err
+	}
+	if exist && !w.opts.Overwrite {
+		report.Conflicts = append(report.Conflicts, string(k))
+		return nil
+	}
+
+	v, err := w.dst.Codec.Marshal(item)
+	if err != nil {
+		return &backend.EncodeError{Key: string(k), Err: err}
+	}
+	// k is only valid during the iteration of the source, such as a key of
+	// a memory-mapped boltDB, so copy it for stores keeping it.
+	if err := w.dst.Store.Put(append([]byte{}, k...), v); err != nil {
+		return err
+	}
+	report.Written++
+	if !exist {
+		w.expect++
+	}
+	return nil
+}
+
+func (w *writer) verify() error {
+	n, err := count(w.dst.Store)
+	if err != nil {
+		return err
+	}
+	if n != w.expect {
+		return errors.Wrapf(ErrCountMismatch, "expect %d records, but get %d", w.expect, n)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/pkg/errors"
+)
+
+func item(id int64, key string) cache.Item {
+	it := cache.New(id, key, id*10)
+	it.Ref = int(id)
+	it.Used = int(id) + 1
+	it.CreatedAt = time.Unix(1600000000, id).UTC()
+	it.LastUsed = time.Unix(1600000100, id).UTC()
+	return it
+}
+
+func fill(t *testing.T, ep Endpoint, items ...cache.Item) {
+	for _, it := range items {
+		v, err := ep.Codec.Marshal(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ep.Store.Put([]byte(it.Key), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func load(t *testing.T, ep Endpoint, key string) cache.Item {
+	v, err := ep.Store.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("expect %s present, but get %v", key, err)
+	}
+	var it cache.Item
+	if err := ep.Codec.Unmarshal(v, &it); err != nil {
+		t.Fatal(err)
+	}
+	return it
+}
+
+func TestMigrate(t *testing.T) {
+	testcases := []struct {
+		description string
+		opts        Options
+		corrupt     bool
+		expectErr   bool
+		expect      Report
+		expectB     int64
+	}{
+		{
+			"conflicts are kept",
+			Options{},
+			false,
+			false,
+			Report{Scanned: 2, Written: 1, Conflicts: []string{"b"}},
+			100,
+		},
+		{
+			"conflicts are overwritten",
+			Options{Overwrite: true},
+			false,
+			false,
+			Report{Scanned: 2, Written: 2},
+			2,
+		},
+		{
+			"corrupted records fail",
+			Options{},
+			true,
+			true,
+			Report{Scanned: 1, Written: 1},
+			100,
+		},
+		{
+			"corrupted records are skipped",
+			Options{SkipCorrupt: true},
+			true,
+			false,
+			Report{Scanned: 3, Written: 1, Corrupted: []string{"c"}, Conflicts: []string{"b"}},
+			100,
+		},
+	}
+
+	for idx, tc := range testcases {
+		src := Endpoint{gomap.New(), codec.Gob{}}
+		dst := Endpoint{gomap.New(), codec.JSON{}}
+		fill(t, src, item(1, "a"), item(2, "b"))
+		fill(t, dst, item(100, "b"))
+		if tc.corrupt {
+			src.Store.Put([]byte("c"), []byte("corrupted"))
+		}
+
+		report, err := Migrate(dst, src, tc.opts)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect error %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+		if tc.expectErr {
+			if !backend.IsDecodeError(err) {
+				t.Errorf("[#Case%d] %s: expect a decode error, but get %v", idx, tc.description, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(report, tc.expect) {
+			t.Errorf("[#Case%d] %s: expect %+v, but get %+v", idx, tc.description, tc.expect, report)
+		}
+		if got := load(t, dst, "a"); !reflect.DeepEqual(got, item(1, "a")) {
+			t.Errorf("[#Case%d] %s: expect %+v, but get %+v", idx, tc.description, item(1, "a"), got)
+		}
+		if got := load(t, dst, "b"); got.ID != tc.expectB {
+			t.Errorf("[#Case%d] %s: expect ID %d, but get %d", idx, tc.description, tc.expectB, got.ID)
+		}
+	}
+}
+
+// lossy drops every record written into it.
+type lossy struct {
+	*gomap.Map
+}
+
+func (l lossy) Put(k, v []byte) error { return nil }
+
+func TestMigrateCountMismatch(t *testing.T) {
+	src := Endpoint{gomap.New(), codec.Gob{}}
+	fill(t, src, item(1, "a"))
+	dst := Endpoint{lossy{gomap.New()}, codec.Gob{}}
+
+	_, err := Migrate(dst, src, Options{})
+	if errors.Cause(err) != ErrCountMismatch {
+		t.Errorf("expect %v, but get %v", ErrCountMismatch, err)
+	}
+}
@@ -1,9 +1,12 @@
 package fcache
 
 import (
+	"time"
+
 	retry "github.com/avast/retry-go"
 	"github.com/meowdada/go-fcache/backend"
 	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/pkg/log"
 	"github.com/meowdada/go-fcache/policy"
 )
 
@@ -14,4 +17,23 @@ type Options struct {
 	Backend      backend.Store
 	CachePolicy  policy.Policy
 	RetryOptions []retry.Option
+	Hooks        Hooks
+
+	// Logger records what the manager, the backend adapter and the cache
+	// policy do. The policy is wrapped with policy.Logged, unless it has its
+	// own logger set by policy.WithLogger.
+	Logger log.Logger
+
+	// CorruptStrategy decides how to handle records of the backend which are
+	// unable to be decoded. If Quarantine is set, corrupted records will be
+	// moved into it and the strategy is ignored.
+	CorruptStrategy backend.CorruptStrategy
+	Quarantine      backend.Store
+
+	// PseudoTTL makes pseudo cache items, which are referenced but never set,
+	// removed after the duration since they are last registered or
+	// unregistered, even if they are still referenced, so speculative
+	// registrations do not pile up. Hooks.OnExpire is invoked with each of
+	// them. It takes effect only if the backend implements backend.Expirer.
+	PseudoTTL time.Duration
 }
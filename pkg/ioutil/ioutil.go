@@ -1,6 +1,9 @@
 package ioutil
 
-import "unsafe"
+import (
+	"os"
+	"unsafe"
+)
 
 // Str2Bytes converts a string to byte array with better performance.
 func Str2Bytes(s string) []byte {
@@ -11,3 +14,14 @@ func Str2Bytes(s string) []byte {
 func Bytes2Str(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
 }
+
+// SyncDir flushes a directory so that a rename in it is durable. It is
+// best-effort since not every platform supports it.
+func SyncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
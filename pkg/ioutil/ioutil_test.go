@@ -2,6 +2,7 @@ package ioutil
 
 import (
 	"bytes"
+	"os"
 	"strings"
 	"testing"
 )
@@ -41,3 +42,10 @@ func TestBytes2Str(t *testing.T) {
 		t.Errorf("expect %v, but get %v", rhs, lhs)
 	}
 }
+
+func TestSyncDir(t *testing.T) {
+	// It is best-effort, so neither an existing nor a missing directory
+	// should panic.
+	SyncDir(os.TempDir())
+	SyncDir("/path/not/exist")
+}
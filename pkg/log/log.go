@@ -0,0 +1,107 @@
+// Package log provides a leveled, structured logger interface which could be
+// injected into the cache manager, its backend adapter and policies.
+package log
+
+import (
+	"fmt"
+	stdlog "log"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	// Debug level logs are verbose details for debugging.
+	Debug Level = iota
+	// Info level logs are routine events, such as evictions.
+	Info
+	// Warn level logs are unexpected but recoverable events, such as retries.
+	Warn
+	// Error level logs are failures.
+	Error
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a field with given key and value.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err creates a field for an error.
+func Err(err error) Field {
+	return F("error", err)
+}
+
+// Logger records log entries with structured fields.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Nop returns a logger which discards all log entries.
+func Nop() Logger {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Log(Level, string, ...Field) {}
+
+// Std adapts a logger of the standard library. Log entries with lower level
+// than min will be discarded. Fields are formatted as key=value pairs.
+func Std(logger *stdlog.Logger, min Level) Logger {
+	return std{logger: logger, min: min}
+}
+
+type std struct {
+	logger *stdlog.Logger
+	min    Level
+}
+
+func (s std) Log(level Level, msg string, fields ...Field) {
+	if level < s.min {
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "level=%s msg=%s", level, quote(msg))
+	for _, f := range fields {
+		fmt.Fprintf(&sb, " %s=%s", f.Key, quote(fmt.Sprint(f.Value)))
+	}
+	s.logger.Print(sb.String())
+}
+
+// quote quotes s if it is empty or contains spaces, quotes or equal signs.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// OrNop returns the given logger, or a no-op logger if it is nil.
+func OrNop(logger Logger) Logger {
+	if logger == nil {
+		return Nop()
+	}
+	return logger
+}
@@ -0,0 +1,80 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	stdlog "log"
+	"testing"
+)
+
+func TestLevelString(t *testing.T) {
+	testcases := []struct {
+		level  Level
+		expect string
+	}{
+		{Debug, "debug"},
+		{Info, "info"},
+		{Warn, "warn"},
+		{Error, "error"},
+		{Level(100), "unknown"},
+	}
+
+	for idx, tc := range testcases {
+		if tc.level.String() != tc.expect {
+			t.Errorf("[#Case%d]: expect %v, but get %v", idx, tc.expect, tc.level.String())
+		}
+	}
+}
+
+func TestStd(t *testing.T) {
+	testcases := []struct {
+		description string
+		level       Level
+		msg         string
+		fields      []Field
+		expect      string
+	}{
+		{
+			"discard lower level",
+			Debug,
+			"debug message",
+			nil,
+			"",
+		},
+		{
+			"without fields",
+			Info,
+			"evict",
+			nil,
+			"level=info msg=evict\n",
+		},
+		{
+			"with fields",
+			Error,
+			"failed to remove cache file",
+			[]Field{F("key", "a b"), F("size", 10), F("empty", ""), Err(errors.New("x=y"))},
+			"level=error msg=\"failed to remove cache file\" key=\"a b\" size=10 empty=\"\" error=\"x=y\"\n",
+		},
+	}
+
+	for idx, tc := range testcases {
+		var buf bytes.Buffer
+		logger := Std(stdlog.New(&buf, "", 0), Info)
+		logger.Log(tc.level, tc.msg, tc.fields...)
+		if buf.String() != tc.expect {
+			t.Errorf("[#Case%d] %s: expect %q, but get %q", idx, tc.description, tc.expect, buf.String())
+		}
+	}
+}
+
+func TestOrNop(t *testing.T) {
+	if _, ok := OrNop(nil).(nop); !ok {
+		t.Errorf("expect a no-op logger for nil")
+	}
+
+	logger := Std(stdlog.New(&bytes.Buffer{}, "", 0), Debug)
+	if OrNop(logger) != logger {
+		t.Errorf("expect the given logger to be returned")
+	}
+	Nop().Log(Error, "nothing happens")
+}
@@ -14,7 +14,7 @@ type fifo struct {
 // FIFO returns a FIFO (first-in-first-out) cache replacement policy instance.
 func FIFO(opts ...Option) Policy {
 	opt := combine(opts...)
-	return fifo{validator: opt.Validate}
+	return opt.withLogging("fifo", fifo{validator: opt.Validate})
 }
 
 // Evict implements FIFO cache replacement policy.
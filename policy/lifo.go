@@ -14,7 +14,7 @@ type lifo struct {
 // LIFO returns a LIFO (last-in-first-out) cache replacement policy instance.
 func LIFO(opts ...Option) Policy {
 	opt := combine(opts...)
-	return lifo{validator: opt.Validate}
+	return opt.withLogging("lifo", lifo{validator: opt.Validate})
 }
 
 // Emit implements LIFO cache replacement policy.
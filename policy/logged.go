@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/log"
+)
+
+// logged decorates a cache replacement policy with logging.
+type logged struct {
+	Policy
+	name   string
+	logger log.Logger
+}
+
+// withLogging wraps the policy with logging if a logger has been set.
+func (opts *validateOption) withLogging(name string, p Policy) Policy {
+	if opts.Logger == nil {
+		return p
+	}
+	return logged{Policy: p, name: name, logger: opts.Logger}
+}
+
+// Logged wraps the cache replacement policy to record its victims and
+// failures with the given logger, unless it is nil or the policy has been
+// created with WithLogger.
+func Logged(p Policy, logger log.Logger) Policy {
+	if _, ok := p.(logged); ok || p == nil || logger == nil {
+		return p
+	}
+	return logged{Policy: p, name: nameOf(p), logger: logger}
+}
+
+// nameOf returns the name of a builtin policy, or its type otherwise.
+func nameOf(p Policy) string {
+	switch p.(type) {
+	case lru:
+		return "lru"
+	case mru:
+		return "mru"
+	case fifo:
+		return "fifo"
+	case lifo:
+		return "lifo"
+	case rr:
+		return "rr"
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// Evict implements policy interface.
+func (l logged) Evict(pool cache.Pool) (cache.Item, error) {
+	victim, err := l.Policy.Evict(pool)
+	switch {
+	case err == ErrNoEmitableCaches:
+		l.logger.Log(log.Debug, "no emitable caches", log.F("policy", l.name))
+	case err != nil:
+		l.logger.Log(log.Error, "failed to pick a victim", log.F("policy", l.name), log.Err(err))
+	default:
+		l.logger.Log(log.Debug, "pick a victim", log.F("policy", l.name),
+			log.F("key", victim.Key), log.F("size", victim.Size))
+	}
+	return victim, err
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/pkg/log"
+	"github.com/pkg/errors"
+)
+
+type recorder struct {
+	msgs []string
+}
+
+func (r *recorder) Log(level log.Level, msg string, fields ...log.Field) {
+	r.msgs = append(r.msgs, msg)
+}
+
+func TestWithLogger(t *testing.T) {
+	errMock := errors.New("mock error")
+
+	testcases := []struct {
+		description string
+		pool        func() cache.Pool
+		expectMsg   string
+	}{
+		{
+			"pick a victim",
+			func() cache.Pool {
+				pool := backend.Adapter(gomap.New(), codec.Gob{})
+				pool.Put("123", 456)
+				pool.IncrRef("123")
+				pool.DecrRef("123")
+				return pool
+			},
+			"pick a victim",
+		},
+		{
+			"no emitable caches",
+			func() cache.Pool {
+				return backend.Adapter(gomap.New(), codec.Gob{})
+			},
+			"no emitable caches",
+		},
+		{
+			"failed to pick a victim",
+			func() cache.Pool {
+				return backend.Adapter(backend.Mock{
+					IterHandler: func(func(k, v []byte) error) error { return errMock },
+				}, codec.Gob{})
+			},
+			"failed to pick a victim",
+		},
+	}
+
+	ctors := []func(opts ...Option) Policy{LRU, MRU, FIFO, LIFO, RR}
+	for idx, tc := range testcases {
+		for _, ctor := range ctors {
+			rec := &recorder{}
+			ctor(WithLogger(rec)).Evict(tc.pool())
+			if len(rec.msgs) != 1 || rec.msgs[0] != tc.expectMsg {
+				t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectMsg, rec.msgs)
+			}
+		}
+	}
+
+	if _, ok := LRU().(lru); !ok {
+		t.Errorf("expect no logging decorator without a logger")
+	}
+}
+
+func TestLogged(t *testing.T) {
+	pool := func() cache.Pool {
+		pool := backend.Adapter(gomap.New(), codec.Gob{})
+		pool.Put("123", 456)
+		return pool
+	}
+
+	rec, own := &recorder{}, &recorder{}
+	testcases := []struct {
+		description string
+		policy      Policy
+		expectRec   int
+		expectOwn   int
+	}{
+		{"builtin policy", LRU(), 1, 0},
+		{"custom policy", Mock{EvictFn: LRU().Evict}, 1, 0},
+		{"policy with its own logger", RR(WithLogger(own)), 0, 1},
+	}
+
+	for idx, tc := range testcases {
+		rec.msgs, own.msgs = nil, nil
+		Logged(tc.policy, rec).Evict(pool())
+		if len(rec.msgs) != tc.expectRec || len(own.msgs) != tc.expectOwn {
+			t.Errorf("[#Case%d] %s: expect %d and %d logs, but get %v and %v",
+				idx, tc.description, tc.expectRec, tc.expectOwn, rec.msgs, own.msgs)
+		}
+	}
+
+	if _, ok := Logged(LRU(), nil).(lru); !ok {
+		t.Errorf("expect the policy kept without a logger")
+	}
+}
@@ -14,7 +14,7 @@ type lru struct {
 // LRU returns a LRU (least recenctly used) cache replacement policy instance.
 func LRU(opts ...Option) Policy {
 	opt := combine(opts...)
-	return lru{validator: opt.Validate}
+	return opt.withLogging("lru", lru{validator: opt.Validate})
 }
 
 // Emit implements LRU cache replacement policy.
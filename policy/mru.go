@@ -14,7 +14,7 @@ type mru struct {
 // MRU returns a MRU (most-recently-used) cache replacement policy instance.
 func MRU(opts ...Option) Policy {
 	opt := combine(opts...)
-	return mru{validator: opt.Validate}
+	return opt.withLogging("mru", mru{validator: opt.Validate})
 }
 
 // Emit implements MRU cache replacement policy.
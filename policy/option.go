@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/log"
 )
 
 type validateOption struct {
@@ -12,6 +13,7 @@ type validateOption struct {
 	MinUsed         int
 	MinLiveTime     time.Duration
 	LastUsed        time.Duration
+	Logger          log.Logger
 }
 
 func newValidateOption() *validateOption {
@@ -141,3 +143,17 @@ func (l lastUsed) setValidateOption(opts *validateOption) {
 func LastUsed(duration time.Duration) Option {
 	return lastUsed{duration}
 }
+
+type withLogger struct {
+	logger log.Logger
+}
+
+func (w withLogger) setValidateOption(opts *validateOption) {
+	opts.Logger = w.logger
+}
+
+// WithLogger returns a cache policy option that makes the cache replacement
+// policy record its victims and failures with the given logger.
+func WithLogger(logger log.Logger) Option {
+	return withLogger{logger}
+}
@@ -13,9 +13,9 @@ type rr struct {
 // RR returns a RR (random replacement) cache replacement policy instance.
 func RR(opts ...Option) Policy {
 	opt := combine(opts...)
-	return rr{
+	return opt.withLogging("rr", rr{
 		validator: opt.Validate,
-	}
+	})
 }
 
 // Evict implements RR cache replacement policy. It will iterates the
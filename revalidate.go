@@ -0,0 +1,308 @@
+package fcache
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	retry "github.com/avast/retry-go"
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/pkg/log"
+)
+
+// RevalidateOptions configures Manager.Revalidate.
+type RevalidateOptions struct {
+	// MaxAge is how long a cache item stays fresh after it has been
+	// validated, during which it is returned without revalidation.
+	MaxAge time.Duration
+
+	// StaleWhileRevalidate is how long a stale cache item could still be
+	// returned, while it is revalidated in the background. Beyond it, the
+	// cache item is revalidated before it is returned.
+	StaleWhileRevalidate time.Duration
+}
+
+// Revalidation is the result of revalidating a cache item against its origin.
+type Revalidation struct {
+	// NotModified reports that the cache item is still current, so only its
+	// freshness is refreshed. Its validators are kept unless new ones are set.
+	NotModified bool
+
+	// Validators identify the version of the object in the origin.
+	Validators cache.Validators
+
+	// Metadata is merged into the cache item along with validators.
+	Metadata cache.Metadata
+
+	// File is a temporary file holding the new content, which replaces the
+	// file of the cache item by renaming, so it must be on the same file
+	// system. If it is empty, the new content is assumed to have been written
+	// to the path of the cache item, which is the key for a new one.
+	File string
+
+	// Size is the size of the new content.
+	Size int64
+}
+
+// RevalidateHandler revalidates a cache item against its origin, usually by
+// a conditional request with the validators of the cache item. If found is
+// false, the key is not cached and the content must be fetched.
+type RevalidateHandler func(item cache.Item, found bool) (Revalidation, error)
+
+// Revalidate gets a cache item which copies an object from its origin. A
+// fresh cache item is returned immediately. A stale one within the stale
+// while revalidate period is returned as well, but revalidated by fn in the
+// background. Otherwise, including cache items which have never been
+// validated, it is revalidated by fn before it is returned. Revalidations of
+// the same key are never run concurrently, and callers waiting for the same
+// key share the result.
+//
+// If the content is modified, the file is replaced atomically by renaming,
+// then the cache item is replaced with the new size and validators, which
+// evicts other cache items if more space is needed, but never the cache item
+// itself. If the key has been cached by others meanwhile, such as by Set or
+// Once, backend.ErrDupKey is returned and their file is kept.
+func (mgr *Manager) Revalidate(key string, opts RevalidateOptions, fn RevalidateHandler) (item cache.Item, err error) {
+	mgr.rlockFn(func() {
+		item, err = mgr.pool.Get(key)
+	})
+	mgr.lookup(key, item, err)
+	if err != nil && !backend.IsNoKeyError(err) {
+		return item, err
+	}
+
+	if err == nil && item.IsReal() && !item.ValidatedAt().IsZero() {
+		switch age := time.Since(item.ValidatedAt()); {
+		case age <= opts.MaxAge:
+			return item, nil
+		case age <= opts.MaxAge+opts.StaleWhileRevalidate:
+			go func() {
+				if _, err := mgr.revalidate(key, fn); err != nil {
+					mgr.logger.Log(log.Warn, "failed to revalidate cache item in background",
+						log.F("key", key), log.Err(err))
+				}
+			}()
+			return item, nil
+		}
+	}
+	return mgr.revalidate(key, fn)
+}
+
+func (mgr *Manager) revalidate(key string, fn RevalidateHandler) (cache.Item, error) {
+	return mgr.flights.do(key, func() (item cache.Item, err error) {
+		// The cache item might have been revalidated by another flight.
+		mgr.rlockFn(func() {
+			item, err = mgr.pool.Get(key)
+		})
+		if err != nil && !backend.IsNoKeyError(err) {
+			return item, err
+		}
+		found := err == nil && item.IsReal()
+
+		rv, err := fn(item, found)
+		switch {
+		case err != nil:
+		case rv.NotModified && !found:
+			err = ErrNotCached
+		case rv.NotModified:
+			err = mgr.Annotate(key, rv.metadata())
+		case rv.Size > mgr.cap:
+			err = ErrCacheTooLarge
+		case found:
+			err = mgr.replace(key, rv)
+		default:
+			err = mgr.insert(key, rv)
+		}
+		if err != nil {
+			// A file at the key might belong to others, see insert.
+			if rv.File != key {
+				mgr.discard(rv.File)
+			}
+			return cache.Item{}, err
+		}
+
+		mgr.rlockFn(func() {
+			item, err = mgr.pool.Get(key)
+		})
+		if err != nil {
+			return item, err
+		}
+		mgr.logger.Log(log.Info, "revalidate cache item",
+			log.F("key", key), log.F("modified", !rv.NotModified))
+		mgr.hooks.revalidate(item, !rv.NotModified)
+		return item, nil
+	})
+}
+
+// insert inserts new content as a cache item. The file is moved into place
+// right before the cache item is put with the manager locked, so the cache
+// item is never seen without it, and a key cached by others meanwhile is
+// never overwritten.
+func (mgr *Manager) insert(key string, rv Revalidation) error {
+	md := rv.metadata()
+	return mgr.retryLocked(key, rv.Size, func(evs *events) error {
+		old, err := mgr.pool.Get(key)
+		if err == nil && old.IsReal() {
+			return retry.Unrecoverable(backend.ErrDupKey)
+		}
+		if err != nil && !backend.IsNoKeyError(err) {
+			return err
+		}
+		if mgr.usage+rv.Size > mgr.cap {
+			if err := mgr.evict(evs, ""); err != nil {
+				return err
+			}
+			if mgr.usage+rv.Size > mgr.cap {
+				return errRetry
+			}
+		}
+
+		if err := mgr.install(rv.File, key); err != nil {
+			return err
+		}
+		// The file at the key is ours now, and it is not going to be moved
+		// into place again, so do not try again.
+		if err := mgr.put(key, rv.Size, md, evs); err != nil {
+			mgr.discard(key)
+			return retry.Unrecoverable(err)
+		}
+		return nil
+	})
+}
+
+// replace replaces a cache item with new content. The cache item is excluded
+// from eviction for its own new content, without touching its usage.
+func (mgr *Manager) replace(key string, rv Revalidation) error {
+	replacer, ok := mgr.pool.(cache.Replacer)
+	if !ok {
+		return ErrNoReplace
+	}
+
+	md := rv.metadata()
+	return mgr.retryLocked(key, rv.Size, func(evs *events) error {
+		old, err := mgr.pool.Get(key)
+		if err != nil {
+			return err
+		}
+		delta := rv.Size - old.Size
+		if mgr.usage+delta > mgr.cap {
+			if err := mgr.evict(evs, key); err != nil {
+				return err
+			}
+			if mgr.usage+delta > mgr.cap {
+				return errRetry
+			}
+		}
+
+		// The record is replaced before the file, so nothing is changed if
+		// it fails. If the file fails to be moved into place then, the record
+		// is replaced back, or it is removed along with the old content as
+		// the last resort, since its validators no longer match the content.
+		if err := replacer.Replace(key, rv.Size, md); err != nil {
+			mgr.logger.Log(log.Error, "failed to replace cache item",
+				log.F("key", key), log.Err(err))
+			return err
+		}
+		mgr.usage += delta
+		if err := mgr.install(rv.File, old.Path); err != nil {
+			if uerr := replacer.Replace(key, old.Size, undoMetadata(old.Metadata, md)); uerr != nil {
+				mgr.logger.Log(log.Error, "failed to restore replaced cache item",
+					log.F("key", key), log.Err(uerr))
+				// Failures are logged by remove, the record is kept along
+				// with its usage then.
+				mgr.remove([]string{key}, evs)
+				return retry.Unrecoverable(err)
+			}
+			mgr.usage -= delta
+			return err
+		}
+		return nil
+	})
+}
+
+// undoMetadata returns the metadata which turns old merged with md back into
+// old when merged.
+func undoMetadata(old, md cache.Metadata) cache.Metadata {
+	undo := make(cache.Metadata, len(md))
+	for k := range md {
+		undo[k] = old[k]
+	}
+	return undo
+}
+
+// install moves a temporary file to the path atomically.
+func (mgr *Manager) install(file, path string) error {
+	if file == "" || file == path {
+		return nil
+	}
+	err := os.Rename(file, path)
+	if err != nil {
+		mgr.logger.Log(log.Error, "failed to install cache file",
+			log.F("file", file), log.F("path", path), log.Err(err))
+	}
+	return err
+}
+
+// discard removes a file which is not going to be cached.
+func (mgr *Manager) discard(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		mgr.logger.Log(log.Warn, "failed to discard file",
+			log.F("path", path), log.Err(err))
+	}
+}
+
+// metadata returns metadata to be merged into the revalidated cache item.
+func (rv Revalidation) metadata() cache.Metadata {
+	item := cache.Item{Metadata: make(cache.Metadata, len(rv.Metadata)+4)}
+	for k, v := range rv.Metadata {
+		item.Metadata[k] = v
+	}
+	if !rv.NotModified || !rv.Validators.IsZero() {
+		for k, v := range rv.Validators.Metadata() {
+			item.Metadata[k] = v
+		}
+	}
+	item.SetValidatedAt(time.Now())
+	return item.Metadata
+}
+
+// flights runs a function at most once at a time for each key, and callers
+// of the same key share its result.
+type flights struct {
+	m  map[string]*flight
+	mu sync.Mutex
+}
+
+type flight struct {
+	done chan struct{}
+	item cache.Item
+	err  error
+}
+
+func (fs *flights) do(key string, fn func() (cache.Item, error)) (cache.Item, error) {
+	fs.mu.Lock()
+	if f, ok := fs.m[key]; ok {
+		fs.mu.Unlock()
+		<-f.done
+		return f.item, f.err
+	}
+	if fs.m == nil {
+		fs.m = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	fs.m[key] = f
+	fs.mu.Unlock()
+
+	defer func() {
+		fs.mu.Lock()
+		delete(fs.m, key)
+		fs.mu.Unlock()
+		close(f.done)
+	}()
+	f.item, f.err = fn()
+	return f.item, f.err
+}
@@ -0,0 +1,457 @@
+package fcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/policy"
+	"github.com/pkg/errors"
+)
+
+// origin is a fake origin store serving an object, which counts requests.
+type origin struct {
+	dir      string
+	content  string
+	etag     string
+	requests int32
+}
+
+// handler serves the object like a conditional GET of HTTP, writing the
+// content into a temporary file.
+func (o *origin) handler(item cache.Item, found bool) (Revalidation, error) {
+	atomic.AddInt32(&o.requests, 1)
+	v := cache.Validators{ETag: o.etag}
+	if found && item.Validators().ETag == o.etag {
+		return Revalidation{NotModified: true}, nil
+	}
+	f, err := ioutil.TempFile(o.dir, "tmp-")
+	if err != nil {
+		return Revalidation{}, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(o.content); err != nil {
+		return Revalidation{}, err
+	}
+	return Revalidation{
+		Validators: v,
+		Metadata:   cache.Metadata{cache.MetaContentType: "text/plain"},
+		File:       f.Name(),
+		Size:       int64(len(o.content)),
+	}, nil
+}
+
+func (o *origin) count() int {
+	return int(atomic.LoadInt32(&o.requests))
+}
+
+func newRevalidateManager(t *testing.T, capacity int64, hooks Hooks) (*Manager, *origin, func()) {
+	dir, err := ioutil.TempDir("", "fcache-revalidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := New(Options{
+		Capacity:    capacity,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		RetryOptions: []retry.Option{
+			retry.MaxDelay(time.Millisecond),
+			retry.Attempts(3),
+			retry.LastErrorOnly(true),
+		},
+		Hooks: hooks,
+	})
+	o := &origin{dir: dir, content: "hello", etag: `"v1"`}
+	return mgr, o, func() { os.RemoveAll(dir) }
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestManagerRevalidate(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		modified  []bool
+		expectMod []bool
+	)
+	mgr, o, cleanup := newRevalidateManager(t, 1000, Hooks{
+		OnRevalidate: func(item cache.Item, m bool) {
+			mu.Lock()
+			modified = append(modified, m)
+			mu.Unlock()
+		},
+	})
+	defer cleanup()
+	key := filepath.Join(o.dir, "object")
+	fresh := RevalidateOptions{MaxAge: time.Hour}
+	stale := RevalidateOptions{}
+
+	testcases := []struct {
+		description   string
+		opts          RevalidateOptions
+		change        string
+		expectContent string
+		expectETag    string
+		expectCount   int
+		expectMod     []bool
+	}{
+		{"fetch on miss", fresh, "", "hello", `"v1"`, 1, []bool{true}},
+		{"fresh without revalidation", fresh, "", "hello", `"v1"`, 1, nil},
+		{"stale but not modified", stale, "", "hello", `"v1"`, 2, []bool{false}},
+		{"stale and modified", stale, "hello, world", "hello, world", `"v2"`, 3, []bool{true}},
+		{"stale and shrunk", stale, "hi", "hi", `"v3"`, 4, []bool{true}},
+	}
+
+	for idx, tc := range testcases {
+		if tc.change != "" {
+			o.content = tc.change
+			o.etag = tc.expectETag
+		}
+		before := time.Now()
+		item, err := mgr.Revalidate(key, tc.opts, o.handler)
+		if err != nil {
+			t.Fatalf("[#Case%d] %s: %v", idx, tc.description, err)
+		}
+		expectMod = append(expectMod, tc.expectMod...)
+
+		if content := readFile(t, item.Path); content != tc.expectContent {
+			t.Errorf("[#Case%d] %s: expect content %q, but get %q", idx, tc.description, tc.expectContent, content)
+		}
+		if etag := item.Validators().ETag; etag != tc.expectETag {
+			t.Errorf("[#Case%d] %s: expect ETag %v, but get %v", idx, tc.description, tc.expectETag, etag)
+		}
+		if item.Meta(cache.MetaContentType) != "text/plain" {
+			t.Errorf("[#Case%d] %s: expect metadata kept, but get %v", idx, tc.description, item.Metadata)
+		}
+		if item.Size != int64(len(tc.expectContent)) || mgr.Usage() != item.Size {
+			t.Errorf("[#Case%d] %s: expect size and usage %d, but get %d and %d",
+				idx, tc.description, len(tc.expectContent), item.Size, mgr.Usage())
+		}
+		if item.Reference() != 0 {
+			t.Errorf("[#Case%d] %s: expect no reference, but get %d", idx, tc.description, item.Reference())
+		}
+		if o.count() != tc.expectCount {
+			t.Errorf("[#Case%d] %s: expect %d requests, but get %d", idx, tc.description, tc.expectCount, o.count())
+		}
+		if tc.expectMod != nil && item.ValidatedAt().Before(before) {
+			t.Errorf("[#Case%d] %s: expect validated after %v, but get %v", idx, tc.description, before, item.ValidatedAt())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(modified) != len(expectMod) {
+		t.Fatalf("expect revalidations %v, but get %v", expectMod, modified)
+	}
+	for i := range modified {
+		if modified[i] != expectMod[i] {
+			t.Errorf("expect revalidations %v, but get %v", expectMod, modified)
+			break
+		}
+	}
+
+	// Temporary files are all moved into place.
+	files, _ := filepath.Glob(filepath.Join(o.dir, "tmp-*"))
+	if len(files) != 0 {
+		t.Errorf("expect no temporary files left, but get %v", files)
+	}
+}
+
+func TestManagerRevalidateStale(t *testing.T) {
+	revalidated := make(chan bool, 1)
+	mgr, o, cleanup := newRevalidateManager(t, 1000, Hooks{
+		OnRevalidate: func(item cache.Item, modified bool) { revalidated <- modified },
+	})
+	defer cleanup()
+	key := filepath.Join(o.dir, "object")
+
+	if _, err := mgr.Revalidate(key, RevalidateOptions{}, o.handler); err != nil {
+		t.Fatal(err)
+	}
+	<-revalidated
+
+	// The stale cache item is returned at once, and replaced in background.
+	o.content, o.etag = "hello, world", `"v2"`
+	item, err := mgr.Revalidate(key, RevalidateOptions{StaleWhileRevalidate: time.Hour}, o.handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag := item.Validators().ETag; etag != `"v1"` {
+		t.Errorf("expect the stale cache item, but get %v", etag)
+	}
+	select {
+	case modified := <-revalidated:
+		if !modified {
+			t.Errorf("expect the cache item modified")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the cache item revalidated in background")
+	}
+	item, err = mgr.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag := item.Validators().ETag; etag != `"v2"` || readFile(t, key) != "hello, world" {
+		t.Errorf("expect the cache item replaced, but get %v", etag)
+	}
+
+	// Beyond stale while revalidate, the cache item is revalidated at once.
+	item.SetValidatedAt(time.Now().Add(-2 * time.Hour))
+	if err := mgr.Annotate(key, cache.Metadata{cache.MetaValidatedAt: item.Meta(cache.MetaValidatedAt)}); err != nil {
+		t.Fatal(err)
+	}
+	o.content, o.etag = "hi", `"v3"`
+	item, err = mgr.Revalidate(key, RevalidateOptions{StaleWhileRevalidate: time.Hour}, o.handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-revalidated
+	if etag := item.Validators().ETag; etag != `"v3"` {
+		t.Errorf("expect the cache item revalidated, but get %v", etag)
+	}
+}
+
+func TestManagerRevalidateConcurrent(t *testing.T) {
+	mgr, o, cleanup := newRevalidateManager(t, 1000, Hooks{})
+	defer cleanup()
+	key := filepath.Join(o.dir, "object")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := mgr.Revalidate(key, RevalidateOptions{MaxAge: time.Hour}, o.handler); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if o.count() != 1 {
+		t.Errorf("expect 1 request, but get %d", o.count())
+	}
+}
+
+func TestManagerRevalidateEvict(t *testing.T) {
+	testcases := []struct {
+		description string
+		policy      policy.Policy
+	}{
+		{"lru", policy.LRU()},
+		{"lru evicting referenced items", policy.LRU(policy.EvictReferenced())},
+	}
+
+	for idx, tc := range testcases {
+		mgr, o, cleanup := newRevalidateManager(t, 10, Hooks{})
+		mgr.policy = tc.policy
+		key := filepath.Join(o.dir, "object")
+		other := filepath.Join(o.dir, "other")
+
+		if _, err := mgr.Revalidate(key, RevalidateOptions{}, o.handler); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(other, []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.Set(other, 5); err != nil {
+			t.Fatal(err)
+		}
+		before, err := mgr.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The cache item is the least recently used one, but it must not be
+		// evicted for its own new content, nor be used by the replacement.
+		o.content, o.etag = "hello, go", `"v2"`
+		item, err := mgr.Revalidate(key, RevalidateOptions{}, o.handler)
+		if err != nil {
+			t.Fatalf("[#Case%d] %s: %v", idx, tc.description, err)
+		}
+		if readFile(t, item.Path) != o.content || mgr.Usage() != int64(len(o.content)) {
+			t.Errorf("[#Case%d] %s: expect the cache item replaced, but get usage %d", idx, tc.description, mgr.Usage())
+		}
+		if item.Used != before.Used || !item.LastUsed.Equal(before.LastUsed) {
+			t.Errorf("[#Case%d] %s: expect usage statistics kept, but get %d and %v",
+				idx, tc.description, item.Used, item.LastUsed)
+		}
+		if _, err := mgr.Get(other); err != cache.ErrNoSuchKey {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, cache.ErrNoSuchKey, err)
+		}
+		cleanup()
+	}
+}
+
+func TestManagerRevalidateDupKey(t *testing.T) {
+	var retries int32
+	mgr, o, cleanup := newRevalidateManager(t, 1000, Hooks{
+		OnRetry: func(key string, attempt uint, err error) { atomic.AddInt32(&retries, 1) },
+	})
+	defer cleanup()
+	key := filepath.Join(o.dir, "object")
+
+	// The key is cached by Set while the content is fetched.
+	_, err := mgr.Revalidate(key, RevalidateOptions{}, func(item cache.Item, found bool) (Revalidation, error) {
+		if err := ioutil.WriteFile(key, []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.Set(key, 4); err != nil {
+			t.Fatal(err)
+		}
+		return o.handler(item, found)
+	})
+	if errors.Cause(err) != backend.ErrDupKey {
+		t.Errorf("expect %v, but get %v", backend.ErrDupKey, err)
+	}
+	if n := atomic.LoadInt32(&retries); n != 1 {
+		t.Errorf("expect 1 attempt, but get %d", n)
+	}
+	if content := readFile(t, key); content != "mine" {
+		t.Errorf("expect the file of Set kept, but get %q", content)
+	}
+	files, _ := filepath.Glob(filepath.Join(o.dir, "tmp-*"))
+	if len(files) != 0 {
+		t.Errorf("expect temporary files discarded, but get %v", files)
+	}
+}
+
+func TestManagerRevalidateFailure(t *testing.T) {
+	mgr, o, cleanup := newRevalidateManager(t, 10, Hooks{})
+	defer cleanup()
+	key := filepath.Join(o.dir, "object")
+
+	testcases := []struct {
+		description string
+		handler     RevalidateHandler
+		expectErr   error
+	}{
+		{
+			"handler fails",
+			func(item cache.Item, found bool) (Revalidation, error) {
+				rv, _ := o.handler(item, found)
+				return rv, errMock
+			},
+			errMock,
+		},
+		{
+			"not modified but not cached",
+			func(item cache.Item, found bool) (Revalidation, error) {
+				return Revalidation{NotModified: true}, nil
+			},
+			ErrNotCached,
+		},
+		{
+			"too large",
+			func(item cache.Item, found bool) (Revalidation, error) {
+				o.content = "hello, world"
+				return o.handler(item, found)
+			},
+			ErrCacheTooLarge,
+		},
+	}
+
+	for idx, tc := range testcases {
+		_, err := mgr.Revalidate(key, RevalidateOptions{}, tc.handler)
+		if errors.Cause(err) != tc.expectErr {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, tc.expectErr, err)
+		}
+		if _, err := mgr.Get(key); err != cache.ErrNoSuchKey {
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, cache.ErrNoSuchKey, err)
+		}
+		files, _ := filepath.Glob(filepath.Join(o.dir, "*"))
+		if len(files) != 0 {
+			t.Errorf("[#Case%d] %s: expect files discarded, but get %v", idx, tc.description, files)
+		}
+	}
+}
+
+func TestManagerRevalidateReplaceFailure(t *testing.T) {
+	testcases := []struct {
+		description string
+		replaces    int
+		moveFails   bool
+		expectErr   func(err error) bool
+		expectKept  bool
+	}{
+		{"replacer fails", 0, false, func(err error) bool { return err == errMock }, true},
+		{"file fails to be moved", 100, true, os.IsNotExist, true},
+		{"record fails to be restored", 1, true, os.IsNotExist, false},
+	}
+
+	for idx, tc := range testcases {
+		mgr, o, cleanup := newRevalidateManager(t, 1000, Hooks{})
+		key := filepath.Join(o.dir, "object")
+		if _, err := mgr.Revalidate(key, RevalidateOptions{}, o.handler); err != nil {
+			t.Fatal(err)
+		}
+		before, _ := mgr.Get(key)
+		mgr.pool = &failingReplacer{Pool: mgr.pool, replaces: tc.replaces}
+
+		o.content, o.etag = "hello, go", `"v2"`
+		_, err := mgr.Revalidate(key, RevalidateOptions{}, func(item cache.Item, found bool) (Revalidation, error) {
+			rv, err := o.handler(item, found)
+			if tc.moveFails {
+				os.Remove(rv.File)
+			}
+			return rv, err
+		})
+		if !tc.expectErr(errors.Cause(err)) {
+			t.Errorf("[#Case%d] %s: expect an error, but get %v", idx, tc.description, err)
+		}
+
+		// The cache item is either kept as it was, or removed along with
+		// its file, and the usage follows it.
+		item, err := mgr.Get(key)
+		switch {
+		case tc.expectKept:
+			if err != nil || item.Size != before.Size || item.Validators() != before.Validators() ||
+				!reflect.DeepEqual(item.Metadata, before.Metadata) {
+				t.Errorf("[#Case%d] %s: expect %v kept, but get %v, %v", idx, tc.description, before, item, err)
+			}
+			if content := readFile(t, key); content != "hello" {
+				t.Errorf("[#Case%d] %s: expect the old content kept, but get %q", idx, tc.description, content)
+			}
+		case err != cache.ErrNoSuchKey:
+			t.Errorf("[#Case%d] %s: expect %v, but get %v", idx, tc.description, cache.ErrNoSuchKey, err)
+		}
+		var expectUsage int64
+		if tc.expectKept {
+			expectUsage = before.Size
+		}
+		if mgr.Usage() != expectUsage {
+			t.Errorf("[#Case%d] %s: expect usage %d, but get %d", idx, tc.description, expectUsage, mgr.Usage())
+		}
+		cleanup()
+	}
+}
+
+// failingReplacer fails to replace cache items once it has replaced the
+// given number of them.
+type failingReplacer struct {
+	cache.Pool
+	replaces int
+}
+
+func (p *failingReplacer) Replace(key string, size int64, md cache.Metadata) error {
+	if p.replaces <= 0 {
+		return errMock
+	}
+	p.replaces--
+	return p.Pool.(cache.Replacer).Replace(key, size, md)
+}
@@ -0,0 +1,277 @@
+package fcache
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+
+	"github.com/meowdada/go-fcache/cache"
+)
+
+// Stats is a snapshot of the states of a cache manager.
+type Stats struct {
+	// Capacity is the capacity of the cache volume.
+	Capacity int64
+
+	// Used is the used space of the cache volume.
+	Used int64
+
+	// Free is the free space of the cache volume.
+	Free int64
+
+	// RealItems is the number of real cache items.
+	RealItems int
+
+	// PseudoItems is the number of psudo cache items, which are registered
+	// but not present so far.
+	PseudoItems int
+
+	// ReferencedItems is the number of cache items with at least one reference.
+	ReferencedItems int
+
+	// ReferencedBytes is the total size of referenced cache items.
+	ReferencedBytes int64
+
+	// Hits is the number of cache hits since the manager started.
+	Hits uint64
+
+	// Misses is the number of cache misses since the manager started.
+	Misses uint64
+
+	// Evictions is the number of evicted cache items since the manager started.
+	Evictions uint64
+
+	// Oldest is the creation time of the oldest real cache item.
+	Oldest time.Time
+
+	// Newest is the creation time of the newest real cache item.
+	Newest time.Time
+}
+
+// Stats returns a snapshot of the states of the cache manager. The first call
+// scans the cache pool once, after that the snapshot is maintained along with
+// every modification made through the manager, so no scan is required unless
+// the backend fails in the middle of a modification.
+func (mgr *Manager) Stats() (stats Stats, err error) {
+	var seeded bool
+	mgr.rlockFn(func() {
+		seeded = mgr.tally.seeded
+		stats = mgr.stats()
+	})
+
+	// The tally has never been seeded or it has been discarded, recount it
+	// with the write lock held to prevent any modification.
+	if !seeded {
+		mgr.lockFn(func() {
+			if !mgr.tally.seeded {
+				err = mgr.tally.seed(mgr.pool)
+			}
+			stats = mgr.stats()
+		})
+		if err != nil {
+			return Stats{}, err
+		}
+	}
+
+	stats.Hits = atomic.LoadUint64(&mgr.counters.hits)
+	stats.Misses = atomic.LoadUint64(&mgr.counters.misses)
+	stats.Evictions = atomic.LoadUint64(&mgr.counters.evictions)
+	return stats, nil
+}
+
+func (mgr *Manager) stats() Stats {
+	t := &mgr.tally
+	return Stats{
+		Capacity:        mgr.cap,
+		Used:            mgr.usage,
+		Free:            mgr.cap - mgr.usage,
+		RealItems:       t.real,
+		PseudoItems:     t.pseudo,
+		ReferencedItems: t.refItems,
+		ReferencedBytes: t.refBytes,
+		Oldest:          t.ctimes.oldest(),
+		Newest:          t.ctimes.newest(),
+	}
+}
+
+// counters are accessed atomically, keep it as the first field of Manager
+// to guarantee 64-bit alignment.
+type counters struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// tally counts the cache items in the cache pool incrementally. Any change
+// of a cache item is recorded by a sub of the old one and an add of the new
+// one. It does nothing until it has been seeded.
+type tally struct {
+	seeded   bool
+	real     int
+	pseudo   int
+	refItems int
+	refBytes int64
+	ctimes   ctimes
+}
+
+// seed recounts the tally by scanning the cache pool.
+func (t *tally) seed(pool cache.Pool) error {
+	*t = tally{seeded: true}
+	err := pool.Iter(func(k string, v cache.Item) error {
+		v.Key = k
+		t.add(v)
+		return nil
+	})
+	if err != nil {
+		t.reset()
+	}
+	return err
+}
+
+// reset discards the tally, it will be seeded again on demand.
+func (t *tally) reset() {
+	*t = tally{}
+}
+
+func (t *tally) add(item cache.Item) {
+	if !t.seeded || item.IsZero() {
+		return
+	}
+	if item.Reference() > 0 {
+		t.refItems++
+		t.refBytes += item.Size
+	}
+	if !item.IsReal() {
+		t.pseudo++
+		return
+	}
+	t.real++
+	t.ctimes.set(item.Key, item.CTime())
+}
+
+func (t *tally) sub(item cache.Item) {
+	if !t.seeded || item.IsZero() {
+		return
+	}
+	if item.Reference() > 0 {
+		t.refItems--
+		t.refBytes -= item.Size
+	}
+	if !item.IsReal() {
+		t.pseudo--
+		return
+	}
+	t.real--
+	t.ctimes.remove(item.Key)
+}
+
+func (t *tally) replace(old, new cache.Item) {
+	t.sub(old)
+	t.add(new)
+}
+
+// ctimes keeps creation times of real cache items by their keys in a min-heap
+// and a max-heap, so the oldest and newest ones are known at any time, and
+// each change costs O(log n).
+type ctimes struct {
+	entries map[string]*ctimeEntry
+	heaps   [2]ctimeHeap
+}
+
+type ctimeEntry struct {
+	ctime time.Time
+
+	// index is the index of the entry in each heap.
+	index [2]int
+}
+
+func (c *ctimes) set(key string, ctime time.Time) {
+	if e, ok := c.entries[key]; ok {
+		e.ctime = ctime
+		for i := range c.heaps {
+			heap.Fix(&c.heaps[i], e.index[i])
+		}
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*ctimeEntry)
+		c.heaps[1].max = true
+	}
+	e := &ctimeEntry{ctime: ctime}
+	c.entries[key] = e
+	for i := range c.heaps {
+		heap.Push(&c.heaps[i], e)
+	}
+}
+
+func (c *ctimes) remove(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	for i := range c.heaps {
+		heap.Remove(&c.heaps[i], e.index[i])
+	}
+}
+
+func (c *ctimes) oldest() time.Time {
+	return c.heaps[0].top()
+}
+
+func (c *ctimes) newest() time.Time {
+	return c.heaps[1].top()
+}
+
+// ctimeHeap implements heap.Interface, which is a min-heap of creation times
+// or a max-heap if max is true. Entries are in two heaps at the same time,
+// so each heap maintains its own index of them.
+type ctimeHeap struct {
+	entries []*ctimeEntry
+	max     bool
+}
+
+func (h *ctimeHeap) slot() int {
+	if h.max {
+		return 1
+	}
+	return 0
+}
+
+func (h *ctimeHeap) top() time.Time {
+	if len(h.entries) == 0 {
+		return time.Time{}
+	}
+	return h.entries[0].ctime
+}
+
+func (h *ctimeHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *ctimeHeap) Less(i, j int) bool {
+	if h.max {
+		return h.entries[i].ctime.After(h.entries[j].ctime)
+	}
+	return h.entries[i].ctime.Before(h.entries[j].ctime)
+}
+
+func (h *ctimeHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index[h.slot()] = i
+	h.entries[j].index[h.slot()] = j
+}
+
+func (h *ctimeHeap) Push(x interface{}) {
+	e := x.(*ctimeEntry)
+	e.index[h.slot()] = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *ctimeHeap) Pop() interface{} {
+	n := len(h.entries) - 1
+	e := h.entries[n]
+	h.entries[n] = nil
+	h.entries = h.entries[:n]
+	return e
+}
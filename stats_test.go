@@ -0,0 +1,210 @@
+package fcache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/meowdada/go-fcache/backend"
+	"github.com/meowdada/go-fcache/backend/gomap"
+	"github.com/meowdada/go-fcache/cache"
+	"github.com/meowdada/go-fcache/codec"
+	"github.com/meowdada/go-fcache/policy"
+)
+
+func TestManagerStats(t *testing.T) {
+	m := New(Options{
+		Capacity:    1000,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		RetryOptions: []retry.Option{
+			retry.MaxDelay(time.Millisecond),
+			retry.Attempts(10),
+			retry.LastErrorOnly(true),
+		},
+	})
+
+	for _, key := range []string{"stats-1", "stats-3"} {
+		err := ioutil.WriteFile(key, nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(key)
+	}
+
+	// Populate some items before the tally is seeded.
+	if err := m.Set("stats-1", 300); err != nil {
+		t.Fatal(err)
+	}
+	m.Register("pseudo")
+	m.Get("stats-1")
+	m.Get("missing")
+
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Stats{
+		Capacity:        1000,
+		Used:            300,
+		Free:            700,
+		RealItems:       1,
+		PseudoItems:     1,
+		ReferencedItems: 1,
+		ReferencedBytes: 0,
+		Hits:            1,
+		Misses:          1,
+	}
+	if !equalStats(stats, expect) {
+		t.Errorf("expect %+v, but get %+v", expect, stats)
+	}
+	first := stats.Oldest
+	if first.IsZero() || !first.Equal(stats.Newest) {
+		t.Errorf("expect oldest equal to newest, but get %v and %v", stats.Oldest, stats.Newest)
+	}
+
+	// Modifications after seeding are maintained incrementally.
+	time.Sleep(time.Millisecond)
+	if err := m.Set("pseudo", 200); err != nil {
+		t.Fatal(err)
+	}
+	m.Register("stats-1")
+	if err := m.Set("stats-3", 500); err != nil {
+		t.Fatal(err)
+	}
+	if !m.tally.seeded {
+		t.Errorf("expect the tally to be maintained")
+	}
+
+	stats, err = m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = Stats{
+		Capacity:        1000,
+		Used:            1000,
+		Free:            0,
+		RealItems:       3,
+		PseudoItems:     0,
+		ReferencedItems: 2,
+		ReferencedBytes: 500,
+		Hits:            1,
+		Misses:          1,
+	}
+	if !equalStats(stats, expect) {
+		t.Errorf("expect %+v, but get %+v", expect, stats)
+	}
+	if !stats.Oldest.Equal(first) || !stats.Newest.After(first) {
+		t.Errorf("unexpect oldest %v and newest %v", stats.Oldest, stats.Newest)
+	}
+
+	// Evicting the newest one, which has never been used, keeps the bounds
+	// without recounting.
+	m.Unregister("stats-1", "pseudo")
+	if err := m.Set("stats-4", 100); err != nil {
+		t.Fatal(err)
+	}
+	if !m.tally.seeded {
+		t.Errorf("expect the tally to be maintained")
+	}
+	newest, err := m.pool.Get("stats-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = Stats{
+		Capacity:        1000,
+		Used:            600,
+		Free:            400,
+		RealItems:       3,
+		PseudoItems:     0,
+		ReferencedItems: 0,
+		ReferencedBytes: 0,
+		Hits:            1,
+		Misses:          1,
+		Evictions:       1,
+	}
+	if !equalStats(stats, expect) {
+		t.Errorf("expect %+v, but get %+v", expect, stats)
+	}
+	if !stats.Oldest.Equal(first) || !stats.Newest.Equal(newest.CTime()) {
+		t.Errorf("unexpect oldest %v and newest %v", stats.Oldest, stats.Newest)
+	}
+}
+
+func TestManagerStatsError(t *testing.T) {
+	m := New(Options{
+		Capacity: 1000,
+		Codec:    codec.Gob{},
+		Backend: backend.Mock{
+			IterHandler: func(func(k, v []byte) error) error { return errMock },
+		},
+		CachePolicy:  policy.LRU(),
+		RetryOptions: nil,
+	})
+
+	_, err := m.Stats()
+	if err != errMock {
+		t.Errorf("expect %v, but get %v", errMock, err)
+	}
+	if m.tally.seeded {
+		t.Errorf("expect the tally not to be seeded")
+	}
+}
+
+func TestManagerStatsExpire(t *testing.T) {
+	expired := make(chan string, 2)
+	m := New(Options{
+		Capacity:    1000,
+		Codec:       codec.Gob{},
+		Backend:     gomap.New(),
+		CachePolicy: policy.LRU(),
+		PseudoTTL:   time.Millisecond,
+		Hooks: Hooks{
+			OnExpire: func(item cache.Item) { expired <- item.Key },
+		},
+	})
+
+	// Seed the tally before the pseudo cache items are registered, so they
+	// are counted incrementally.
+	if _, err := m.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	m.Register("123", "456")
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PseudoItems != 2 || stats.ReferencedItems != 2 {
+		t.Fatalf("expect 2 pseudo and referenced items, but get %+v", stats)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-expired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expect pseudo cache items expired")
+		}
+	}
+	stats, err = m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Stats{Capacity: 1000, Free: 1000}
+	if !equalStats(stats, expect) {
+		t.Errorf("expect %+v, but get %+v", expect, stats)
+	}
+}
+
+func equalStats(a, b Stats) bool {
+	a.Oldest, a.Newest = time.Time{}, time.Time{}
+	b.Oldest, b.Newest = time.Time{}, time.Time{}
+	return a == b
+}